@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProviderFailureThreshold = 3
+	defaultProviderCooldown         = 5 * time.Minute
+)
+
+// errCircuitOpen is returned instead of querying a provider that is out of
+// rotation after failing repeatedly.
+var errCircuitOpen = errors.New("provider skipped after repeated failures")
+
+// breakerProvider is a circuit breaker around an IPProvider. After threshold
+// consecutive failures the circuit opens and the provider is skipped for
+// cooldown; the first request after that is a trial, closing the circuit
+// again if it succeeds and reopening it if it fails.
+type breakerProvider struct {
+	IPProvider
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreakerProvider(provider IPProvider, threshold int, cooldown time.Duration) *breakerProvider {
+	return &breakerProvider{IPProvider: provider, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *breakerProvider) String() string {
+	return fmt.Sprint(b.IPProvider)
+}
+
+func (b *breakerProvider) Fetch(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	if b.now().Before(b.openUntil) {
+		b.mu.Unlock()
+		return "", errCircuitOpen
+	}
+	b.mu.Unlock()
+
+	ip, err := b.IPProvider.Fetch(ctx)
+	if ctx.Err() != nil {
+		// Cancellation says nothing about the provider's health.
+		return ip, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.failures >= b.threshold {
+			slog.Info("Provider recovered, returning it to rotation", "provider", b.String())
+		}
+		b.failures = 0
+		return ip, nil
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+		slog.Warn("Provider keeps failing, taking it out of rotation",
+			"provider", b.String(), "failures", b.failures, "cooldown", b.cooldown.String())
+	}
+	return "", err
+}
+
+// withBreakers wraps each provider in a circuit breaker, or returns them
+// unchanged if threshold is zero.
+func withBreakers(providers []IPProvider, threshold int, cooldown time.Duration) []IPProvider {
+	if threshold == 0 {
+		return providers
+	}
+	wrapped := make([]IPProvider, len(providers))
+	for i, provider := range providers {
+		wrapped[i] = newBreakerProvider(provider, threshold, cooldown)
+	}
+	return wrapped
+}
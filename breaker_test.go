@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingProvider is a fakeProvider that records how often it was asked.
+type countingProvider struct {
+	fakeProvider
+	calls int
+}
+
+func (p *countingProvider) Fetch(ctx context.Context) (string, error) {
+	p.calls++
+	return p.fakeProvider.Fetch(ctx)
+}
+
+func TestBreakerProvider(t *testing.T) {
+	now := time.Unix(0, 0)
+	inner := &countingProvider{fakeProvider: fakeProvider{err: errors.New("boom")}}
+	breaker := newBreakerProvider(inner, 2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := breaker.Fetch(context.Background()); err == nil || errors.Is(err, errCircuitOpen) {
+			t.Fatalf("failure %d: err = %v, want the provider's error", i+1, err)
+		}
+	}
+
+	if _, err := breaker.Fetch(context.Background()); !errors.Is(err, errCircuitOpen) {
+		t.Fatalf("err = %v after reaching the threshold, want errCircuitOpen", err)
+	}
+	if inner.calls != 2 {
+		t.Fatalf("provider called %d times, want it skipped while open", inner.calls)
+	}
+
+	// After the cooldown a trial request goes through; failing, it reopens
+	// the circuit straight away.
+	now = now.Add(time.Minute)
+	breaker.Fetch(context.Background())
+	if _, err := breaker.Fetch(context.Background()); !errors.Is(err, errCircuitOpen) || inner.calls != 3 {
+		t.Fatalf("err = %v with %d calls, want a single trial then errCircuitOpen", err, inner.calls)
+	}
+
+	now = now.Add(time.Minute)
+	inner.ip, inner.err = "1.1.1.1", nil
+	if ip, err := breaker.Fetch(context.Background()); err != nil || ip != "1.1.1.1" {
+		t.Fatalf("Fetch = %q, %v after recovery", ip, err)
+	}
+	inner.ip, inner.err = "", errors.New("boom")
+	if _, err := breaker.Fetch(context.Background()); errors.Is(err, errCircuitOpen) {
+		t.Fatal("a single failure after recovery opened the circuit")
+	}
+}
+
+func TestBreakerFallsBackToNextProvider(t *testing.T) {
+	dead := &countingProvider{fakeProvider: fakeProvider{err: errors.New("boom")}}
+	providers := withBreakers([]IPProvider{dead, &fakeProvider{ip: "1.1.1.1"}}, 1, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		result, err := getCurrentIP(context.Background(), providers, ipVersion4, true)
+		if err != nil || result.IP != "1.1.1.1" {
+			t.Fatalf("getCurrentIP = %+v, %v", result, err)
+		}
+	}
+	if dead.calls != 1 {
+		t.Errorf("failing provider called %d times, want 1", dead.calls)
+	}
+}
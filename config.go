@@ -0,0 +1,913 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultRetryInterval = 5 * time.Second
+	defaultHTTPTimeout   = 10 * time.Second
+	defaultFetchTimeout  = 30 * time.Second
+	defaultMaxBackoff    = 10 * time.Minute
+	defaultEnvBackupKeep = 10
+	defaultConfirmCount  = 2
+	defaultHistoryRows   = 1000
+	defaultMaxErrors     = 5
+
+	defaultMinRestartInterval = 5 * time.Minute
+)
+
+type Config struct {
+	CheckInterval time.Duration `yaml:"check_interval"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// StartupJitter delays the first check by a random duration up to its
+	// value, and CheckIntervalJitter adds one to every CheckInterval, so a
+	// fleet redeployed at once doesn't poll the providers in lockstep.
+	StartupJitter       time.Duration `yaml:"startup_jitter"`
+	CheckIntervalJitter time.Duration `yaml:"check_interval_jitter"`
+	MaxBackoff          time.Duration `yaml:"max_backoff"`
+	HTTPTimeout         time.Duration `yaml:"http_timeout"`
+	// FetchTimeout bounds a whole detection, across every provider tried,
+	// while HTTPTimeout bounds each individual request.
+	FetchTimeout time.Duration `yaml:"fetch_timeout"`
+	// HTTPUserAgent is sent with every request to an IP provider.
+	HTTPUserAgent string `yaml:"http_user_agent"`
+	// MaxConsecutiveErrors is how many failed IP fetches in a row trigger a
+	// warning or, with ExitOnMaxErrors, a non-zero exit so the supervisor
+	// can restart the process.
+	MaxConsecutiveErrors int  `yaml:"max_consecutive_errors"`
+	ExitOnMaxErrors      bool `yaml:"exit_on_max_errors"`
+	// MaxRuntime makes the process exit cleanly once it has run this long,
+	// for a supervisor to start it afresh; zero means no limit.
+	MaxRuntime time.Duration `yaml:"max_runtime"`
+	// ConfirmCount is how many consecutive identical readings of a new IP
+	// are needed before it is treated as a change.
+	ConfirmCount int `yaml:"confirm_count"`
+	// FlapThreshold is how many times within FlapWindow the IP may revert
+	// to its previous value after a few readings before it is considered
+	// flapping and changes are no longer acted upon; zero disables this.
+	FlapThreshold int           `yaml:"flap_threshold"`
+	FlapWindow    time.Duration `yaml:"flap_window"`
+	// Providers and IPv6Providers are HTTP endpoints or, prefixed with
+	// "stun:", STUN servers. IP_PROVIDER=stun replaces both with STUN_SERVER.
+	// Providers may also name aws-metadata or gcp-metadata, which read the
+	// public IPv4 address from the cloud instance metadata service, as
+	// IP_PROVIDER=aws-metadata or gcp-metadata does.
+	Providers     []string `yaml:"ip_providers"`
+	IPv6Providers []string `yaml:"ip_providers_v6"`
+	IPVersion     string   `yaml:"ip_version"`
+	// ProviderQuorum, if non-zero, requires that many providers to report
+	// the same IP before it is accepted.
+	ProviderQuorum int `yaml:"ip_provider_quorum"`
+	// ProviderFailureThreshold is how many consecutive failures take a
+	// provider out of rotation for ProviderCooldown; zero disables this.
+	ProviderFailureThreshold int           `yaml:"provider_failure_threshold"`
+	ProviderCooldown         time.Duration `yaml:"provider_cooldown"`
+	// IPChangeMask and IPChangeMaskV6 are prefix lengths such as "/24"; a
+	// new IP inside the stored IP's network of that size is not treated as
+	// a change. Unset, any difference is a change.
+	IPChangeMask   string `yaml:"ip_change_mask"`
+	IPChangeMaskV6 string `yaml:"ip_change_mask_v6"`
+	// IPJSONField is the key holding the address in JSON provider
+	// responses, for self-hosted endpoints that don't use "ip".
+	IPJSONField string `yaml:"ip_json_field"`
+	// SourceInterface and SourceIP pin the local address provider requests
+	// are sent from, for hosts with several uplinks. At most one may be set.
+	SourceInterface string `yaml:"source_interface"`
+	SourceIP        string `yaml:"source_ip"`
+	// IPFetchProxy routes provider requests through an http(s):// or
+	// socks5:// proxy instead of HTTP_PROXY/HTTPS_PROXY. The detected IP is
+	// then the proxy's, so it must egress from the node's own address.
+	IPFetchProxy string `yaml:"ip_fetch_proxy"`
+	// IPFetchHeaders are added to every HTTP provider request, e.g. an API
+	// key for a self-hosted endpoint. IP_FETCH_HEADERS sets them as
+	// "Name: value" pairs separated by semicolons.
+	IPFetchHeaders map[string]string `yaml:"ip_fetch_headers"`
+	// IPTLSInsecure disables certificate verification for IP providers.
+	// IPTLSPinnedSHA256 additionally requires their leaf certificate to
+	// have this SHA-256 fingerprint, so it suits a single pinned provider.
+	IPTLSInsecure     bool   `yaml:"ip_tls_insecure"`
+	IPTLSPinnedSHA256 string `yaml:"ip_tls_pinned_sha256"`
+
+	AllowPrivateIP bool `yaml:"allow_private_ip"`
+	// EnvFile is the path of the .env file Charon reads.
+	EnvFile string `yaml:"env_file"`
+	// EnvKeys are the .env variables set to the IP; .env is in sync only
+	// when all of them hold it.
+	EnvKeys []string `yaml:"env_keys"`
+	// IPv6Brackets writes IPv6 addresses to .env as "[addr]".
+	IPv6Brackets bool `yaml:"env_ipv6_brackets"`
+
+	// EnvBackupDir is where .env backups are written; empty means next to
+	// the .env file itself.
+	EnvBackupDir  string `yaml:"env_backup_dir"`
+	EnvBackupKeep int    `yaml:"env_backup_keep"`
+
+	// DBDriver selects the storage backend: sqlite, at DBPath, or
+	// postgres, at DatabaseURL.
+	DBDriver    string `yaml:"db_driver"`
+	DBPath      string `yaml:"db_path"`
+	DatabaseURL string `yaml:"database_url"`
+	// SQLiteJournalMode, SQLiteSynchronous and SQLiteBusyTimeout set the
+	// journal_mode, synchronous and busy_timeout pragmas of the SQLite
+	// database.
+	SQLiteJournalMode string        `yaml:"sqlite_journal_mode"`
+	SQLiteSynchronous string        `yaml:"sqlite_synchronous"`
+	SQLiteBusyTimeout time.Duration `yaml:"sqlite_busy_timeout"`
+	// HAEnable elects a leader among updaters sharing a Postgres database;
+	// only the leader applies updates while the others keep checking.
+	// InstanceID names this updater in the lease and defaults to the
+	// hostname.
+	HAEnable        bool          `yaml:"ha_enable"`
+	InstanceID      string        `yaml:"instance_id"`
+	HALeaseDuration time.Duration `yaml:"ha_lease_duration"`
+
+	// HistoryMaxRows and HistoryMaxAge bound the ip_store table; zero
+	// disables the respective limit.
+	HistoryMaxRows int           `yaml:"history_max_rows"`
+	HistoryMaxAge  time.Duration `yaml:"history_max_age"`
+
+	// RestartBackend selects how Charon is restarted: compose, docker,
+	// podman, docker-api, kubernetes, nomad or command. It defaults to command
+	// when RestartCommand is set.
+	RestartBackend        string   `yaml:"restart_backend"`
+	RestartCommand        []string `yaml:"restart_command"`
+	RestartContainer      string   `yaml:"restart_container"`
+	RestartContainerLabel string   `yaml:"restart_container_label"`
+	ComposeFile           string   `yaml:"compose_file"`
+	// ComposeServices are recreated together by the compose backend.
+	ComposeServices []string `yaml:"compose_services"`
+	// K8SDeployment is rolled by the kubernetes backend, in K8SNamespace
+	// or else the updater's own namespace.
+	K8SNamespace  string `yaml:"k8s_namespace"`
+	K8SDeployment string `yaml:"k8s_deployment"`
+	// NomadJob is redeployed by the nomad backend through the API at
+	// NomadAddr, authenticating with NomadToken if set.
+	NomadAddr  string `yaml:"nomad_addr"`
+	NomadToken string `yaml:"nomad_token"`
+	NomadJob   string `yaml:"nomad_job"`
+	// MinRestartInterval defers an update that would restart Charon less
+	// than this long after the last restart; zero disables the guard.
+	MinRestartInterval time.Duration `yaml:"min_restart_interval"`
+	// RestartRetries is how many more times a failed restart is attempted,
+	// backing off exponentially from RestartRetryBackoff. Failures that
+	// retrying cannot fix, such as a missing service, are not retried.
+	RestartRetries      int           `yaml:"restart_retries"`
+	RestartRetryBackoff time.Duration `yaml:"restart_retry_backoff"`
+	// RestartEnabled set to false still updates .env and the database on
+	// an IP change but leaves restarting Charon to the operator.
+	RestartEnabled bool `yaml:"restart_enabled"`
+
+	// CheckReachability enables an advisory check that P2PPort is
+	// reachable on the public IP whenever it changes. RequireReachable
+	// makes it strict: an update to an unreachable IP is not applied, and
+	// is retried on the next check. It implies CheckReachability.
+	CheckReachability bool `yaml:"check_reachability"`
+	RequireReachable  bool `yaml:"require_reachable"`
+	P2PPort           int  `yaml:"charon_p2p_port"`
+	// CharonENRURL, if set, is Charon's monitoring API endpoint serving its
+	// ENR, such as http://charon:3620/enr. The IP the ENR advertises then
+	// stands in for the one in .env when deciding whether to restart, as
+	// the running Charon may not have picked up .env.
+	CharonENRURL string `yaml:"charon_enr_url"`
+
+	// PreUpdateHook and PostUpdateHook are executables run before an
+	// update is applied and after it succeeded; a failing pre-update hook
+	// aborts the update.
+	PreUpdateHook  string `yaml:"pre_update_hook"`
+	PostUpdateHook string `yaml:"post_update_hook"`
+
+	// HealthCheck enables polling HealthCheckURL after a restart and rolling
+	// back .env if Charon does not become healthy within HealthCheckTimeout.
+	HealthCheck        bool          `yaml:"health_check"`
+	HealthCheckURL     string        `yaml:"health_check_url"`
+	HealthCheckTimeout time.Duration `yaml:"health_check_timeout"`
+
+	// MetricsAddr is the listen address of the Prometheus endpoint; an
+	// explicitly empty METRICS_ADDR disables it.
+	MetricsAddr string `yaml:"metrics_addr"`
+	// HealthAddr is the listen address of the /healthz liveness endpoint;
+	// it is disabled when empty.
+	HealthAddr string `yaml:"health_addr"`
+	// APIAddr is the listen address of the /ip, /events and /status API;
+	// it is disabled when empty.
+	APIAddr string `yaml:"api_addr"`
+	// GRPCAddr is the listen address of the gRPC control API; it is
+	// disabled when empty.
+	GRPCAddr string `yaml:"grpc_addr"`
+	// ControlSocket is the path of a Unix socket serving the sync status
+	// as JSON; it is disabled when empty.
+	ControlSocket string `yaml:"control_socket"`
+
+	// NotifyWebhookURL receives a JSON POST whenever the IP changes, shaped
+	// for NotifyType: generic, slack or discord.
+	NotifyWebhookURL string `yaml:"notify_webhook_url"`
+	NotifyType       string `yaml:"notify_type"`
+	// NotifyMinInterval, if set, holds back a notification sent within
+	// this long of the last one of its kind, coalescing bursts into a
+	// single flapping summary.
+	NotifyMinInterval time.Duration `yaml:"notify_min_interval"`
+	// SMTPHost, if set, emails the same notifications from SMTPFrom to
+	// every SMTPTo address, logging in as SMTPUser if one is given.
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"`
+	SMTPUser string   `yaml:"smtp_user"`
+	SMTPPass string   `yaml:"smtp_pass"`
+	SMTPFrom string   `yaml:"smtp_from"`
+	SMTPTo   []string `yaml:"smtp_to"`
+
+	// UpdateMode selects what is changed on an IP change: env (rewrite .env
+	// and restart Charon), dns (update the DDNSProvider record only) or
+	// both. It defaults to both when a DDNS provider is configured. If .env
+	// holds a hostname rather than an IP, it is never rewritten: the
+	// hostname is resolved and the DDNS record updated when it is stale.
+	UpdateMode         string `yaml:"update_mode"`
+	DDNSProvider       string `yaml:"ddns_provider"`
+	DDNSHostname       string `yaml:"ddns_hostname"`
+	DuckDNSToken       string `yaml:"duckdns_token"`
+	CloudflareAPIToken string `yaml:"cloudflare_api_token"`
+	CloudflareZoneID   string `yaml:"cloudflare_zone_id"`
+}
+
+// withinChangeMask reports whether newIP is in oldIP's network as sized by
+// IPChangeMask or IPChangeMaskV6, and so not a significant change.
+func (c *Config) withinChangeMask(oldIP, newIP string) bool {
+	mask4, _ := parsePrefixLength(c.IPChangeMask, 32)
+	mask6, _ := parsePrefixLength(c.IPChangeMaskV6, 128)
+	if mask4 == 0 && mask6 == 0 {
+		return false
+	}
+	return sameNetwork(oldIP, newIP, mask4, mask6)
+}
+
+// updatesEnv reports whether IP changes are written to .env.
+func (c *Config) updatesEnv() bool {
+	return c.UpdateMode != updateModeDNS
+}
+
+// sqliteOptions returns the pragmas initDB applies to the SQLite database.
+func (c *Config) sqliteOptions() sqliteOptions {
+	return sqliteOptions{
+		journalMode: c.SQLiteJournalMode,
+		synchronous: c.SQLiteSynchronous,
+		busyTimeout: c.SQLiteBusyTimeout,
+	}
+}
+
+// defaultConfig returns the configuration used when nothing is overridden.
+func defaultConfig() *Config {
+	return &Config{
+		CheckInterval:            defaultCheckInterval,
+		RetryInterval:            defaultRetryInterval,
+		MaxBackoff:               defaultMaxBackoff,
+		HTTPTimeout:              defaultHTTPTimeout,
+		FetchTimeout:             defaultFetchTimeout,
+		HTTPUserAgent:            "obol-ip-updater/" + version,
+		ConfirmCount:             defaultConfirmCount,
+		FlapThreshold:            defaultFlapThreshold,
+		FlapWindow:               defaultFlapWindow,
+		MaxConsecutiveErrors:     defaultMaxErrors,
+		Providers:                defaultProviders,
+		IPv6Providers:            defaultIPv6Providers,
+		ProviderFailureThreshold: defaultProviderFailureThreshold,
+		ProviderCooldown:         defaultProviderCooldown,
+		IPVersion:                ipVersion4,
+		IPJSONField:              defaultIPJSONField,
+		EnvFile:                  defaultEnvFile,
+		EnvKeys:                  []string{envKey},
+		EnvBackupKeep:            defaultEnvBackupKeep,
+		DBDriver:                 driverSQLite,
+		DBPath:                   defaultDBPath,
+		SQLiteJournalMode:        defaultSQLiteJournalMode,
+		SQLiteSynchronous:        defaultSQLiteSynchronous,
+		SQLiteBusyTimeout:        defaultSQLiteBusyTimeout,
+		HALeaseDuration:          defaultHALeaseDuration,
+		HistoryMaxRows:           defaultHistoryRows,
+		RestartEnabled:           true,
+		RestartContainer:         defaultRestartContainer,
+		ComposeServices:          []string{defaultComposeService},
+		NomadAddr:                defaultNomadAddr,
+		MinRestartInterval:       defaultMinRestartInterval,
+		RestartRetries:           defaultRestartRetries,
+		RestartRetryBackoff:      defaultRestartRetryBackoff,
+		P2PPort:                  defaultP2PPort,
+		HealthCheckURL:           defaultHealthCheckURL,
+		HealthCheckTimeout:       defaultHealthCheckTimeout,
+		MetricsAddr:              defaultMetricsAddr,
+		NotifyType:               notifyGeneric,
+		SMTPPort:                 defaultSMTPPort,
+	}
+}
+
+// loadConfig builds the configuration from the defaults above, overridden
+// by the YAML file at path, if any, overridden in turn by the process
+// environment.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		if err := loadConfigFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadConfigFile decodes the YAML file at path over cfg. Unknown keys are
+// rejected so typos don't silently fall back to defaults.
+func loadConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with the tunables set in the process environment.
+func applyEnv(cfg *Config) error {
+	durations := []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"CHECK_INTERVAL", &cfg.CheckInterval},
+		{"RETRY_INTERVAL", &cfg.RetryInterval},
+		{"STARTUP_JITTER", &cfg.StartupJitter},
+		{"CHECK_INTERVAL_JITTER", &cfg.CheckIntervalJitter},
+		{"MAX_BACKOFF", &cfg.MaxBackoff},
+		{"HTTP_TIMEOUT", &cfg.HTTPTimeout},
+		{"FETCH_TIMEOUT", &cfg.FetchTimeout},
+		{"MAX_RUNTIME", &cfg.MaxRuntime},
+		{"PROVIDER_COOLDOWN", &cfg.ProviderCooldown},
+		{"HEALTH_CHECK_TIMEOUT", &cfg.HealthCheckTimeout},
+		{"HA_LEASE_DURATION", &cfg.HALeaseDuration},
+		{"SQLITE_BUSY_TIMEOUT", &cfg.SQLiteBusyTimeout},
+		{"FLAP_WINDOW", &cfg.FlapWindow},
+		{"RESTART_RETRY_BACKOFF", &cfg.RestartRetryBackoff},
+		{"HISTORY_MAX_AGE", &cfg.HistoryMaxAge},
+		{"NOTIFY_MIN_INTERVAL", &cfg.NotifyMinInterval},
+		{"MIN_RESTART_INTERVAL", &cfg.MinRestartInterval},
+	}
+
+	for _, d := range durations {
+		value, err := durationFromEnv(d.key, *d.dst)
+		if err != nil {
+			return err
+		}
+		*d.dst = value
+	}
+
+	ints := []struct {
+		key string
+		dst *int
+	}{
+		{"ENV_BACKUP_KEEP", &cfg.EnvBackupKeep},
+		{"HISTORY_MAX_ROWS", &cfg.HistoryMaxRows},
+		{"IP_PROVIDER_QUORUM", &cfg.ProviderQuorum},
+		{"PROVIDER_FAILURE_THRESHOLD", &cfg.ProviderFailureThreshold},
+		{"CONFIRM_COUNT", &cfg.ConfirmCount},
+		{"FLAP_THRESHOLD", &cfg.FlapThreshold},
+		{"MAX_CONSECUTIVE_ERRORS", &cfg.MaxConsecutiveErrors},
+		{"CHARON_P2P_PORT", &cfg.P2PPort},
+		{"SMTP_PORT", &cfg.SMTPPort},
+		{"RESTART_RETRIES", &cfg.RestartRetries},
+	}
+
+	for _, i := range ints {
+		value, err := intFromEnv(i.key, *i.dst)
+		if err != nil {
+			return err
+		}
+		*i.dst = value
+	}
+
+	bools := []struct {
+		key string
+		dst *bool
+	}{
+		{"ALLOW_PRIVATE_IP", &cfg.AllowPrivateIP},
+		{"IP_TLS_INSECURE", &cfg.IPTLSInsecure},
+		{"EXIT_ON_MAX_ERRORS", &cfg.ExitOnMaxErrors},
+		{"ENV_IPV6_BRACKETS", &cfg.IPv6Brackets},
+		{"CHECK_REACHABILITY", &cfg.CheckReachability},
+		{"REQUIRE_REACHABLE", &cfg.RequireReachable},
+		{"HEALTH_CHECK", &cfg.HealthCheck},
+		{"RESTART_ENABLED", &cfg.RestartEnabled},
+		{"HA_ENABLE", &cfg.HAEnable},
+	}
+
+	for _, b := range bools {
+		value, err := boolFromEnv(b.key, *b.dst)
+		if err != nil {
+			return err
+		}
+		*b.dst = value
+	}
+
+	strs := []struct {
+		key string
+		dst *string
+	}{
+		{"HTTP_USER_AGENT", &cfg.HTTPUserAgent},
+		{"IP_VERSION", &cfg.IPVersion},
+		{"IP_JSON_FIELD", &cfg.IPJSONField},
+		{"IP_CHANGE_MASK", &cfg.IPChangeMask},
+		{"IP_CHANGE_MASK_V6", &cfg.IPChangeMaskV6},
+		{"SOURCE_INTERFACE", &cfg.SourceInterface},
+		{"SOURCE_IP", &cfg.SourceIP},
+		{"IP_FETCH_PROXY", &cfg.IPFetchProxy},
+		{"IP_TLS_PINNED_SHA256", &cfg.IPTLSPinnedSHA256},
+		{"ENV_FILE", &cfg.EnvFile},
+		{"ENV_BACKUP_DIR", &cfg.EnvBackupDir},
+		{"DB_DRIVER", &cfg.DBDriver},
+		{"DB_PATH", &cfg.DBPath},
+		{"DATABASE_URL", &cfg.DatabaseURL},
+		{"SQLITE_JOURNAL_MODE", &cfg.SQLiteJournalMode},
+		{"SQLITE_SYNCHRONOUS", &cfg.SQLiteSynchronous},
+		{"INSTANCE_ID", &cfg.InstanceID},
+		{"RESTART_BACKEND", &cfg.RestartBackend},
+		{"RESTART_CONTAINER", &cfg.RestartContainer},
+		{"RESTART_CONTAINER_LABEL", &cfg.RestartContainerLabel},
+		{"COMPOSE_FILE", &cfg.ComposeFile},
+		{"K8S_NAMESPACE", &cfg.K8SNamespace},
+		{"K8S_DEPLOYMENT", &cfg.K8SDeployment},
+		{"NOMAD_ADDR", &cfg.NomadAddr},
+		{"NOMAD_TOKEN", &cfg.NomadToken},
+		{"NOMAD_JOB", &cfg.NomadJob},
+		{"PRE_UPDATE_HOOK", &cfg.PreUpdateHook},
+		{"POST_UPDATE_HOOK", &cfg.PostUpdateHook},
+		{"HEALTH_CHECK_URL", &cfg.HealthCheckURL},
+		{"CHARON_ENR_URL", &cfg.CharonENRURL},
+		{"HEALTH_ADDR", &cfg.HealthAddr},
+		{"API_ADDR", &cfg.APIAddr},
+		{"GRPC_ADDR", &cfg.GRPCAddr},
+		{"CONTROL_SOCKET", &cfg.ControlSocket},
+		{"NOTIFY_WEBHOOK_URL", &cfg.NotifyWebhookURL},
+		{"NOTIFY_TYPE", &cfg.NotifyType},
+		{"SMTP_HOST", &cfg.SMTPHost},
+		{"SMTP_USER", &cfg.SMTPUser},
+		{"SMTP_PASS", &cfg.SMTPPass},
+		{"SMTP_FROM", &cfg.SMTPFrom},
+		{"UPDATE_MODE", &cfg.UpdateMode},
+		{"DDNS_PROVIDER", &cfg.DDNSProvider},
+		{"DDNS_HOSTNAME", &cfg.DDNSHostname},
+		{"DUCKDNS_TOKEN", &cfg.DuckDNSToken},
+		{"CLOUDFLARE_API_TOKEN", &cfg.CloudflareAPIToken},
+		{"CLOUDFLARE_ZONE_ID", &cfg.CloudflareZoneID},
+	}
+
+	for _, s := range strs {
+		*s.dst = stringFromEnv(s.key, *s.dst)
+	}
+
+	// An explicitly empty METRICS_ADDR disables the endpoint.
+	if addr, ok := os.LookupEnv("METRICS_ADDR"); ok {
+		cfg.MetricsAddr = addr
+	}
+
+	// COMPOSE_SERVICE_NAME is the single-service form kept for existing
+	// setups; COMPOSE_SERVICES takes precedence.
+	if name := os.Getenv("COMPOSE_SERVICE_NAME"); name != "" {
+		cfg.ComposeServices = []string{name}
+	}
+	cfg.ComposeServices = listFromEnv("COMPOSE_SERVICES", cfg.ComposeServices)
+
+	cfg.EnvKeys = listFromEnv("ENV_KEYS", cfg.EnvKeys)
+	cfg.SMTPTo = listFromEnv("SMTP_TO", cfg.SMTPTo)
+	cfg.Providers = listFromEnv("IP_PROVIDERS", cfg.Providers)
+	cfg.IPv6Providers = listFromEnv("IP_PROVIDERS_V6", cfg.IPv6Providers)
+	switch provider := os.Getenv("IP_PROVIDER"); provider {
+	case "":
+	case "stun":
+		stun := []string{stunScheme + stringFromEnv("STUN_SERVER", defaultSTUNServer)}
+		cfg.Providers, cfg.IPv6Providers = stun, stun
+	case awsMetadataProvider, gcpMetadataProvider:
+		cfg.Providers = []string{provider}
+	default:
+		return fmt.Errorf("IP_PROVIDER must be stun, aws-metadata or gcp-metadata if set, got %q", provider)
+	}
+
+	if raw := os.Getenv("IP_FETCH_HEADERS"); raw != "" {
+		headers, err := parseHeaders(raw)
+		if err != nil {
+			return fmt.Errorf("invalid IP_FETCH_HEADERS: %v", err)
+		}
+		cfg.IPFetchHeaders = headers
+	}
+
+	if raw := os.Getenv("RESTART_COMMAND"); raw != "" {
+		args, err := splitCommand(raw)
+		if err != nil {
+			return fmt.Errorf("invalid RESTART_COMMAND: %v", err)
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("RESTART_COMMAND must not be empty")
+		}
+		cfg.RestartCommand = args
+	}
+
+	return nil
+}
+
+// validate checks the merged configuration and fills in the defaults that
+// depend on other settings.
+func (c *Config) validate() error {
+	positive := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"CHECK_INTERVAL", c.CheckInterval},
+		{"RETRY_INTERVAL", c.RetryInterval},
+		{"MAX_BACKOFF", c.MaxBackoff},
+		{"HTTP_TIMEOUT", c.HTTPTimeout},
+		{"FETCH_TIMEOUT", c.FetchTimeout},
+		{"PROVIDER_COOLDOWN", c.ProviderCooldown},
+		{"HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout},
+		{"HA_LEASE_DURATION", c.HALeaseDuration},
+		{"FLAP_WINDOW", c.FlapWindow},
+		{"RESTART_RETRY_BACKOFF", c.RestartRetryBackoff},
+	}
+	for _, d := range positive {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be a positive duration, got %v", d.key, d.value)
+		}
+	}
+	optional := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"STARTUP_JITTER", c.StartupJitter},
+		{"CHECK_INTERVAL_JITTER", c.CheckIntervalJitter},
+		{"HISTORY_MAX_AGE", c.HistoryMaxAge},
+		{"NOTIFY_MIN_INTERVAL", c.NotifyMinInterval},
+		{"MIN_RESTART_INTERVAL", c.MinRestartInterval},
+		{"MAX_RUNTIME", c.MaxRuntime},
+		{"SQLITE_BUSY_TIMEOUT", c.SQLiteBusyTimeout},
+	}
+	for _, d := range optional {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %v", d.key, d.value)
+		}
+	}
+
+	nonNegative := []struct {
+		key   string
+		value int
+	}{
+		{"ENV_BACKUP_KEEP", c.EnvBackupKeep},
+		{"HISTORY_MAX_ROWS", c.HistoryMaxRows},
+		{"IP_PROVIDER_QUORUM", c.ProviderQuorum},
+		{"PROVIDER_FAILURE_THRESHOLD", c.ProviderFailureThreshold},
+		{"FLAP_THRESHOLD", c.FlapThreshold},
+	}
+	for _, i := range nonNegative {
+		if i.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", i.key, i.value)
+		}
+	}
+
+	if c.ConfirmCount < 1 {
+		return fmt.Errorf("CONFIRM_COUNT must be at least 1")
+	}
+	if c.MaxConsecutiveErrors < 1 {
+		return fmt.Errorf("MAX_CONSECUTIVE_ERRORS must be at least 1")
+	}
+	if c.RestartRetries < 0 || c.RestartRetries > maxRestartRetries {
+		return fmt.Errorf("RESTART_RETRIES must be between 0 and %d, got %d", maxRestartRetries, c.RestartRetries)
+	}
+	if c.P2PPort < 1 || c.P2PPort > 65535 {
+		return fmt.Errorf("CHARON_P2P_PORT must be between 1 and 65535, got %d", c.P2PPort)
+	}
+	if len(c.EnvKeys) == 0 {
+		return fmt.Errorf("at least one ENV_KEYS entry is required")
+	}
+	if c.EnvFile == "" {
+		return fmt.Errorf("ENV_FILE must not be empty")
+	}
+	if len(c.Providers) == 0 && c.IPVersion != ipVersion6 {
+		return fmt.Errorf("at least one IPv4 provider is required")
+	}
+	if len(c.IPv6Providers) == 0 && c.IPVersion != ipVersion4 {
+		return fmt.Errorf("at least one IPv6 provider is required")
+	}
+	for _, provider := range c.IPv6Providers {
+		if isMetadataProvider(provider) {
+			return fmt.Errorf("%s only reports IPv4 addresses and cannot be an IPv6 provider", provider)
+		}
+	}
+
+	switch c.IPVersion {
+	case ipVersion4, ipVersion6, ipVersionDual:
+	default:
+		return fmt.Errorf("IP_VERSION must be one of 4, 6 or dual, got %q", c.IPVersion)
+	}
+
+	masks := []struct {
+		key   string
+		value string
+		bits  int
+	}{
+		{"IP_CHANGE_MASK", c.IPChangeMask, 32},
+		{"IP_CHANGE_MASK_V6", c.IPChangeMaskV6, 128},
+	}
+	for _, m := range masks {
+		if _, err := parsePrefixLength(m.value, m.bits); err != nil {
+			return fmt.Errorf("invalid %s: %v", m.key, err)
+		}
+	}
+
+	if c.SourceInterface != "" && c.SourceIP != "" {
+		return fmt.Errorf("SOURCE_INTERFACE and SOURCE_IP are mutually exclusive")
+	}
+	if c.SourceIP != "" {
+		if net.ParseIP(c.SourceIP) == nil {
+			return fmt.Errorf("SOURCE_IP must be an IP address, got %q", c.SourceIP)
+		}
+		if c.IPVersion != ipVersionDual && isIPv6(c.SourceIP) != (c.IPVersion == ipVersion6) {
+			return fmt.Errorf("SOURCE_IP %s does not match IP_VERSION=%s", c.SourceIP, c.IPVersion)
+		}
+	}
+
+	if c.IPFetchProxy != "" {
+		proxy, err := url.Parse(c.IPFetchProxy)
+		if err != nil {
+			return fmt.Errorf("invalid IP_FETCH_PROXY: %v", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("IP_FETCH_PROXY must be an http, https or socks5 URL, got %q", proxy.Redacted())
+		}
+	}
+
+	if c.CharonENRURL != "" {
+		if u, err := url.Parse(c.CharonENRURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("CHARON_ENR_URL must be an http or https URL, got %q", c.CharonENRURL)
+		}
+	}
+
+	for name, value := range c.IPFetchHeaders {
+		if err := checkHeader(name, value); err != nil {
+			return fmt.Errorf("invalid IP_FETCH_HEADERS: %v", err)
+		}
+	}
+	if len(c.IPFetchHeaders) > 0 {
+		// The headers usually carry credentials meant for the user's own
+		// endpoint, which must not be handed to third parties.
+		for _, provider := range append(slices.Clone(c.Providers), c.IPv6Providers...) {
+			if slices.Contains(defaultProviders, provider) || slices.Contains(defaultIPv6Providers, provider) {
+				return fmt.Errorf("IP_FETCH_HEADERS would be sent to the public provider %s; set IP_PROVIDERS to your own endpoint", provider)
+			}
+		}
+	}
+
+	if c.IPTLSPinnedSHA256 != "" {
+		if _, err := parseFingerprint(c.IPTLSPinnedSHA256); err != nil {
+			return fmt.Errorf("invalid IP_TLS_PINNED_SHA256: %v", err)
+		}
+	}
+
+	switch c.DBDriver {
+	case driverSQLite:
+		c.SQLiteJournalMode = strings.ToUpper(c.SQLiteJournalMode)
+		if !slices.Contains(sqliteJournalModes, c.SQLiteJournalMode) {
+			return fmt.Errorf("SQLITE_JOURNAL_MODE must be one of %s, got %q", strings.Join(sqliteJournalModes, ", "), c.SQLiteJournalMode)
+		}
+		c.SQLiteSynchronous = strings.ToUpper(c.SQLiteSynchronous)
+		if !slices.Contains(sqliteSynchronousModes, c.SQLiteSynchronous) {
+			return fmt.Errorf("SQLITE_SYNCHRONOUS must be one of %s, got %q", strings.Join(sqliteSynchronousModes, ", "), c.SQLiteSynchronous)
+		}
+	case driverPostgres:
+		if c.DatabaseURL == "" {
+			return fmt.Errorf("DATABASE_URL is required when DB_DRIVER=postgres")
+		}
+	default:
+		return fmt.Errorf("DB_DRIVER must be sqlite or postgres, got %q", c.DBDriver)
+	}
+
+	if c.HAEnable {
+		if c.DBDriver != driverPostgres {
+			return fmt.Errorf("HA_ENABLE requires DB_DRIVER=postgres, as the lease lives in the shared database")
+		}
+		if c.InstanceID == "" {
+			c.InstanceID = defaultInstanceID()
+		}
+	}
+
+	if c.RequireReachable {
+		c.CheckReachability = true
+	}
+
+	if c.RestartBackend == "" {
+		c.RestartBackend = backendCompose
+		if len(c.RestartCommand) > 0 {
+			c.RestartBackend = backendCommand
+		}
+	}
+
+	if c.RestartBackend == backendCompose && len(c.ComposeServices) == 0 {
+		return fmt.Errorf("at least one compose service is required")
+	}
+
+	if c.SMTPHost != "" {
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			return fmt.Errorf("SMTP_PORT must be between 1 and 65535, got %d", c.SMTPPort)
+		}
+		if c.SMTPFrom == "" || len(c.SMTPTo) == 0 {
+			return fmt.Errorf("SMTP_FROM and SMTP_TO are required when SMTP_HOST is set")
+		}
+	}
+
+	if c.DDNSProvider != "" && c.DDNSHostname == "" {
+		return fmt.Errorf("DDNS_HOSTNAME is required when DDNS_PROVIDER is set")
+	}
+
+	switch c.UpdateMode {
+	case "":
+		c.UpdateMode = updateModeEnv
+		if c.DDNSProvider != "" {
+			c.UpdateMode = updateModeBoth
+		}
+	case updateModeEnv:
+	case updateModeDNS, updateModeBoth:
+		if c.DDNSProvider == "" {
+			return fmt.Errorf("UPDATE_MODE=%s requires DDNS_PROVIDER", c.UpdateMode)
+		}
+	default:
+		return fmt.Errorf("UPDATE_MODE must be one of env, dns or both, got %q", c.UpdateMode)
+	}
+
+	return nil
+}
+
+// redacted returns a copy of c with credentials masked, for logging.
+func (c Config) redacted() Config {
+	mask := func(s *string) {
+		if *s != "" {
+			*s = "REDACTED"
+		}
+	}
+	mask(&c.DatabaseURL)
+	mask(&c.NotifyWebhookURL)
+	mask(&c.SMTPPass)
+	mask(&c.DuckDNSToken)
+	mask(&c.CloudflareAPIToken)
+	mask(&c.NomadToken)
+	if proxy, err := url.Parse(c.IPFetchProxy); err == nil {
+		c.IPFetchProxy = proxy.Redacted()
+	}
+	if c.IPFetchHeaders != nil {
+		headers := make(map[string]string, len(c.IPFetchHeaders))
+		for name := range c.IPFetchHeaders {
+			headers[name] = "REDACTED"
+		}
+		c.IPFetchHeaders = headers
+	}
+	return c
+}
+
+// summary returns the settings that decide what the updater does, as
+// slog key-value pairs for a single startup line. Secrets are left out.
+func (c *Config) summary() []any {
+	envFile := c.EnvFile
+	if !c.updatesEnv() {
+		envFile = "not managed (UPDATE_MODE=dns)"
+	}
+	database := c.DBPath
+	if c.DBDriver == driverPostgres {
+		database = c.redacted().DatabaseURL
+	}
+
+	var providers []string
+	if c.IPVersion != ipVersion6 {
+		providers = append(providers, c.Providers...)
+	}
+	if c.IPVersion != ipVersion4 {
+		providers = append(providers, c.IPv6Providers...)
+	}
+
+	var notifications []string
+	if c.NotifyWebhookURL != "" {
+		notifications = append(notifications, "webhook:"+c.NotifyType)
+	}
+	if c.SMTPHost != "" {
+		notifications = append(notifications, "email")
+	}
+	if len(notifications) == 0 {
+		notifications = []string{"none"}
+	}
+
+	return []any{
+		"check_interval", c.CheckInterval.String(),
+		"retry_interval", c.RetryInterval.String(),
+		"max_backoff", c.MaxBackoff.String(),
+		"fetch_timeout", c.FetchTimeout.String(),
+		"confirm_count", c.ConfirmCount,
+		"update_mode", c.UpdateMode,
+		"env_file", envFile,
+		"db_driver", c.DBDriver,
+		"db", database,
+		"restart_backend", c.RestartBackend,
+		"restart_enabled", c.RestartEnabled,
+		"ip_version", c.IPVersion,
+		"providers", strings.Join(providers, ","),
+		"quorum", c.ProviderQuorum,
+		"notifications", strings.Join(notifications, ","),
+	}
+}
+
+func durationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration such as 30s or 5m, got %q", key, raw)
+	}
+
+	// Whether zero or negative values are allowed depends on the setting
+	// and is checked in validate.
+	return value, nil
+}
+
+func stringFromEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func intFromEnv(key string, fallback int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, got %q", key, raw)
+	}
+
+	if value < 0 {
+		return 0, fmt.Errorf("%s must not be negative, got %d", key, value)
+	}
+
+	return value, nil
+}
+
+func boolFromEnv(key string, fallback bool) (bool, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", key, raw)
+	}
+
+	return value, nil
+}
+
+// listFromEnv parses a comma-separated list, ignoring empty entries. The
+// fallback is returned when the variable is unset or contains no entries.
+func listFromEnv(key string, fallback []string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
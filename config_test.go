@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "check_interval: 30s\nretry_interval: 15s\nip_providers:\n  - https://example.com/ip\n"
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("RETRY_INTERVAL", "1m")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	if cfg.CheckInterval != 30*time.Second {
+		t.Errorf("CheckInterval = %v, want the file value 30s", cfg.CheckInterval)
+	}
+	if cfg.RetryInterval != time.Minute {
+		t.Errorf("RetryInterval = %v, want the env value 1m", cfg.RetryInterval)
+	}
+	if cfg.MaxBackoff != defaultMaxBackoff {
+		t.Errorf("MaxBackoff = %v, want the default %v", cfg.MaxBackoff, defaultMaxBackoff)
+	}
+	if len(cfg.Providers) != 1 || cfg.Providers[0] != "https://example.com/ip" {
+		t.Errorf("Providers = %v, want the file value", cfg.Providers)
+	}
+}
+
+func TestLoadConfigZeroDurationsFromEnv(t *testing.T) {
+	t.Setenv("MIN_RESTART_INTERVAL", "0")
+	t.Setenv("STARTUP_JITTER", "0s")
+	t.Setenv("SQLITE_BUSY_TIMEOUT", "0")
+
+	cfg, err := loadConfig("")
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.MinRestartInterval != 0 || cfg.StartupJitter != 0 {
+		t.Errorf("MinRestartInterval, StartupJitter = %v, %v; want 0", cfg.MinRestartInterval, cfg.StartupJitter)
+	}
+	if cfg.SQLiteBusyTimeout != 0 {
+		t.Errorf("SQLiteBusyTimeout = %v, want 0", cfg.SQLiteBusyTimeout)
+	}
+
+	t.Setenv("CHECK_INTERVAL", "0")
+	if _, err := loadConfig(""); err == nil {
+		t.Error("loadConfig accepted CHECK_INTERVAL=0")
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":                "check_intervall: 30s\n",
+		"invalid value":              "confirm_count: 0\n",
+		"negative duration":          "retry_interval: -5s\n",
+		"two sources":                "source_interface: eth0\nsource_ip: 192.0.2.10\n",
+		"source version":             "source_ip: 2001:db8::10\n",
+		"smtp without recipients":    "smtp_host: mail.example.com\nsmtp_from: updater@example.com\n",
+		"change mask too long":       "ip_change_mask: /33\n",
+		"ha without postgres":        "ha_enable: true\n",
+		"headers to public provider": "ip_fetch_headers: {X-Api-Key: abc}\n",
+		"metadata as ipv6":           "ip_version: dual\nip_providers_v6: [aws-metadata]\n",
+		"sqlite journal mode":        "sqlite_journal_mode: fast\n",
+		"sqlite synchronous":         "sqlite_synchronous: sometimes\n",
+		"negative busy timeout":      "sqlite_busy_timeout: -1s\n",
+	}
+
+	for name, content := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestConfigSummary(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DBDriver = driverPostgres
+	cfg.DatabaseURL = "postgres://updater:hunter2@db:5432/ipupdater"
+	cfg.NotifyWebhookURL = "https://hooks.slack.com/services/secret"
+	cfg.NotifyType = notifySlack
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("Effective configuration", cfg.summary()...)
+	line := buf.String()
+
+	for _, secret := range []string{"hunter2", "secret"} {
+		if strings.Contains(line, secret) {
+			t.Errorf("summary leaks %q: %s", secret, line)
+		}
+	}
+	for _, want := range []string{"check_interval=10s", "env_file=.env", "notifications=webhook:slack", ipifyAPI} {
+		if !strings.Contains(line, want) {
+			t.Errorf("summary missing %q: %s", want, line)
+		}
+	}
+}
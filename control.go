@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// controlRequestTimeout bounds a whole control socket exchange.
+const controlRequestTimeout = time.Minute
+
+// controlError is the reply to a control socket request that failed.
+type controlError struct {
+	Error string `json:"error"`
+}
+
+// startControlSocket serves status requests on a Unix socket at path in the
+// background until ctx is cancelled. A client writes a "status" line and
+// receives one line of JSON, the same as GET /status serves, before the
+// connection is closed. Like startHTTPServer, listen errors are only
+// logged.
+func startControlSocket(ctx context.Context, path string, svc *Service) {
+	// A socket left behind by an unclean shutdown would make Listen fail.
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		os.Remove(path)
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		slog.Error("Control socket failed", "path", path, "error", err)
+		return
+	}
+
+	go func() {
+		slog.Info("Starting control socket", "path", path)
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					slog.Error("Control socket failed", "path", path, "error", err)
+				}
+				return
+			}
+			go serveControl(conn, svc)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		// Closing a Unix listener also removes the socket file.
+		lis.Close()
+	}()
+}
+
+func serveControl(conn net.Conn, svc *Service) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlRequestTimeout))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+
+	var reply any
+	switch cmd := strings.TrimSpace(line); cmd {
+	case "status":
+		status, err := svc.currentStatus()
+		if err != nil {
+			reply = controlError{Error: err.Error()}
+		} else {
+			reply = status
+		}
+	default:
+		reply = controlError{Error: "unknown command " + cmd}
+	}
+
+	json.NewEncoder(conn).Encode(reply)
+}
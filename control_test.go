@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func queryControlSocket(t *testing.T, path, cmd string) map[string]any {
+	t.Helper()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, cmd)
+	var reply map[string]any
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		t.Fatalf("decoding reply: %v", err)
+	}
+	return reply
+}
+
+func TestControlSocket(t *testing.T) {
+	rec := &recorder{}
+	svc := &Service{
+		cfg:     &Config{},
+		store:   &memoryStore{rec: rec, ips: []string{"1.1.1.1"}},
+		env:     &memoryEnvWriter{rec: rec, ip: "1.1.1.1"},
+		started: time.Now(),
+	}
+	svc.detectedIP.Store("2.2.2.2")
+	path := filepath.Join(t.TempDir(), "control.sock")
+
+	// A stale socket from an unclean shutdown is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	startControlSocket(ctx, path, svc)
+
+	// The reply is the JSON GET /status serves.
+	got := queryControlSocket(t, path, "status")
+	rec2 := httptest.NewRecorder()
+	runtimeHandler(svc).ServeHTTP(rec2, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var want map[string]any
+	if err := json.Unmarshal(rec2.Body.Bytes(), &want); err != nil {
+		t.Fatal(err)
+	}
+	delete(got, "uptime_seconds")
+	delete(want, "uptime_seconds")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("status = %v, want the /status reply %v", got, want)
+	}
+	if got["detected_ip"] != "2.2.2.2" || got["stored_ip"] != "1.1.1.1" || got["env_ip"] != "1.1.1.1" || got["synced"] != false {
+		t.Errorf("status = %v", got)
+	}
+
+	if got := queryControlSocket(t, path, "restart"); got["error"] == nil {
+		t.Error("expected an error for an unknown command")
+	}
+
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("socket file not removed on shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
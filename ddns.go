@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ddnsTimeout = 10 * time.Second
+
+	ddnsDuckDNS    = "duckdns"
+	ddnsCloudflare = "cloudflare"
+
+	duckDNSAPI    = "https://www.duckdns.org/update"
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+	// maxAPIResponseBytes caps how much of a DNS API response is read.
+	maxAPIResponseBytes = 64 << 10
+)
+
+// Update modes select what is changed when the IP changes: the .env file
+// (followed by a Charon restart), a dynamic DNS record, or both.
+const (
+	updateModeEnv  = "env"
+	updateModeDNS  = "dns"
+	updateModeBoth = "both"
+)
+
+// DNSUpdater points a dynamic DNS record at a new IP.
+type DNSUpdater interface {
+	Update(ctx context.Context, ip string) error
+}
+
+// newDNSUpdater builds the updater for cfg.DDNSProvider, returning nil if no
+// provider is configured.
+func newDNSUpdater(cfg *Config) (DNSUpdater, error) {
+	client := &http.Client{Timeout: ddnsTimeout}
+
+	switch cfg.DDNSProvider {
+	case "":
+		return nil, nil
+	case ddnsDuckDNS:
+		if cfg.DuckDNSToken == "" {
+			return nil, fmt.Errorf("DUCKDNS_TOKEN is required for the duckdns provider")
+		}
+		return &duckDNSUpdater{
+			baseURL: duckDNSAPI,
+			domain:  strings.TrimSuffix(cfg.DDNSHostname, ".duckdns.org"),
+			token:   cfg.DuckDNSToken,
+			client:  client,
+		}, nil
+	case ddnsCloudflare:
+		if cfg.CloudflareAPIToken == "" || cfg.CloudflareZoneID == "" {
+			return nil, fmt.Errorf("CLOUDFLARE_API_TOKEN and CLOUDFLARE_ZONE_ID are required for the cloudflare provider")
+		}
+		return &cloudflareUpdater{
+			baseURL: cloudflareAPI,
+			zoneID:  cfg.CloudflareZoneID,
+			name:    cfg.DDNSHostname,
+			token:   cfg.CloudflareAPIToken,
+			client:  client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown DDNS provider %q", cfg.DDNSProvider)
+	}
+}
+
+// duckDNSUpdater updates a DuckDNS subdomain through its update endpoint,
+// which answers a plain "OK" or "KO".
+type duckDNSUpdater struct {
+	baseURL string
+	domain  string
+	token   string
+	client  *http.Client
+}
+
+func (u *duckDNSUpdater) String() string {
+	return fmt.Sprintf("duckdns %s", u.domain)
+}
+
+func (u *duckDNSUpdater) Update(ctx context.Context, ip string) error {
+	query := url.Values{"domains": {u.domain}, "token": {u.token}}
+	if isIPv6(ip) {
+		query.Set("ipv6", ip)
+	} else {
+		query.Set("ip", ip)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := readLimited(resp.Body, maxIPResponseBytes)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+	if answer := strings.TrimSpace(string(body)); !strings.HasPrefix(answer, "OK") {
+		return fmt.Errorf("DuckDNS rejected the update: %q", truncate(answer, 64))
+	}
+	return nil
+}
+
+// cloudflareUpdater rewrites the content of an existing A or AAAA record in
+// a Cloudflare zone. The record must already exist.
+type cloudflareUpdater struct {
+	baseURL string
+	zoneID  string
+	name    string
+	token   string
+	client  *http.Client
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func (u *cloudflareUpdater) String() string {
+	return fmt.Sprintf("cloudflare %s", u.name)
+}
+
+func (u *cloudflareUpdater) Update(ctx context.Context, ip string) error {
+	recordType := "A"
+	if isIPv6(ip) {
+		recordType = "AAAA"
+	}
+
+	query := url.Values{"type": {recordType}, "name": {u.name}}
+	var records []cloudflareRecord
+	if err := u.do(ctx, http.MethodGet, "/zones/"+u.zoneID+"/dns_records?"+query.Encode(), nil, &records); err != nil {
+		return fmt.Errorf("failed to look up %s record: %v", recordType, err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no %s record named %q in zone %s", recordType, u.name, u.zoneID)
+	}
+
+	record := records[0]
+	if record.Content == ip {
+		slog.Info("DNS record already up to date", "record", u.name, "ip", ip)
+		return nil
+	}
+
+	patch := map[string]string{"content": ip}
+	if err := u.do(ctx, http.MethodPatch, "/zones/"+u.zoneID+"/dns_records/"+record.ID, patch, nil); err != nil {
+		return fmt.Errorf("failed to update %s record: %v", recordType, err)
+	}
+	return nil
+}
+
+// do sends an authenticated request to the Cloudflare API and decodes the
+// result into out, if non-nil.
+func (u *cloudflareUpdater) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+u.token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := readLimited(resp.Body, maxAPIResponseBytes)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	var decoded cloudflareResponse
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return fmt.Errorf("failed to parse response (status %d): %v", resp.StatusCode, err)
+	}
+	if !decoded.Success {
+		if len(decoded.Errors) > 0 {
+			return fmt.Errorf("API error %d: %s", decoded.Errors[0].Code, decoded.Errors[0].Message)
+		}
+		return fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(decoded.Result, out); err != nil {
+			return fmt.Errorf("failed to parse result: %v", err)
+		}
+	}
+	return nil
+}
+
+// dryRunDNSUpdater logs the update the wrapped DNSUpdater would perform.
+type dryRunDNSUpdater struct {
+	DNSUpdater
+}
+
+func (u dryRunDNSUpdater) Update(ctx context.Context, ip string) error {
+	slog.Info("Dry run: would update DNS record", "updater", fmt.Sprint(u.DNSUpdater), "ip", ip)
+	return nil
+}
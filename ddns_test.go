@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDuckDNSUpdater(t *testing.T) {
+	var query map[string][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		io.WriteString(w, "OK")
+	}))
+	defer srv.Close()
+
+	u := &duckDNSUpdater{baseURL: srv.URL, domain: "mynode", token: "secret", client: srv.Client()}
+	if err := u.Update(context.Background(), "2001:db8::1"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if got := query["domains"]; len(got) != 1 || got[0] != "mynode" {
+		t.Errorf("domains = %v, want mynode", got)
+	}
+	if got := query["ipv6"]; len(got) != 1 || got[0] != "2001:db8::1" {
+		t.Errorf("ipv6 = %v, want 2001:db8::1", got)
+	}
+	if _, ok := query["ip"]; ok {
+		t.Error("ip parameter set for an IPv6 update")
+	}
+}
+
+func TestDuckDNSUpdaterRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "KO")
+	}))
+	defer srv.Close()
+
+	u := &duckDNSUpdater{baseURL: srv.URL, domain: "mynode", token: "bad", client: srv.Client()}
+	if err := u.Update(context.Background(), "1.1.1.1"); err == nil {
+		t.Fatal("expected an error for a KO response")
+	}
+}
+
+func TestCloudflareUpdater(t *testing.T) {
+	var patched map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q", got)
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone/dns_records":
+			if r.URL.Query().Get("type") != "A" || r.URL.Query().Get("name") != "node.example.com" {
+				t.Errorf("unexpected lookup query %s", r.URL.RawQuery)
+			}
+			io.WriteString(w, `{"success":true,"result":[{"id":"rec1","content":"1.1.1.1"}]}`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/zones/zone/dns_records/rec1":
+			json.NewDecoder(r.Body).Decode(&patched)
+			io.WriteString(w, `{"success":true,"result":{}}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u := &cloudflareUpdater{baseURL: srv.URL, zoneID: "zone", name: "node.example.com", token: "token", client: srv.Client()}
+	if err := u.Update(context.Background(), "2.2.2.2"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if patched["content"] != "2.2.2.2" {
+		t.Errorf("patched content = %q, want 2.2.2.2", patched["content"])
+	}
+}
+
+func TestCloudflareUpdaterAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+	}))
+	defer srv.Close()
+
+	u := &cloudflareUpdater{baseURL: srv.URL, zoneID: "zone", name: "node.example.com", token: "bad", client: srv.Client()}
+	if err := u.Update(context.Background(), "2.2.2.2"); err == nil {
+		t.Fatal("expected an error for a failed API call")
+	}
+}
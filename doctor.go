@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFileNames are the files docker compose reads from the working
+// directory when no COMPOSE_FILE is given, in order of preference.
+var composeFileNames = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// diagnosis is the outcome of one doctor check, with a hint on fixing it.
+// Unlike --validate-config, the doctor contacts the IP providers and the
+// database. A failed check that is not critical is reported as a warning.
+type diagnosis struct {
+	validation
+	hint     string
+	critical bool
+}
+
+func diagnoseRestartBackend(cfg *Config) diagnosis {
+	d := diagnosis{validation: validateRestartBackend(cfg), critical: true}
+	switch cfg.RestartBackend {
+	case backendCompose, backendDocker:
+		d.hint = "install Docker, or set RESTART_BACKEND to the container runtime in use"
+	case backendPodman:
+		d.hint = "install Podman, or set RESTART_BACKEND to the container runtime in use"
+	case backendDockerAPI:
+		d.hint = "mount the Docker socket into the container or set DOCKER_HOST"
+	case backendCommand:
+		d.hint = "make RESTART_COMMAND name an executable on PATH"
+	default:
+		d.hint = "check the settings of RESTART_BACKEND=" + cfg.RestartBackend
+	}
+	return d
+}
+
+// diagnoseComposeServices checks that the services to recreate are defined
+// in the compose file the compose backend runs against.
+func diagnoseComposeServices(cfg *Config) diagnosis {
+	d := diagnosis{validation: validation{name: "compose services"}, critical: true}
+	if cfg.RestartBackend != backendCompose {
+		d.skipped = "RESTART_BACKEND is not compose"
+		return d
+	}
+
+	path := cfg.ComposeFile
+	if path == "" {
+		for _, name := range composeFileNames {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+	}
+	if path == "" {
+		d.err = fmt.Errorf("no compose file in the working directory")
+		d.hint = "run the updater from Charon's compose directory or set COMPOSE_FILE"
+		return d
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		d.err = err
+		d.hint = "set COMPOSE_FILE to Charon's compose file"
+		return d
+	}
+	var compose struct {
+		Services map[string]any `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(content, &compose); err != nil {
+		d.err = fmt.Errorf("failed to parse %s: %v", path, err)
+		d.hint = "check the file with docker compose config"
+		return d
+	}
+
+	var defined, missing []string
+	for name := range compose.Services {
+		defined = append(defined, name)
+	}
+	slices.Sort(defined)
+	for _, service := range cfg.ComposeServices {
+		if !slices.Contains(defined, service) {
+			missing = append(missing, service)
+		}
+	}
+	if len(missing) > 0 {
+		d.err = fmt.Errorf("%s defines no service %s", path, strings.Join(missing, ", "))
+		d.hint = "set COMPOSE_SERVICES to the service running Charon, one of: " + strings.Join(defined, ", ")
+		return d
+	}
+	d.detail = fmt.Sprintf("%s defines %s", path, strings.Join(cfg.ComposeServices, ", "))
+	return d
+}
+
+// diagnoseEnvKeys checks that the .env file already sets the keys the
+// updater rewrites, since a misspelt key would be added rather than
+// replacing the one Charon reads.
+func diagnoseEnvKeys(cfg *Config) diagnosis {
+	d := diagnosis{validation: validation{name: ".env keys"}, critical: true}
+	if !cfg.updatesEnv() {
+		d.skipped = "not managed with UPDATE_MODE=dns"
+		return d
+	}
+
+	env, err := godotenv.Read(cfg.EnvFile)
+	if os.IsNotExist(err) {
+		d.err = fmt.Errorf("%s does not exist", cfg.EnvFile)
+		d.hint = "set ENV_FILE to Charon's .env if it lives elsewhere; otherwise it is created on the first update"
+		d.critical = false
+		return d
+	}
+	if err != nil {
+		d.err = err
+		d.hint = "check the permissions and syntax of ENV_FILE"
+		return d
+	}
+
+	var missing []string
+	for _, key := range cfg.EnvKeys {
+		if env[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		d.err = fmt.Errorf("%s does not set %s", cfg.EnvFile, strings.Join(missing, ", "))
+		d.hint = fmt.Sprintf("set ENV_KEYS to the variables Charon reads its external address from, usually %s", envKey)
+		return d
+	}
+	d.detail = fmt.Sprintf("%s sets %s", cfg.EnvFile, strings.Join(cfg.EnvKeys, ", "))
+	return d
+}
+
+func diagnoseProviders(ctx context.Context, cfg *Config) diagnosis {
+	d := diagnosis{validation: validation{name: "IP providers"}, critical: true}
+	providers, err := newProviderSet(cfg)
+	if err != nil {
+		d.err = err
+		d.hint = "check SOURCE_INTERFACE and SOURCE_IP"
+		return d
+	}
+
+	result, err := providers.FetchIP(ctx, "")
+	if err != nil {
+		d.err = err
+		d.hint = "check outbound access to the providers, IP_FETCH_PROXY and IP_PROVIDERS"
+		return d
+	}
+	d.detail = fmt.Sprintf("%s from %s", result.IP, result.Provider)
+	return d
+}
+
+// diagnoseDatabase checks that the database can be written, without
+// creating or migrating it.
+func diagnoseDatabase(ctx context.Context, cfg *Config) diagnosis {
+	d := diagnosis{validation: validation{name: "database"}, critical: true}
+
+	if cfg.DBDriver == driverPostgres {
+		d.hint = "check DATABASE_URL points at a reachable primary the user can write to"
+		db, err := sql.Open(postgresDialect.driver, cfg.DatabaseURL)
+		if err != nil {
+			d.err = err
+			return d
+		}
+		defer db.Close()
+
+		var readOnly string
+		if err := db.QueryRowContext(ctx, "SHOW transaction_read_only").Scan(&readOnly); err != nil {
+			d.err = err
+			return d
+		}
+		if readOnly == "on" {
+			d.err = fmt.Errorf("the database is read-only")
+			return d
+		}
+		d.detail = "connected, read-write"
+		return d
+	}
+
+	d.hint = "point DB_PATH at a writable volume or fix its permissions"
+	if f, err := os.OpenFile(cfg.DBPath, os.O_RDWR, 0); err == nil {
+		f.Close()
+		d.detail = fmt.Sprintf("%s writable", cfg.DBPath)
+		return d
+	} else if !os.IsNotExist(err) {
+		d.err = err
+		return d
+	}
+
+	// The database and any missing directories are created on startup, so
+	// the nearest existing directory must be writable.
+	dir := filepath.Dir(cfg.DBPath)
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".ip-updater-doctor-*")
+	if err != nil {
+		d.err = fmt.Errorf("%s cannot be created: %v", cfg.DBPath, err)
+		return d
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.detail = fmt.Sprintf("%s missing, it can be created", cfg.DBPath)
+	return d
+}
+
+// runDoctor implements the doctor subcommand, writing a report of the checks
+// with a hint for each problem to w. It fails if a critical check did.
+func runDoctor(ctx context.Context, w io.Writer, cfg *Config) error {
+	diagnoses := []diagnosis{
+		diagnoseRestartBackend(cfg),
+		diagnoseComposeServices(cfg),
+		diagnoseEnvKeys(cfg),
+		diagnoseProviders(ctx, cfg),
+		diagnoseDatabase(ctx, cfg),
+	}
+
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, d := range diagnoses {
+		switch {
+		case d.err != nil && d.critical:
+			failed++
+			fmt.Fprintf(tw, "FAIL\t%s\t%v\n", d.name, d.err)
+		case d.err != nil:
+			fmt.Fprintf(tw, "warn\t%s\t%v\n", d.name, d.err)
+		case d.skipped != "":
+			fmt.Fprintf(tw, "skip\t%s\t%s\n", d.name, d.skipped)
+		default:
+			fmt.Fprintf(tw, "ok\t%s\t%s\n", d.name, d.detail)
+		}
+		if d.err != nil && d.hint != "" {
+			fmt.Fprintf(tw, "\t\thint: %s\n", d.hint)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d critical check(s) failed", failed)
+	}
+	return nil
+}
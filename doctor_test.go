@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDoctor(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	// A stand-in docker binary, as the doctor only looks it up on PATH.
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	compose := "services:\n  charon:\n    image: obolnetwork/charon\n  lighthouse:\n    image: sigp/lighthouse\n"
+	if err := os.WriteFile("compose.yaml", []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(defaultEnvFile, []byte(envKey+"=1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := fakeIpify(t, http.StatusOK, "text/plain", "1.1.1.1")
+
+	cfg := defaultConfig()
+	cfg.Providers = []string{srv.URL}
+	cfg.DBPath = filepath.Join(dir, "data", "ip_store.db")
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runDoctor(context.Background(), &out, cfg); err != nil {
+		t.Fatalf("runDoctor: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"compose.yaml defines charon", "sets " + envKey, "1.1.1.1 from", "can be created"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(cfg.DBPath); !os.IsNotExist(err) {
+		t.Errorf("doctor created the database: %v", err)
+	}
+
+	cfg.ComposeServices = []string{"charon-1"}
+	cfg.EnvKeys = []string{"CHARON_P2P_EXTERNAL_HOST"}
+	out.Reset()
+	err = runDoctor(context.Background(), &out, cfg)
+	if err == nil || !strings.Contains(err.Error(), "2 critical check(s) failed") {
+		t.Errorf("err = %v, want the service and env key reported\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "one of: charon, lighthouse") {
+		t.Errorf("report does not suggest the defined services:\n%s", out.String())
+	}
+
+	// A missing .env only warns, as the first update creates it.
+	cfg.ComposeServices = []string{"charon"}
+	cfg.EnvFile = filepath.Join(dir, "missing.env")
+	out.Reset()
+	if err := runDoctor(context.Background(), &out, cfg); err != nil {
+		t.Errorf("runDoctor with no .env: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "warn") {
+		t.Errorf("report does not warn about the missing .env:\n%s", out.String())
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxENRResponseBytes caps how much of the ENR response is read. A record
+// is at most 300 bytes, about 400 in its textual form.
+const maxENRResponseBytes = 4 << 10
+
+// charonENR reads the Ethereum Node Record Charon serves on its monitoring
+// API, to learn the IP it is actually advertising to its peers.
+type charonENR struct {
+	client *http.Client
+	url    string
+}
+
+func newCharonENR(url string) *charonENR {
+	return &charonENR{client: &http.Client{Timeout: 10 * time.Second}, url: url}
+}
+
+// advertisedIP returns the IP in Charon's ENR, preferring the IPv6 address
+// if ipv6 is set and falling back to the other family if the record has
+// only one.
+func (e *charonENR) advertisedIP(ctx context.Context, ipv6 bool) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %v", err)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch Charon's ENR: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch Charon's ENR: received status code %d", resp.StatusCode)
+	}
+	body, err := readLimited(resp.Body, maxENRResponseBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Charon's ENR: %v", err)
+	}
+
+	ip4, ip6, err := decodeENRIPs(string(body))
+	if err != nil {
+		return "", err
+	}
+	ip := ip4
+	if ip == nil || ipv6 && ip6 != nil {
+		ip = ip6
+	}
+	if ip == nil {
+		return "", fmt.Errorf("Charon's ENR advertises no IP")
+	}
+	return ip.String(), nil
+}
+
+// decodeENRIPs extracts the ip and ip6 entries of an ENR in its textual
+// "enr:" form, as defined by EIP-778. Either is nil if absent. The
+// signature is not verified, as the record comes from our own Charon.
+func decodeENRIPs(text string) (ip4, ip6 net.IP, err error) {
+	text = strings.Trim(strings.TrimSpace(text), `"`)
+	encoded, ok := strings.CutPrefix(text, "enr:")
+	if !ok {
+		return nil, nil, fmt.Errorf("not an ENR: missing enr: prefix")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ENR encoding: %v", err)
+	}
+
+	list, isList, rest, err := rlpSplit(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ENR: %v", err)
+	}
+	if !isList || len(rest) != 0 {
+		return nil, nil, fmt.Errorf("invalid ENR: not a single RLP list")
+	}
+
+	// The list holds the signature and sequence number, followed by
+	// key/value pairs.
+	var items [][]byte
+	for len(list) > 0 {
+		var item []byte
+		if item, _, list, err = rlpSplit(list); err != nil {
+			return nil, nil, fmt.Errorf("invalid ENR: %v", err)
+		}
+		items = append(items, item)
+	}
+	if len(items) < 2 || len(items)%2 != 0 {
+		return nil, nil, fmt.Errorf("invalid ENR: %d list items", len(items))
+	}
+
+	for i := 2; i < len(items); i += 2 {
+		switch key, value := string(items[i]), items[i+1]; {
+		case key == "ip" && len(value) == net.IPv4len:
+			ip4 = net.IP(value)
+		case key == "ip6" && len(value) == net.IPv6len:
+			ip6 = net.IP(value)
+		}
+	}
+	return ip4, ip6, nil
+}
+
+// rlpSplit splits the first RLP item off b, returning its content, whether
+// it is a list and the remaining input.
+func rlpSplit(b []byte) (content []byte, isList bool, rest []byte, err error) {
+	if len(b) == 0 {
+		return nil, false, nil, fmt.Errorf("unexpected end of RLP input")
+	}
+
+	prefix := b[0]
+	var offset, size uint64
+	switch {
+	case prefix < 0x80:
+		return b[:1], false, b[1:], nil
+	case prefix < 0xb8:
+		offset, size = 1, uint64(prefix-0x80)
+	case prefix < 0xc0:
+		offset, size, err = rlpLongSize(b, prefix-0xb7)
+	case prefix < 0xf8:
+		offset, size, isList = 1, uint64(prefix-0xc0), true
+	default:
+		offset, size, err = rlpLongSize(b, prefix-0xf7)
+		isList = true
+	}
+	if err != nil {
+		return nil, false, nil, err
+	}
+	if size > uint64(len(b))-offset {
+		return nil, false, nil, fmt.Errorf("RLP item of %d bytes overruns the input", size)
+	}
+	return b[offset : offset+size], isList, b[offset+size:], nil
+}
+
+// rlpLongSize decodes the big-endian size of a long RLP item, stored in
+// the n bytes after its prefix.
+func rlpLongSize(b []byte, n byte) (offset, size uint64, err error) {
+	if n > 8 || len(b) < 1+int(n) {
+		return 0, 0, fmt.Errorf("invalid RLP size of %d bytes", n)
+	}
+	var buf [8]byte
+	copy(buf[8-n:], b[1:1+n])
+	return 1 + uint64(n), binary.BigEndian.Uint64(buf[:]), nil
+}
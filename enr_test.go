@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// eip778ENR is the example record of EIP-778, advertising 127.0.0.1.
+const eip778ENR = "enr:-IS4QHCYrYZbAKWCBRlAy5zzaDZXJBGkcnh4MHcBFZntXNFrdvJjX04jRzjzCBOonrkTfj499SZuOh8R33Ls8RRcy5wBgmlkgnY0gmlwhH8AAAGJc2VjcDI1NmsxoQPKY0yuDUmstAHYpMa2_oxVtw0RW_QAdpzBQA8yWM0xOIN1ZHCCdl8"
+
+// rlpString and rlpList encode short RLP items, enough to build test ENRs.
+func rlpString(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append([]byte{0x80 + byte(len(b))}, b...)
+}
+
+func rlpList(items ...[]byte) []byte {
+	var content []byte
+	for _, item := range items {
+		content = append(content, item...)
+	}
+	if len(content) < 56 {
+		return append([]byte{0xc0 + byte(len(content))}, content...)
+	}
+	return append([]byte{0xf8, byte(len(content))}, content...)
+}
+
+// testENR encodes a record with the given key/value pairs and a dummy
+// signature.
+func testENR(pairs ...string) string {
+	items := [][]byte{rlpString(make([]byte, 64)), rlpString([]byte{1})}
+	for _, s := range pairs {
+		items = append(items, rlpString([]byte(s)))
+	}
+	return "enr:" + base64.RawURLEncoding.EncodeToString(rlpList(items...))
+}
+
+func TestDecodeENRIPs(t *testing.T) {
+	ip4, ip6, err := decodeENRIPs(eip778ENR)
+	if err != nil || ip4.String() != "127.0.0.1" || ip6 != nil {
+		t.Fatalf("decodeENRIPs(EIP-778 example) = %v, %v, %v", ip4, ip6, err)
+	}
+
+	dual := testENR("id", "v4", "ip", string(net.ParseIP("1.2.3.4").To4()), "ip6", string(net.ParseIP("2001:db8::1")))
+	ip4, ip6, err = decodeENRIPs(`"` + dual + `"` + "\n")
+	if err != nil || ip4.String() != "1.2.3.4" || ip6.String() != "2001:db8::1" {
+		t.Errorf("decodeENRIPs(dual stack) = %v, %v, %v", ip4, ip6, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"-IS4QHCYrYZbAKWCBRlAy5zzaDZXJBGkcnh4MHcBFZntXNFrdvJjX04jRzjzCBOonrkTfj499SZuOh8R33Ls8RRcy5wBgmlkgnY0",
+		"enr:!!!",
+		eip778ENR[:40],
+		testENR("ip"),
+	} {
+		if _, _, err := decodeENRIPs(bad); err == nil {
+			t.Errorf("decodeENRIPs(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCharonENRAdvertisedIP(t *testing.T) {
+	record := testENR("ip", string(net.ParseIP("1.2.3.4").To4()), "ip6", string(net.ParseIP("2001:db8::1")))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/enr" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(record))
+	}))
+	defer srv.Close()
+
+	enr := newCharonENR(srv.URL + "/enr")
+	if ip, err := enr.advertisedIP(context.Background(), false); err != nil || ip != "1.2.3.4" {
+		t.Errorf("advertisedIP(IPv4) = %q, %v", ip, err)
+	}
+	if ip, err := enr.advertisedIP(context.Background(), true); err != nil || ip != "2001:db8::1" {
+		t.Errorf("advertisedIP(IPv6) = %q, %v", ip, err)
+	}
+
+	record = testENR("id", "v4")
+	if _, err := enr.advertisedIP(context.Background(), false); err == nil || !strings.Contains(err.Error(), "no IP") {
+		t.Errorf("advertisedIP without an IP: err = %v", err)
+	}
+
+	record = strings.Repeat("x", maxENRResponseBytes+1)
+	if _, err := enr.advertisedIP(context.Background(), false); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("advertisedIP with an oversized response: err = %v", err)
+	}
+
+	enr.url = srv.URL + "/missing"
+	if _, err := enr.advertisedIP(context.Background(), false); err == nil {
+		t.Error("advertisedIP succeeded on a 404")
+	}
+}
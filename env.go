@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// getEnvIP returns the IP the .env file at path assigns to keys. When the
+// keys disagree, or only some are set, the distinct values are returned
+// joined by commas, which matches no IP and so reads as out of sync. The
+// file is parsed on every call and never loaded into the process
+// environment, so the result always reflects its current content.
+func getEnvIP(path string, keys []string) (string, error) {
+	env, err := godotenv.Read(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to load .env file: %v", err)
+	}
+
+	var values []string
+	found := false
+	for _, key := range keys {
+		value := normalizeEnvIP(env[key])
+		found = found || value != ""
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("IP not found in .env file")
+	}
+
+	return strings.Join(values, ","), nil
+}
+
+// checkEnvFile returns an error if path can neither be read as the .env
+// file nor created: it names a directory, or its parent does not exist.
+func checkEnvFile(path string) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	dir := filepath.Dir(path)
+	info, err = os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot create %s: %s is not a directory", path, dir)
+	}
+	return nil
+}
+
+// isHostname reports whether an .env value is a DNS name rather than an IP
+// address, as when the node is advertised under its own hostname.
+func isHostname(value string) bool {
+	if value == "" || net.ParseIP(value) != nil {
+		return false
+	}
+	labels := strings.Split(strings.TrimSuffix(value, "."), ".")
+	// A numeric top-level label is a mistyped address, not a name.
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizeEnvIP strips whitespace, a single layer of matching quotes and
+// IPv6 brackets from an .env value so it compares equal to a bare IP.
+func normalizeEnvIP(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	return strings.Trim(value, "[]")
+}
+
+// formatEnvIP renders ip as it should appear in .env, wrapping IPv6
+// addresses in brackets when requested.
+func formatEnvIP(ip string, brackets bool) string {
+	if brackets && isIPv6(ip) {
+		return "[" + ip + "]"
+	}
+	return ip
+}
+
+// EnvWriter reads and applies the IP in the env file Charon reads.
+type EnvWriter interface {
+	// CurrentIP returns the IP currently configured in the env file.
+	CurrentIP() (string, error)
+	// SetIP writes ip to the env file.
+	SetIP(ip string) error
+	// Rollback restores the env file as it was before the last SetIP.
+	Rollback() error
+}
+
+// fileEnvWriter rewrites key in the env file at path, backing the file up
+// first so the change can be rolled back.
+type fileEnvWriter struct {
+	path       string
+	keys       []string
+	backupDir  string
+	backupKeep int
+	lastBackup string
+	// created records that the last SetIP created the file, so rolling
+	// back removes it.
+	created bool
+}
+
+func newFileEnvWriter(cfg *Config) *fileEnvWriter {
+	return &fileEnvWriter{
+		path:       cfg.EnvFile,
+		keys:       cfg.EnvKeys,
+		backupDir:  cfg.EnvBackupDir,
+		backupKeep: cfg.EnvBackupKeep,
+	}
+}
+
+func (w *fileEnvWriter) CurrentIP() (string, error) {
+	return getEnvIP(w.path, w.keys)
+}
+
+func (w *fileEnvWriter) SetIP(ip string) error {
+	slog.Info("Updating .env file", "ip", ip)
+
+	backup, err := backupEnvFile(w.path, w.backupDir, w.backupKeep)
+	if err != nil {
+		return fmt.Errorf("failed to back up .env file: %v", err)
+	}
+	w.lastBackup = backup
+	w.created = backup == ""
+	if w.created {
+		slog.Info("No .env file found, creating it", "path", w.path)
+	} else {
+		slog.Info("Backed up previous .env file", "path", backup)
+	}
+
+	if err := writeEnvValues(w.path, w.keys, ip); err != nil {
+		return err
+	}
+
+	slog.Info("Successfully updated .env file")
+	return nil
+}
+
+func (w *fileEnvWriter) Rollback() error {
+	if w.created {
+		slog.Warn("Rolling back .env by removing the newly created file", "path", w.path)
+		return os.Remove(w.path)
+	}
+	if w.lastBackup == "" {
+		return fmt.Errorf("no backup to roll back to")
+	}
+	slog.Warn("Rolling back .env", "backup", w.lastBackup)
+	return restoreEnvFile(w.lastBackup, w.path)
+}
+
+// dryRunEnvWriter logs the change SetIP would make without touching the file.
+type dryRunEnvWriter struct {
+	path string
+	keys []string
+}
+
+func (w *dryRunEnvWriter) CurrentIP() (string, error) {
+	return getEnvIP(w.path, w.keys)
+}
+
+func (w *dryRunEnvWriter) SetIP(ip string) error {
+	var lines []string
+	if input, err := os.ReadFile(w.path); err == nil {
+		lines = strings.Split(string(input), "\n")
+	}
+
+	for _, key := range w.keys {
+		var old string
+		for _, line := range lines {
+			if strings.HasPrefix(line, key+"=") {
+				old = strings.TrimSuffix(line, "\r")
+				break
+			}
+		}
+		slog.Info("Dry run: would update .env file", "path", w.path,
+			"diff", fmt.Sprintf("-%s\n+%s=%s", old, key, ip))
+	}
+	return nil
+}
+
+func (w *dryRunEnvWriter) Rollback() error {
+	slog.Info("Dry run: would roll back .env file", "path", w.path)
+	return nil
+}
+
+// updateEnvFile writes newIP to the env file and restarts Charon, unless
+// restarts are disabled. With the health check enabled, a restart that
+// leaves Charon unhealthy is undone by rolling the env file back and
+// restarting again.
+func updateEnvFile(ctx context.Context, cfg *Config, writer EnvWriter, restarter Restarter, newIP string) error {
+	_, span := tracer.Start(ctx, "env.write", trace.WithAttributes(attribute.String("new_ip", newIP)))
+	err := writer.SetIP(newIP)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.RestartEnabled {
+		slog.Warn("RESTART_ENABLED is false, not restarting Charon; restart it to pick up the new IP", "ip", newIP)
+		return nil
+	}
+
+	if err := restarter.Restart(ctx); err != nil {
+		restartFailuresTotal.Inc()
+		return fmt.Errorf("failed to restart Charon after IP update: %v", err)
+	}
+
+	if !cfg.HealthCheck {
+		return nil
+	}
+
+	healthErr := waitHealthy(ctx, cfg.HealthCheckURL, cfg.HealthCheckTimeout)
+	if healthErr == nil {
+		return nil
+	}
+
+	slog.Warn("Charon is unhealthy after restart, rolling back")
+	if err := writer.Rollback(); err != nil {
+		return fmt.Errorf("%w; rollback failed: %v", healthErr, err)
+	}
+	if err := restarter.Restart(ctx); err != nil {
+		restartFailuresTotal.Inc()
+		return fmt.Errorf("%w; restart after rollback failed: %v", healthErr, err)
+	}
+	slog.Info("Rolled back .env and restarted Charon with the previous configuration")
+
+	return healthErr
+}
+
+// restoreEnvFile atomically replaces path with the contents of backup.
+func restoreEnvFile(backup, path string) error {
+	input, err := os.ReadFile(backup)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(backup)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, input, info.Mode().Perm())
+}
+
+// quoteLike wraps value in the same quotes as oldValue, if it was quoted,
+// so that rewriting an entry keeps the style the file already uses.
+func quoteLike(oldValue, value string) string {
+	oldValue = strings.TrimSpace(oldValue)
+	if len(oldValue) >= 2 && (oldValue[0] == '"' || oldValue[0] == '\'') && oldValue[len(oldValue)-1] == oldValue[0] {
+		q := string(oldValue[0])
+		return q + value + q
+	}
+	return value
+}
+
+// writeEnvValues sets each of keys to value in the env file at path, in a
+// single write. Only the first line assigning each key is touched; comments,
+// blank lines, the file's line endings (LF or CRLF) and trailing newline are
+// preserved, as is the quoting of the existing value, so rewriting with the
+// same value leaves the file byte-for-byte unchanged. Missing keys are
+// appended, and a missing file is created.
+func writeEnvValues(path string, keys []string, value string) error {
+	perm := os.FileMode(0644)
+	input, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		input = nil
+	case err != nil:
+		return fmt.Errorf("failed to read .env file: %v", err)
+	default:
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat .env file: %v", err)
+		}
+		perm = info.Mode().Perm()
+	}
+
+	content := string(input)
+	trailingNewline := strings.HasSuffix(content, "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	var lines []string
+	if content != "" || trailingNewline {
+		lines = strings.Split(content, "\n")
+	}
+	found := make(map[string]bool, len(keys))
+
+	// Lines keep their own "\r" of a CRLF ending; it is stripped only to
+	// compare, and new lines follow the style of the first line.
+	cr := ""
+	if len(lines) > 0 && strings.HasSuffix(lines[0], "\r") {
+		cr = "\r"
+	}
+
+	for i, rawLine := range lines {
+		line := strings.TrimSuffix(rawLine, "\r")
+		for _, key := range keys {
+			if found[key] || !strings.HasPrefix(line, key+"=") {
+				continue
+			}
+			oldValue := strings.TrimPrefix(line, key+"=")
+			lines[i] = fmt.Sprintf("%s=%s", key, quoteLike(oldValue, value)) + rawLine[len(line):]
+			found[key] = true
+			slog.Info("Updating .env entry", "key", key, "old_value", oldValue, "value", value)
+			break
+		}
+	}
+
+	for _, key := range keys {
+		if found[key] {
+			continue
+		}
+		slog.Info("No existing .env entry found, adding new entry", "key", key)
+		if !trailingNewline && len(lines) > 0 {
+			lines[len(lines)-1] += cr
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", key, value)+cr)
+		trailingNewline = true
+	}
+
+	output := strings.Join(lines, "\n")
+	if trailingNewline {
+		output += "\n"
+	}
+
+	if err := writeFileAtomic(path, []byte(output), perm); err != nil {
+		return fmt.Errorf("failed to write .env file: %v", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic replaces path with data by writing a temporary file in the
+// same directory and renaming it into place, so readers never observe a
+// partially written file. The temporary file is removed on failure.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	cleanup := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return cleanup(err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+const envBackupTimeFormat = "20060102-150405"
+
+// backupEnvFile copies the env file at path to a timestamped backup in dir
+// (the env file's own directory when dir is empty) and prunes older backups
+// so that at most keep remain. A keep of zero disables pruning. A missing
+// env file has nothing to back up and yields an empty path.
+func backupEnvFile(path, dir string, keep int) (string, error) {
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	input, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := filepath.Base(path) + ".bak."
+	backup := filepath.Join(dir, prefix+time.Now().Format(envBackupTimeFormat))
+	if err := writeFileAtomic(backup, input, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+
+	if keep > 0 {
+		if err := pruneEnvBackups(dir, prefix, keep); err != nil {
+			slog.Warn("Failed to prune old .env backups", "error", err)
+		}
+	}
+
+	return backup, nil
+}
+
+// pruneEnvBackups removes all but the newest keep backups in dir. Backup
+// names embed a sortable timestamp, so lexical order is chronological.
+func pruneEnvBackups(dir, prefix string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) && !strings.Contains(entry.Name(), ".tmp") {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		slog.Info("Removed old .env backup", "path", backups[0])
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteEnvValuePreservesCommentsAndIsStable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	original := "# Charon settings\n\nCLUSTER_NAME=test\n# external address\n" + envKey + "=1.1.1.1\n\n# trailing comment\n"
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# Charon settings\n\nCLUSTER_NAME=test\n# external address\n" + envKey + "=2.2.2.2\n\n# trailing comment\n"
+	if string(first) != want {
+		t.Fatalf("unexpected content after update:\ngot:  %q\nwant: %q", first, want)
+	}
+
+	if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err != nil {
+		t.Fatalf("second update: %v", err)
+	}
+	second, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(second) != string(first) {
+		t.Fatalf("file changed on repeated update:\nfirst:  %q\nsecond: %q", first, second)
+	}
+}
+
+func TestWriteEnvValueAppendsMissingKeyOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("# no address yet\nFOO=bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := writeEnvValues(path, []string{envKey}, "3.3.3.3"); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# no address yet\nFOO=bar\n" + envKey + "=3.3.3.3\n"
+	if string(got) != want {
+		t.Fatalf("unexpected content:\ngot:  %q\nwant: %q", got, want)
+	}
+}
+
+func TestWriteEnvValuePreservesFileMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env")
+	if err := os.WriteFile(path, []byte(envKey+"=1.1.1.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("file mode = %v, want 0600", perm)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only .env in directory, found %d entries", len(entries))
+	}
+}
+
+func TestPruneEnvBackupsKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		".env.bak.20240101-000000",
+		".env.bak.20240102-000000",
+		".env.bak.20240103-000000",
+		"unrelated",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneEnvBackups(dir, ".env.bak.", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+
+	want := []string{".env.bak.20240102-000000", ".env.bak.20240103-000000", "unrelated"}
+	if len(got) != len(want) {
+		t.Fatalf("remaining files = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("remaining files = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestNormalizeEnvIP(t *testing.T) {
+	tests := map[string]string{
+		"1.1.1.1":         "1.1.1.1",
+		" 1.1.1.1 ":       "1.1.1.1",
+		`"1.1.1.1"`:       "1.1.1.1",
+		"' 1.1.1.1 '":     "1.1.1.1",
+		"[2001:db8::1]":   "2001:db8::1",
+		`"[2001:db8::1]"`: "2001:db8::1",
+		`"1.1.1.1`:        `"1.1.1.1`,
+		"":                "",
+	}
+
+	for in, want := range tests {
+		if got := normalizeEnvIP(in); got != want {
+			t.Errorf("normalizeEnvIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWriteEnvValuePreservesQuoting(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{"unquoted", envKey + "=1.1.1.1\n", envKey + "=2.2.2.2\n"},
+		{"single-quoted", envKey + "='1.1.1.1'\n", envKey + "='2.2.2.2'\n"},
+		{"double-quoted", envKey + "=\"1.1.1.1\"\n", envKey + "=\"2.2.2.2\"\n"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteEnvValueCreatesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+
+	if err := writeEnvValues(path, []string{envKey}, "1.1.1.1"); err != nil {
+		t.Fatalf("writeEnvValues: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := envKey + "=1.1.1.1\n"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteEnvValueUnreadableFile(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read files regardless of permissions")
+	}
+
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(envKey+"=1.1.1.1\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err == nil {
+		t.Fatal("expected an error for an unreadable .env file")
+	}
+}
+
+func TestWriteEnvValueCRLF(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		want     string
+	}{
+		{
+			"replace",
+			"# comment\r\nFOO=bar\r\n" + envKey + "=1.1.1.1\r\n",
+			"# comment\r\nFOO=bar\r\n" + envKey + "=2.2.2.2\r\n",
+		},
+		{
+			"append",
+			"FOO=bar\r\n",
+			"FOO=bar\r\n" + envKey + "=2.2.2.2\r\n",
+		},
+		{
+			"append without trailing newline",
+			"FOO=bar\r\nBAZ=qux",
+			"FOO=bar\r\nBAZ=qux\r\n" + envKey + "=2.2.2.2\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".env")
+		if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := writeEnvValues(path, []string{envKey}, "2.2.2.2"); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteEnvValuesMultipleKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("RELAY_HOST=\"1.1.1.1\"\n"+envKey+"=1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeEnvValues(path, []string{envKey, "RELAY_HOST", "EXTRA_HOST"}, "2.2.2.2"); err != nil {
+		t.Fatalf("writeEnvValues: %v", err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "RELAY_HOST=\"2.2.2.2\"\n" + envKey + "=2.2.2.2\nEXTRA_HOST=2.2.2.2\n"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestGetEnvIPMultipleKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		want  string
+		error bool
+	}{
+		{"all agree", map[string]string{"KEY_A": "1.1.1.1", "KEY_B": "\"1.1.1.1\""}, "1.1.1.1", false},
+		{"disagree", map[string]string{"KEY_A": "1.1.1.1", "KEY_B": "9.9.9.9"}, "1.1.1.1,9.9.9.9", false},
+		{"one missing", map[string]string{"KEY_A": "1.1.1.1"}, "1.1.1.1,", false},
+		{"all missing", map[string]string{}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(dir); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chdir(wd) })
+
+			var content string
+			for key, value := range tt.env {
+				content += key + "=" + value + "\n"
+			}
+			if err := os.WriteFile(defaultEnvFile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := getEnvIP(defaultEnvFile, []string{"KEY_A", "KEY_B"})
+			if (err != nil) != tt.error {
+				t.Fatalf("err = %v, want error %v", err, tt.error)
+			}
+			if got != tt.want {
+				t.Errorf("getEnvIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "charon.env")
+	if err := os.WriteFile(existing, []byte(envKey+"=1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{existing, false},
+		{filepath.Join(dir, "new.env"), false},
+		{dir, true},
+		{filepath.Join(dir, "missing", ".env"), true},
+		{filepath.Join(existing, ".env"), true},
+	}
+	for _, tt := range tests {
+		if err := checkEnvFile(tt.path); (err != nil) != tt.wantErr {
+			t.Errorf("checkEnvFile(%s) = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestGetEnvIPIgnoresProcessEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte(envKey+"=1.1.1.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envKey, "9.9.9.9")
+
+	if got, err := getEnvIP(path, []string{envKey}); err != nil || got != "1.1.1.1" {
+		t.Fatalf("getEnvIP = %q, %v; want the file's 1.1.1.1", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte(envKey+"=2.2.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := getEnvIP(path, []string{envKey}); err != nil || got != "2.2.2.2" {
+		t.Fatalf("getEnvIP = %q, %v after the file changed; want 2.2.2.2", got, err)
+	}
+	if got := os.Getenv(envKey); got != "9.9.9.9" {
+		t.Errorf("process environment changed to %q", got)
+	}
+}
+
+func TestIsHostname(t *testing.T) {
+	tests := map[string]bool{
+		"node.example.com":  true,
+		"node.example.com.": true,
+		"charon-1":          true,
+		"1.2.3.4":           false,
+		"2001:db8::1":       false,
+		"1.2.3":             false,
+		"":                  false,
+		"1.1.1.1,2.2.2.2":   false,
+		"-bad.example.com":  false,
+		"bad..example.com":  false,
+	}
+	for value, want := range tests {
+		if got := isHostname(value); got != want {
+			t.Errorf("isHostname(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
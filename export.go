@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	exportCSV  = "csv"
+	exportJSON = "json"
+)
+
+// exportRecord is an ipRecord as written by the export subcommand, with
+// updated_at in RFC 3339.
+type exportRecord struct {
+	IP         string `json:"ip"`
+	Provider   string `json:"provider,omitempty"`
+	LatencyMS  int64  `json:"latency_ms,omitempty"`
+	UpdatedAt  string `json:"updated_at"`
+	PreviousIP string `json:"previous_ip,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+func newExportRecord(record ipRecord) exportRecord {
+	return exportRecord{
+		IP:         record.IP,
+		Provider:   record.Provider,
+		LatencyMS:  record.LatencyMS,
+		UpdatedAt:  record.UpdatedAt.UTC().Format(time.RFC3339),
+		PreviousIP: record.PreviousIP,
+		Reason:     record.Reason,
+	}
+}
+
+// eachRecord calls fn with every stored IP, oldest first, reading one row
+// at a time.
+func (s *sqlStore) eachRecord(fn func(ipRecord) error) error {
+	rows, err := s.db.Query("SELECT " + ipRecordColumns + " FROM ip_store ORDER BY updated_at, id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanIPRecord(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// exportHistory writes the whole IP history to w in format, streaming it
+// from the database.
+func exportHistory(w io.Writer, store *sqlStore, format string) error {
+	switch format {
+	case exportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"ip", "provider", "latency_ms", "updated_at", "previous_ip", "reason"}); err != nil {
+			return err
+		}
+		err := store.eachRecord(func(record ipRecord) error {
+			r := newExportRecord(record)
+			latency := ""
+			if r.Provider != "" {
+				latency = strconv.FormatInt(r.LatencyMS, 10)
+			}
+			return cw.Write([]string{r.IP, r.Provider, latency, r.UpdatedAt, r.PreviousIP, r.Reason})
+		})
+		if err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	case exportJSON:
+		// Written element by element so the history is never held in
+		// memory as a whole.
+		bw := bufio.NewWriter(w)
+		if _, err := bw.WriteString("["); err != nil {
+			return err
+		}
+		first := true
+		err := store.eachRecord(func(record ipRecord) error {
+			sep := ",\n  "
+			if first {
+				sep = "\n  "
+			}
+			first = false
+			line, err := json.Marshal(newExportRecord(record))
+			if err != nil {
+				return err
+			}
+			if _, err := bw.WriteString(sep); err != nil {
+				return err
+			}
+			_, err = bw.Write(line)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		end := "\n]\n"
+		if first {
+			end = "]\n"
+		}
+		if _, err := bw.WriteString(end); err != nil {
+			return err
+		}
+		return bw.Flush()
+	default:
+		return fmt.Errorf("unknown export format %q, want csv or json", format)
+	}
+}
+
+// runExport implements the export subcommand. Like status, it opens the
+// database read-only, without creating or migrating it.
+func runExport(cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", exportCSV, "output format: csv or json")
+	output := fs.String("output", "-", "file to write to, or - for standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != exportCSV && *format != exportJSON {
+		return fmt.Errorf("unknown export format %q, want csv or json", *format)
+	}
+
+	if cfg.DBDriver == driverSQLite {
+		if _, err := os.Stat(cfg.DBPath); err != nil {
+			return fmt.Errorf("no database to export: %v", err)
+		}
+	}
+	store, db, err := openStoreReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *output == "-" {
+		return exportHistory(os.Stdout, store, *format)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := exportHistory(f, store, *format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportHistory(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows}
+	for _, record := range []ipRecord{
+		{IP: "1.1.1.1"},
+		{IP: "2.2.2.2", Provider: "https://api.ipify.org", LatencyMS: 42},
+	} {
+		if err := store.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := exportHistory(&out, store, exportCSV); err != nil {
+		t.Fatalf("csv export: %v", err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][0] != "1.1.1.1" || rows[2][0] != "2.2.2.2" || rows[2][2] != "42" || rows[1][2] != "" {
+		t.Fatalf("csv rows = %q, want a header and both IPs, oldest first", rows)
+	}
+	if _, err := time.Parse(time.RFC3339, rows[1][3]); err != nil {
+		t.Errorf("updated_at %q is not RFC 3339", rows[1][3])
+	}
+
+	out.Reset()
+	if err := exportHistory(&out, store, exportJSON); err != nil {
+		t.Fatalf("json export: %v", err)
+	}
+	var records []exportRecord
+	if err := json.Unmarshal(out.Bytes(), &records); err != nil {
+		t.Fatalf("json export is not valid JSON: %v\n%s", err, out.String())
+	}
+	if len(records) != 2 || records[0].IP != "1.1.1.1" || records[1].Provider != "https://api.ipify.org" {
+		t.Errorf("json records = %+v", records)
+	}
+}
+
+// fullWriter fails every write, like a file on a full disk.
+type fullWriter struct{ writes int }
+
+func (w *fullWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("no space left on device")
+}
+
+func TestExportHistoryStopsOnWriteError(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t)}
+	// Enough rows to overflow the output buffers mid-export.
+	for i := 0; i < 200; i++ {
+		if err := store.Insert(ipRecord{IP: "2001:db8::1", Provider: "https://api6.ipify.org"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{exportCSV, exportJSON} {
+		w := &fullWriter{}
+		if err := exportHistory(w, store, format); err == nil {
+			t.Errorf("%s export to a full disk succeeded", format)
+		}
+		if w.writes != 1 {
+			t.Errorf("%s export wrote %d times, want it to stop after the first failure", format, w.writes)
+		}
+	}
+}
+
+func TestExportEmptyHistoryAsJSON(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows}
+
+	var out bytes.Buffer
+	if err := exportHistory(&out, store, exportJSON); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("export = %q, want an empty array", out.String())
+	}
+}
+
+func TestRunExportToFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.DBPath = filepath.Join(dir, "ip_store.db")
+
+	if err := runExport(cfg, nil); err == nil {
+		t.Fatal("expected an error without a database")
+	}
+	if _, err := os.Stat(cfg.DBPath); !os.IsNotExist(err) {
+		t.Fatal("export created the database")
+	}
+
+	db, err := initDB(cfg.DBPath, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	output := filepath.Join(dir, "history.json")
+	if err := runExport(cfg, []string{"--format", "json", "--output", output}); err != nil {
+		t.Fatalf("runExport: %v", err)
+	}
+	if got, err := os.ReadFile(output); err != nil || string(got) != "[]\n" {
+		t.Errorf("output = %q, %v", got, err)
+	}
+
+	if err := runExport(cfg, []string{"--format", "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
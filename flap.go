@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+const (
+	defaultFlapThreshold = 3
+	defaultFlapWindow    = time.Hour
+
+	// flapHistory is how many IP transitions are remembered.
+	flapHistory = 64
+	// flapRevertPolls is how soon, in readings, going back to the
+	// previous IP counts as a revert rather than a second change.
+	flapRevertPolls = 5
+)
+
+// ipTransition is a reading that differed from the one before it.
+type ipTransition struct {
+	from, to string
+	poll     int
+	at       time.Time
+}
+
+// flapDetector remembers recent IP readings in a ring buffer of transitions
+// and counts reverts: readings that go back to the previous IP within a
+// few polls, as when a provider intermittently reports a wrong address.
+type flapDetector struct {
+	last  string
+	polls int
+	ring  [flapHistory]ipTransition
+	// n is the number of transitions recorded so far; the newest is at
+	// ring[(n-1)%flapHistory].
+	n int
+}
+
+// observe records a reading.
+func (f *flapDetector) observe(ip string, now time.Time) {
+	f.polls++
+	if f.last != "" && ip != f.last {
+		f.ring[f.n%flapHistory] = ipTransition{from: f.last, to: ip, poll: f.polls, at: now}
+		f.n++
+	}
+	f.last = ip
+}
+
+// reverts counts the reverts since the given time.
+func (f *flapDetector) reverts(since time.Time) int {
+	count := 0
+	for i := f.n - 1; i > 0 && i > f.n-flapHistory; i-- {
+		cur, prev := f.ring[i%flapHistory], f.ring[(i-1)%flapHistory]
+		if cur.at.Before(since) {
+			break
+		}
+		if cur.to == prev.from && cur.poll-prev.poll <= flapRevertPolls {
+			count++
+		}
+	}
+	return count
+}
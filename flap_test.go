@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlapDetectorCountsReverts(t *testing.T) {
+	var f flapDetector
+	start := time.Now()
+	readings := []string{
+		"1.1.1.1", "9.9.9.9", "1.1.1.1", // revert
+		"1.1.1.1", "9.9.9.9", "9.9.9.9", "1.1.1.1", // back to 9.9.9.9 and to 1.1.1.1 are both reverts
+		"2.2.2.2", "2.2.2.2", "2.2.2.2", "2.2.2.2", "2.2.2.2", "2.2.2.2", "1.1.1.1", // too late to be a revert
+	}
+	for i, ip := range readings {
+		f.observe(ip, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	if got := f.reverts(start); got != 3 {
+		t.Errorf("reverts = %d, want 3", got)
+	}
+	if got := f.reverts(start.Add(5 * time.Minute)); got != 1 {
+		t.Errorf("reverts in the last readings = %d, want 1", got)
+	}
+}
+
+func TestFlapDetectorRingWraps(t *testing.T) {
+	var f flapDetector
+	start := time.Now()
+	for i := 0; i < 3*flapHistory; i++ {
+		ip := "1.1.1.1"
+		if i%2 == 1 {
+			ip = "9.9.9.9"
+		}
+		f.observe(ip, start.Add(time.Duration(i)*time.Second))
+	}
+
+	// Every transition but the oldest remembered one is a revert.
+	if got := f.reverts(start); got != flapHistory-1 {
+		t.Errorf("reverts = %d, want %d", got, flapHistory-1)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/crisog/obol-ip-updater/updaterpb"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative updaterpb/updater.proto
+
+// grpcServer implements the IPUpdater control API on top of the same
+// Service the polling loop runs.
+type grpcServer struct {
+	updaterpb.UnimplementedIPUpdaterServer
+	svc *Service
+}
+
+func (g *grpcServer) GetStatus(ctx context.Context, _ *updaterpb.GetStatusRequest) (*updaterpb.GetStatusResponse, error) {
+	report, err := g.svc.status(ctx)
+	if err != nil {
+		return nil, checkError(err)
+	}
+	return &updaterpb.GetStatusResponse{
+		DetectedIp: report.DetectedIP,
+		EnvIp:      report.EnvIP,
+		StoredIp:   report.StoredIP,
+		Synced:     report.synced(),
+	}, nil
+}
+
+func (g *grpcServer) GetHistory(_ context.Context, req *updaterpb.GetHistoryRequest) (*updaterpb.GetHistoryResponse, error) {
+	limit := int(req.GetLimit())
+	if limit == 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit < 1 || limit > maxHistoryLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxHistoryLimit)
+	}
+
+	records, err := g.svc.store.History(limit)
+	if err != nil {
+		slog.Error("Failed to query IP history", "error", err)
+		return nil, status.Error(codes.Internal, "failed to query IP history")
+	}
+
+	resp := &updaterpb.GetHistoryResponse{Records: make([]*updaterpb.IPRecord, 0, len(records))}
+	for _, record := range records {
+		resp.Records = append(resp.Records, &updaterpb.IPRecord{
+			Ip:         record.IP,
+			Provider:   record.Provider,
+			LatencyMs:  record.LatencyMS,
+			UpdatedAt:  timestamppb.New(record.UpdatedAt),
+			PreviousIp: record.PreviousIP,
+			Reason:     record.Reason,
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) ForceCheck(ctx context.Context, _ *updaterpb.ForceCheckRequest) (*updaterpb.ForceCheckResponse, error) {
+	slog.Info("Check requested over gRPC")
+	if err := g.svc.Check(ctx); err != nil {
+		return nil, checkError(err)
+	}
+
+	storedIP, err := g.svc.store.LatestIP()
+	if err != nil && err != sql.ErrNoRows {
+		return nil, status.Errorf(codes.Internal, "failed to query database: %v", err)
+	}
+	return &updaterpb.ForceCheckResponse{StoredIp: storedIP}, nil
+}
+
+// checkError maps a failed check to a gRPC status: failing to reach the IP
+// providers is transient, anything else is an internal error.
+func checkError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, errFetchIP):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// startGRPCServer serves the control API on addr in the background until
+// ctx is cancelled. Like startHTTPServer, listen errors are only logged.
+func startGRPCServer(ctx context.Context, addr string, svc *Service) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("gRPC server failed", "addr", addr, "error", err)
+		return
+	}
+
+	srv := grpc.NewServer()
+	updaterpb.RegisterIPUpdaterServer(srv, &grpcServer{svc: svc})
+
+	go func() {
+		slog.Info("Starting gRPC server", "addr", lis.Addr().String())
+		if err := srv.Serve(lis); err != nil {
+			slog.Error("gRPC server failed", "addr", addr, "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		// A forced check may be slow to finish; don't let it hold up
+		// shutdown for longer than the HTTP servers would.
+		timer := time.AfterFunc(serverShutdownTimeout, srv.Stop)
+		defer timer.Stop()
+		srv.GracefulStop()
+	}()
+}
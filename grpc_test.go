@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/crisog/obol-ip-updater/updaterpb"
+)
+
+// newGRPCClient serves the control API for svc over an in-memory listener.
+func newGRPCClient(t *testing.T, svc *Service) updaterpb.IPUpdaterClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	updaterpb.RegisterIPUpdaterServer(srv, &grpcServer{svc: svc})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return updaterpb.NewIPUpdaterClient(conn)
+}
+
+func TestGRPCForceCheckAndHistory(t *testing.T) {
+	rec := &recorder{}
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t)}
+	if err := store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	svc := &Service{
+		cfg:       &Config{},
+		fetcher:   fakeFetcher{ip: "2.2.2.2"},
+		store:     store,
+		env:       &memoryEnvWriter{rec: rec, ip: "1.1.1.1"},
+		restarter: recordingRestarter{rec},
+		state:     newCheckState(1),
+	}
+	client := newGRPCClient(t, svc)
+	ctx := context.Background()
+
+	check, err := client.ForceCheck(ctx, &updaterpb.ForceCheckRequest{})
+	if err != nil {
+		t.Fatalf("ForceCheck: %v", err)
+	}
+	if check.GetStoredIp() != "2.2.2.2" {
+		t.Errorf("stored IP after check = %q, want 2.2.2.2", check.GetStoredIp())
+	}
+
+	history, err := client.GetHistory(ctx, &updaterpb.GetHistoryRequest{})
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if got := history.GetRecords(); len(got) != 2 || got[0].GetIp() != "2.2.2.2" || got[1].GetIp() != "1.1.1.1" {
+		t.Errorf("history = %v, want 2.2.2.2 then 1.1.1.1", got)
+	} else if got[0].GetPreviousIp() != "1.1.1.1" || got[0].GetReason() != reasonIPChange {
+		t.Errorf("latest record = %v, want the previous IP and reason", got[0])
+	}
+
+	report, err := client.GetStatus(ctx, &updaterpb.GetStatusRequest{})
+	if err != nil {
+		t.Fatalf("GetStatus: %v", err)
+	}
+	if !report.GetSynced() || report.GetDetectedIp() != "2.2.2.2" {
+		t.Errorf("status = %v, want synced on 2.2.2.2", report)
+	}
+
+	_, err = client.GetHistory(ctx, &updaterpb.GetHistoryRequest{Limit: -1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetHistory(-1) error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCForceCheckFetchFailure(t *testing.T) {
+	svc := &Service{
+		cfg:     &Config{},
+		fetcher: &failingFetcher{},
+		store:   &memoryStore{rec: &recorder{}},
+		env:     &memoryEnvWriter{rec: &recorder{}},
+		state:   newCheckState(1),
+	}
+	client := newGRPCClient(t, svc)
+
+	_, err := client.ForceCheck(context.Background(), &updaterpb.ForceCheckRequest{})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("ForceCheck error = %v, want Unavailable", err)
+	}
+}
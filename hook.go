@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// hookTimeout bounds how long a hook script may run.
+const hookTimeout = time.Minute
+
+// Hook is run around an IP update with the previous and new IP.
+type Hook interface {
+	Run(ctx context.Context, oldIP, newIP string) error
+}
+
+// scriptHook runs an executable, passing the IPs both as arguments and as
+// OLD_IP / NEW_IP in its environment.
+type scriptHook struct {
+	name string
+	path string
+}
+
+// newScriptHook returns a Hook running path, or nil if path is empty.
+func newScriptHook(name, path string) Hook {
+	if path == "" {
+		return nil
+	}
+	return &scriptHook{name: name, path: path}
+}
+
+func (h *scriptHook) String() string {
+	return h.path
+}
+
+func (h *scriptHook) Run(ctx context.Context, oldIP, newIP string) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	slog.Info("Running hook", "hook", h.name, "path", h.path, "old_ip", oldIP, "ip", newIP)
+	start := time.Now()
+
+	cmd := exec.CommandContext(ctx, h.path, oldIP, newIP)
+	cmd.Env = append(os.Environ(), "OLD_IP="+oldIP, "NEW_IP="+newIP)
+	output, err := cmd.CombinedOutput()
+	if out := strings.TrimSpace(string(output)); out != "" {
+		slog.Info("Hook output", "hook", h.name, "output", out)
+	}
+	if err != nil {
+		return fmt.Errorf("%s hook %s failed: %v", h.name, h.path, err)
+	}
+
+	slog.Info("Hook completed", "hook", h.name, "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+// dryRunHook logs the hook the wrapped Hook would run.
+type dryRunHook struct {
+	Hook
+}
+
+func (h dryRunHook) Run(ctx context.Context, oldIP, newIP string) error {
+	slog.Info("Dry run: would run hook", "path", fmt.Sprint(h.Hook), "old_ip", oldIP, "ip", newIP)
+	return nil
+}
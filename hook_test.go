@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScriptHookPassesIPs(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	hook := newScriptHook("test", writeScript(t, `echo "$1 $2 $OLD_IP $NEW_IP" > `+out+"\n"))
+
+	if err := hook.Run(context.Background(), "1.1.1.1", "2.2.2.2"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.1.1.1 2.2.2.2 1.1.1.1 2.2.2.2\n"; string(got) != want {
+		t.Errorf("hook saw %q, want %q", got, want)
+	}
+}
+
+func TestScriptHookFailure(t *testing.T) {
+	hook := newScriptHook("test", writeScript(t, "echo refusing\nexit 3\n"))
+	if err := hook.Run(context.Background(), "1.1.1.1", "2.2.2.2"); err == nil {
+		t.Fatal("expected an error for a non-zero exit")
+	}
+}
+
+func TestNewScriptHookEmptyPath(t *testing.T) {
+	if hook := newScriptHook("test", ""); hook != nil {
+		t.Fatalf("newScriptHook(\"\") = %v, want nil", hook)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHALeaseDuration = 30 * time.Second
+
+	// leaseName is the leader_lease row the updaters of one node share.
+	leaseName = "ip-updater"
+)
+
+// leaderLease elects one of several updaters sharing a database as the
+// leader, the only one that applies updates. The leader holds a row in
+// leader_lease and renews it well before it expires; if it stops renewing,
+// another instance takes the row over once it has expired. A nil
+// leaderLease always leads.
+type leaderLease struct {
+	db       *sql.DB
+	dialect  dialect
+	holder   string
+	duration time.Duration
+
+	mu      sync.Mutex
+	leading bool
+}
+
+func newLeaderLease(db *sql.DB, d dialect, holder string, duration time.Duration) *leaderLease {
+	return &leaderLease{db: db, dialect: d, holder: holder, duration: duration}
+}
+
+// defaultInstanceID identifies this updater when INSTANCE_ID is not set.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host
+}
+
+// tryAcquire takes or renews the lease, reporting whether this instance now
+// holds it. Taking it over only succeeds once the previous holder's lease
+// has expired.
+func (l *leaderLease) tryAcquire(now time.Time) (bool, error) {
+	res, err := l.db.Exec(l.dialect.rebind(`
+	INSERT INTO leader_lease (name, holder, expires_at) VALUES (?, ?, ?)
+	ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+	WHERE leader_lease.holder = excluded.holder OR leader_lease.expires_at < ?`),
+		leaseName, l.holder, now.Add(l.duration).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// release gives up the lease, if held, so a follower can take over without
+// waiting for it to expire.
+func (l *leaderLease) release() error {
+	_, err := l.db.Exec(l.dialect.rebind("DELETE FROM leader_lease WHERE name = ? AND holder = ?"), leaseName, l.holder)
+	return err
+}
+
+// isLeader reports whether this instance held the lease at the last
+// renewal.
+func (l *leaderLease) isLeader() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// renew tries to take or renew the lease once and logs leadership changes.
+// A failed attempt gives up leadership, since another instance may take
+// the lease over in the meantime.
+func (l *leaderLease) renew() {
+	leading, err := l.tryAcquire(time.Now())
+	if err != nil {
+		slog.Warn("Failed to renew the leader lease", "instance_id", l.holder, "error", err)
+		leading = false
+	}
+
+	l.mu.Lock()
+	changed := leading != l.leading
+	l.leading = leading
+	l.mu.Unlock()
+
+	switch {
+	case changed && leading:
+		slog.Info("Became the leader, applying updates", "instance_id", l.holder)
+	case changed:
+		slog.Warn("No longer the leader, standing by", "instance_id", l.holder)
+	}
+}
+
+// start renews the lease every third of its duration, jittered so
+// instances started together don't contend in lockstep. The first attempt
+// is made before start returns, so the first check already knows whether to
+// act. The returned stop ends the renewals and releases the lease.
+func (l *leaderLease) start() (stop func()) {
+	l.renew()
+	if !l.isLeader() {
+		slog.Info("Another instance is the leader, standing by", "instance_id", l.holder)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			interval := l.duration/3 + jitter(l.duration/10, rand.Int64N)
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+				l.renew()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		if l.isLeader() {
+			if err := l.release(); err != nil {
+				slog.Warn("Failed to release the leader lease", "error", err)
+			}
+		}
+	}
+}
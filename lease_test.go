@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaderLease(t *testing.T) {
+	db := newTestDB(t)
+	a := newLeaderLease(db, sqliteDialect, "a", time.Minute)
+	b := newLeaderLease(db, sqliteDialect, "b", time.Minute)
+	now := time.Now()
+
+	acquire := func(l *leaderLease, at time.Time, want bool) {
+		t.Helper()
+		got, err := l.tryAcquire(at)
+		if err != nil {
+			t.Fatalf("%s: tryAcquire: %v", l.holder, err)
+		}
+		if got != want {
+			t.Fatalf("%s: tryAcquire at %v = %v, want %v", l.holder, at.Sub(now), got, want)
+		}
+	}
+
+	acquire(a, now, true)
+	acquire(b, now, false)
+	acquire(a, now.Add(30*time.Second), true) // renewal
+	acquire(b, now.Add(time.Minute), false)   // a's renewed lease still holds
+	acquire(b, now.Add(2*time.Minute), true)  // a stopped renewing
+	acquire(a, now.Add(2*time.Minute), false)
+
+	if err := b.release(); err != nil {
+		t.Fatal(err)
+	}
+	acquire(a, now.Add(2*time.Minute), true)
+}
+
+func TestLeaderLeaseStartAndStop(t *testing.T) {
+	db := newTestDB(t)
+	a := newLeaderLease(db, sqliteDialect, "a", time.Minute)
+	b := newLeaderLease(db, sqliteDialect, "b", time.Minute)
+
+	stopA := a.start()
+	if !a.isLeader() {
+		t.Fatal("first instance did not become the leader")
+	}
+	stopB := b.start()
+	defer stopB()
+	if b.isLeader() {
+		t.Fatal("second instance became the leader while the first holds the lease")
+	}
+
+	stopA()
+	b.renew()
+	if !b.isLeader() {
+		t.Error("second instance did not take over the released lease")
+	}
+
+	var none *leaderLease
+	if !none.isLeader() {
+		t.Error("a nil lease must always lead")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// instanceLock is an exclusive flock held for the life of the process, so
+// two instances never race on .env and the database.
+type instanceLock struct {
+	f *os.File
+}
+
+// acquireLock takes the lock on path, creating the file if needed, and
+// records the current PID in it. It fails immediately if another process
+// holds the lock.
+func acquireLock(path string) (*instanceLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %v", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			owner, _ := os.ReadFile(path)
+			if pid := strings.TrimSpace(string(owner)); pid != "" {
+				return nil, fmt.Errorf("another instance (pid %s) is already running, lock %s is held", pid, path)
+			}
+			return nil, fmt.Errorf("another instance is already running, lock %s is held", path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &instanceLock{f: f}, nil
+}
+
+// release drops the lock. The file is left in place: removing it would let
+// a waiting process lock the unlinked file while a new one is created.
+func (l *instanceLock) release() {
+	l.f.Truncate(0)
+	syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	l.f.Close()
+}
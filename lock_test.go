@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAcquireLockExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_store.db.lock")
+
+	lock, err := acquireLock(path)
+	if err != nil {
+		t.Fatalf("acquireLock: %v", err)
+	}
+
+	// flock locks belong to the open file description, so a second open
+	// in the same process conflicts just like another process would.
+	if _, err := acquireLock(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Fatalf("second acquireLock error = %v, want an already running error", err)
+	}
+
+	lock.release()
+
+	again, err := acquireLock(path)
+	if err != nil {
+		t.Fatalf("acquireLock after release: %v", err)
+	}
+	again.release()
+}
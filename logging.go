@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger builds the process logger from LOG_FORMAT (text or json) and
+// LOG_LEVEL (debug, info, warn or error). Empty values default to text/info.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q: must be text or json", format)
+	}
+}
+
+// logLevel resolves the log level from LOG_LEVEL and the --verbose and
+// --quiet flags, which take precedence over it.
+func logLevel(env string, verbose, quiet bool) (string, error) {
+	switch {
+	case verbose && quiet:
+		return "", fmt.Errorf("--verbose and --quiet are mutually exclusive")
+	case verbose:
+		return "debug", nil
+	case quiet:
+		return "warn", nil
+	default:
+		return env, nil
+	}
+}
+
+// fatal logs msg with err at error level and exits, replacing log.Fatalf.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNewLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped", "ip", "1.1.1.1")
+	logger.Warn("kept", "ip", "2.2.2.2")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "kept" || entry["ip"] != "2.2.2.2" || entry["level"] != slog.LevelWarn.String() {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := newLogger(&buf, "xml", ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := newLogger(&buf, "", "loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		env            string
+		verbose, quiet bool
+		want           string
+	}{
+		{"", false, false, ""},
+		{"error", false, false, "error"},
+		{"error", true, false, "debug"},
+		{"debug", false, true, "warn"},
+	}
+	for _, tt := range tests {
+		got, err := logLevel(tt.env, tt.verbose, tt.quiet)
+		if err != nil || got != tt.want {
+			t.Errorf("logLevel(%q, %v, %v) = %q, %v; want %q", tt.env, tt.verbose, tt.quiet, got, err, tt.want)
+		}
+	}
+
+	if _, err := logLevel("", true, true); err == nil {
+		t.Error("expected an error for --verbose with --quiet")
+	}
+}
@@ -1,228 +1,427 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Build information, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+func versionString() string {
+	return fmt.Sprintf("obol-ip-updater %s (commit %s, built %s)", version, commit, buildDate)
+}
+
 const (
-	dbPath        = "ip_store.db"
-	ipifyAPI      = "https://api.ipify.org?format=json"
-	checkInterval = 10 * time.Second
-	envKey        = "CHARON_P2P_EXTERNAL_HOSTNAME"
-	retryInterval = 5 * time.Second
-	httpTimeout   = 10 * time.Second
+	// defaultEnvFile is the env file Charon reads, relative to the working
+	// directory unless ENV_FILE says otherwise.
+	defaultEnvFile = ".env"
+	// envKey is the .env variable Charon reads its advertised address from,
+	// and the default for ENV_KEYS.
+	envKey = "CHARON_P2P_EXTERNAL_HOSTNAME"
 )
 
-type IPResponse struct {
-	IP string `json:"ip"`
+// debouncer requires a changed IP to be reported a number of times in a row
+// before the change is acted upon, filtering out transient bad readings.
+type debouncer struct {
+	required  int
+	candidate string
+	streak    int
 }
 
-func getCurrentIP() (string, error) {
-	client := &http.Client{
-		Timeout: httpTimeout,
-	}
+func newDebouncer(required int) *debouncer {
+	return &debouncer{required: required}
+}
 
-	log.Printf("Fetching current IP from %s...", ipifyAPI)
-	resp, err := client.Get(ipifyAPI)
-	if err != nil {
-		return "", fmt.Errorf("network error while fetching IP: %v", err)
+// observe records a reading that differs from the stored IP and reports
+// whether it has now been seen the required number of consecutive times.
+func (d *debouncer) observe(ip string) bool {
+	if ip != d.candidate {
+		d.candidate = ip
+		d.streak = 0
 	}
-	defer resp.Body.Close()
+	d.streak++
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	if d.streak >= d.required {
+		d.reset()
+		return true
 	}
+	return false
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
+// hold treats ip as already confirmed, so acting on it can be deferred
+// without having to confirm it all over again.
+func (d *debouncer) hold(ip string) {
+	d.candidate = ip
+	d.streak = d.required - 1
+}
 
-	var ipResp IPResponse
-	if err := json.Unmarshal(body, &ipResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %v", err)
-	}
+// reset discards any pending candidate, e.g. when the reading flips back.
+func (d *debouncer) reset() {
+	d.candidate = ""
+	d.streak = 0
+}
 
-	if ipResp.IP == "" {
-		return "", fmt.Errorf("received empty IP from API")
+// backoffDuration returns how long to wait before retry number attempt
+// (starting at 1), using exponential backoff from base capped at max, with
+// full jitter: a uniformly random duration between zero and that ceiling.
+// randInt63n is injected so tests can make the jitter deterministic.
+func backoffDuration(attempt int, base, max time.Duration, randInt63n func(int64) int64) time.Duration {
+	ceiling := base
+	for i := 1; i < attempt && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
 	}
 
-	log.Printf("Successfully fetched current IP: %s", ipResp.IP)
-	return ipResp.IP, nil
+	return time.Duration(randInt63n(int64(ceiling) + 1))
 }
 
-func initDB() (*sql.DB, error) {
-	log.Printf("Initializing SQLite database at %s...", dbPath)
-	db, err := sql.Open("sqlite3", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %v", err)
+// jitter returns a uniformly random duration between zero and max, or zero
+// if max is not positive. randInt63n is injected as for backoffDuration.
+func jitter(max time.Duration, randInt63n func(int64) int64) time.Duration {
+	if max <= 0 {
+		return 0
 	}
+	return time.Duration(randInt63n(int64(max) + 1))
+}
 
-	createTable := `
-	CREATE TABLE IF NOT EXISTS ip_store (
-		id INTEGER PRIMARY KEY,
-		ip TEXT NOT NULL,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-
-	if _, err := db.Exec(createTable); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to create table: %v", err)
+func main() {
+	var (
+		once, dryRun, showVersion bool
+		validateConfig            bool
+		skipComposeCheck          bool
+		verbose, quiet            bool
+		configPath                string
+	)
+	flag.BoolVar(&once, "once", false, "check and update exactly once, then exit")
+	flag.BoolVar(&once, "1", false, "shorthand for --once")
+	flag.BoolVar(&dryRun, "dry-run", false, "log intended changes without writing .env, restarting Charon or storing IPs")
+	flag.StringVar(&configPath, "config", "", "path to a YAML config file; environment variables override its values")
+	flag.BoolVar(&validateConfig, "validate-config", false, "check the configuration, .env, restart backend and database without running, then exit")
+	flag.BoolVar(&skipComposeCheck, "skip-compose-check", false, "don't check at startup that the compose services exist, e.g. when the compose project is not readable from here")
+	flag.BoolVar(&showVersion, "version", false, "print version information and exit")
+	flag.BoolVar(&verbose, "verbose", false, "log at debug level, overriding LOG_LEVEL")
+	flag.BoolVar(&verbose, "v", false, "shorthand for --verbose")
+	flag.BoolVar(&quiet, "quiet", false, "log warnings and errors only, overriding LOG_LEVEL")
+	flag.BoolVar(&quiet, "q", false, "shorthand for --quiet")
+	flag.Parse()
+
+	if showVersion {
+		fmt.Println(versionString())
+		return
 	}
 
-	log.Printf("Database initialized successfully")
-	return db, nil
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func getEnvIP() (string, error) {
-	if err := godotenv.Load(); err != nil {
-		return "", fmt.Errorf("failed to load .env file: %v", err)
+	level, err := logLevel(os.Getenv("LOG_LEVEL"), verbose, quiet)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
+	logger, err := newLogger(os.Stderr, os.Getenv("LOG_FORMAT"), level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		os.Exit(1)
 	}
+	slog.SetDefault(logger)
 
-	ip := os.Getenv(envKey)
-	if ip == "" {
-		return "", fmt.Errorf("IP not found in .env file")
+	// setup writes the config file, so it runs before any is loaded.
+	if flag.Arg(0) == "setup" {
+		if err := runSetup(os.Stdin, os.Stdout, flag.Args()[1:]); err != nil {
+			fatal("Setup failed", err)
+		}
+		return
 	}
 
-	return ip, nil
-}
+	slog.Info("Starting IP monitoring service", "version", version, "commit", commit, "build_date", buildDate)
 
-func restartCharon() error {
-	log.Printf("Restarting Charon container...")
-	cmd := exec.Command("docker", "compose", "up", "charon", "-d", "--force-recreate")
-	output, err := cmd.CombinedOutput()
+	cfg, err := loadConfig(configPath)
 	if err != nil {
-		return fmt.Errorf("failed to restart Charon: %v, output: %s", err, string(output))
+		fatal("Invalid configuration", err)
 	}
-	log.Printf("Successfully restarted Charon container")
-	return nil
-}
 
-func updateEnvFile(newIP string) error {
-	log.Printf("Updating .env file with new IP: %s", newIP)
-	input, err := os.ReadFile(".env")
-	if err != nil {
-		return fmt.Errorf("failed to read .env file: %v", err)
+	if validateConfig {
+		if err := runValidateConfig(os.Stdout, cfg); err != nil {
+			fatal("Configuration validation failed", err)
+		}
+		return
 	}
 
-	lines := strings.Split(string(input), "\n")
-	found := false
+	shutdownTracing, err := initTracing(ctx)
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
 
-	for i, line := range lines {
-		if strings.HasPrefix(line, envKey+"=") {
-			oldIP := strings.TrimPrefix(line, envKey+"=")
-			lines[i] = fmt.Sprintf("%s=%s", envKey, newIP)
-			found = true
-			log.Printf("Updating IP in .env file: %s -> %s", oldIP, newIP)
-			break
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+	case "status":
+		if err := runStatus(ctx, cfg); err != nil {
+			fatal("Status check failed", err)
+		}
+		return
+	case "export":
+		if err := runExport(cfg, flag.Args()[1:]); err != nil {
+			fatal("Export failed", err)
 		}
+		return
+	case "watch":
+		if err := runWatch(ctx, cfg, flag.Args()[1:]); err != nil {
+			fatal("Watch failed", err)
+		}
+		return
+	case "doctor":
+		if err := runDoctor(ctx, os.Stdout, cfg); err != nil {
+			fatal("Doctor found problems", err)
+		}
+		return
+	default:
+		fatal("Invalid command line", fmt.Errorf("unknown command %q", cmd))
 	}
 
-	if !found {
-		log.Printf("No existing IP entry found in .env file, adding new entry")
-		lines = append(lines, fmt.Sprintf("%s=%s", envKey, newIP))
+	slog.Info("Effective configuration", cfg.summary()...)
+	if cfg.updatesEnv() {
+		if err := checkEnvFile(cfg.EnvFile); err != nil {
+			fatal("Invalid ENV_FILE", err)
+		}
 	}
 
-	output := strings.Join(lines, "\n")
-	if err := os.WriteFile(".env", []byte(output), 0644); err != nil {
-		return fmt.Errorf("failed to write .env file: %v", err)
+	restarter, err := newRestarter(cfg)
+	if err != nil {
+		fatal("Invalid restart configuration", err)
+	}
+	slog.Info("Restart backend", "backend", cfg.RestartBackend, "command", fmt.Sprint(restarter))
+	if cfg.RestartBackend == backendCompose && cfg.updatesEnv() && cfg.RestartEnabled && !skipComposeCheck {
+		checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := checkComposeServices(checkCtx, cfg, commandOutput)
+		cancel()
+		if err != nil {
+			fatal("Invalid compose configuration (pass --skip-compose-check to start anyway)", err)
+		}
+	}
+	if !cfg.RestartEnabled {
+		slog.Warn("RESTART_ENABLED is false: .env will be updated on IP changes but Charon will not be restarted")
+	}
+	if cfg.HealthCheck {
+		slog.Info("Post-restart health check enabled", "url", cfg.HealthCheckURL, "timeout", cfg.HealthCheckTimeout.String())
 	}
 
-	log.Printf("Successfully updated .env file")
+	if cfg.IPTLSInsecure {
+		slog.Warn("IP_TLS_INSECURE is set, IP provider certificates will not be verified")
+	}
+	if cfg.IPTLSPinnedSHA256 != "" {
+		slog.Info("IP provider certificates are pinned", "sha256", cfg.IPTLSPinnedSHA256)
+	}
 
-	if err := restartCharon(); err != nil {
-		return fmt.Errorf("failed to restart Charon after IP update: %v", err)
+	if cfg.AllowPrivateIP {
+		slog.Warn("ALLOW_PRIVATE_IP is set, non-public addresses will be accepted")
 	}
 
-	return nil
-}
+	providers, err := newProviderSet(cfg)
+	if err != nil {
+		fatal("Invalid source address configuration", err)
+	}
+	if cfg.SourceInterface != "" || cfg.SourceIP != "" {
+		slog.Info("Binding IP lookups to a source address", "interface", cfg.SourceInterface, "ip", cfg.SourceIP)
+	}
+	if cfg.IPFetchProxy != "" {
+		slog.Info("Fetching the IP through a proxy", "proxy", cfg.redacted().IPFetchProxy)
+	}
 
-func main() {
-	log.Printf("Starting IP monitoring service...")
-	log.Printf("Check interval: %v", checkInterval)
+	dns, err := newDNSUpdater(cfg)
+	if err != nil {
+		fatal("Invalid DDNS configuration", err)
+	}
+	if dns != nil {
+		slog.Info("Dynamic DNS updates enabled", "provider", cfg.DDNSProvider, "hostname", cfg.DDNSHostname, "update_mode", cfg.UpdateMode)
+	}
 
-	db, err := initDB()
+	notifier, err := newDispatcher(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Invalid notification configuration", err)
+	}
+	if cfg.NotifyWebhookURL != "" {
+		slog.Info("IP change notifications enabled", "type", cfg.NotifyType)
+	}
+	if cfg.SMTPHost != "" {
+		slog.Info("Email notifications enabled", "smtp_host", cfg.SMTPHost, "to", strings.Join(cfg.SMTPTo, ","))
 	}
-	defer db.Close()
 
-	log.Printf("IP monitoring service started successfully")
-	log.Printf("Monitoring IP changes...")
+	lock, err := acquireLock(cfg.DBPath + ".lock")
+	if err != nil {
+		fatal("Failed to acquire instance lock", err)
+	}
+	defer lock.release()
 
-	consecutiveErrors := 0
-	maxConsecutiveErrors := 5
+	sqlStore, db, err := openStore(cfg)
+	if err != nil {
+		fatal("Failed to initialize database", err)
+	}
+	defer db.Close()
 
-	for {
-		currentIP, err := getCurrentIP()
-		if err != nil {
-			consecutiveErrors++
-			log.Printf("Error getting current IP (attempt %d/%d): %v", consecutiveErrors, maxConsecutiveErrors, err)
-
-			if consecutiveErrors >= maxConsecutiveErrors {
-				log.Printf("Multiple consecutive errors detected. Increasing retry interval...")
-				time.Sleep(checkInterval * 2) // Double the wait time after multiple failures
-			} else {
-				time.Sleep(retryInterval)
-			}
-			continue
+	var (
+		store    Store     = sqlStore
+		writer   EnvWriter = newFileEnvWriter(cfg)
+		preHook            = newScriptHook("pre-update", cfg.PreUpdateHook)
+		postHook           = newScriptHook("post-update", cfg.PostUpdateHook)
+	)
+	if dryRun {
+		slog.Warn("Dry run enabled: .env, Charon and the database will not be modified")
+		store = dryRunStore{store}
+		writer = &dryRunEnvWriter{path: cfg.EnvFile, keys: cfg.EnvKeys}
+		restarter = dryRunRestarter{restarter}
+		if dns != nil {
+			dns = dryRunDNSUpdater{dns}
 		}
-		consecutiveErrors = 0 // Reset error counter on successful IP fetch
-
-		// Check if .env and DB are in sync
-		envIP, err := getEnvIP()
-		if err != nil {
-			log.Printf("Warning: Could not get IP from .env: %v", err)
+		if preHook != nil {
+			preHook = dryRunHook{preHook}
+		}
+		if postHook != nil {
+			postHook = dryRunHook{postHook}
 		}
+	}
 
-		var storedIP string
-		err = db.QueryRow("SELECT ip FROM ip_store ORDER BY updated_at DESC LIMIT 1").Scan(&storedIP)
-		if err == sql.ErrNoRows {
-			log.Printf("No IP found in database, storing first IP: %s", currentIP)
-		} else if err != nil {
-			log.Printf("Error querying database: %v", err)
-			log.Printf("Will retry database query in %v...", retryInterval)
-			time.Sleep(retryInterval)
-			continue
+	svc := &Service{
+		cfg:       cfg,
+		fetcher:   providers,
+		store:     store,
+		env:       writer,
+		restarter: restarter,
+		dns:       dns,
+		preHook:   preHook,
+		postHook:  postHook,
+		notifier:  notifier,
+		state:     newCheckState(cfg.ConfirmCount),
+		started:   time.Now(),
+	}
+	defer svc.waitNotifications()
+	startTimeGauge.Set(float64(svc.started.Unix()))
+	if restarted, err := lastRestart(store); err == nil {
+		lastRestartTimestamp.Set(float64(restarted.Unix()))
+	}
+
+	if cfg.HAEnable {
+		if dryRun {
+			slog.Warn("Dry run: not taking part in leader election")
 		} else {
-			log.Printf("Current stored IP: %s", storedIP)
+			slog.Info("High availability enabled", "instance_id", cfg.InstanceID, "lease_duration", cfg.HALeaseDuration.String())
+			svc.lease = newLeaderLease(db, sqlStore.dialect, cfg.InstanceID, cfg.HALeaseDuration)
+			stopLease := svc.lease.start()
+			defer stopLease()
 		}
+	}
 
-		// Update if: no IP in DB, IP changed, or .env is out of sync
-		if err == sql.ErrNoRows ||
-			(err == nil && storedIP != currentIP) ||
-			(envIP != "" && envIP != storedIP) {
-
-			if err := updateEnvFile(currentIP); err != nil {
-				log.Printf("Error updating .env file: %v", err)
-				log.Printf("Retrying in %v...", retryInterval)
-				time.Sleep(retryInterval)
-				continue
-			}
-
-			_, err = db.Exec("INSERT INTO ip_store (ip) VALUES (?)", currentIP)
-			if err != nil {
-				log.Printf("Error storing IP in database: %v", err)
-			} else {
-				log.Printf("Successfully stored new IP in database: %s", currentIP)
-			}
-		} else {
-			log.Printf("No IP change detected. Current IP: %s", currentIP)
+	if cfg.CharonENRURL != "" {
+		slog.Info("Comparing against the IP in Charon's ENR", "url", cfg.CharonENRURL)
+		svc.enr = newCharonENR(cfg.CharonENRURL)
+	}
+
+	if cfg.CheckReachability {
+		slog.Info("P2P reachability check enabled", "port", cfg.P2PPort, "required", cfg.RequireReachable)
+		svc.reach = newReachabilityProbe(cfg.P2PPort)
+	}
+
+	if once {
+		slog.Info("Running a single check")
+		// There are no previous readings to confirm against in one-shot mode
+		svc.state = newCheckState(1)
+		if err := svc.Check(ctx); err != nil {
+			slog.Error("Check failed", "error", err)
+			svc.waitNotifications()
+			db.Close()
+			lock.release()
+			os.Exit(1)
 		}
+		slog.Info("Check completed successfully")
+		return
+	}
 
-		log.Printf("Waiting %v before next check...", checkInterval)
-		time.Sleep(checkInterval)
+	slog.Info("IP monitoring service started successfully")
+
+	if cfg.MetricsAddr != "" {
+		registerMetrics(prometheus.DefaultRegisterer)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		startHTTPServer(ctx, "metrics", cfg.MetricsAddr, mux)
+	}
+
+	live := newLiveness(func() time.Duration { return 2 * svc.config().CheckInterval })
+	if cfg.HealthAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", live)
+		startHTTPServer(ctx, "liveness probe", cfg.HealthAddr, mux)
 	}
+
+	if cfg.APIAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/ip", historyHandler(store))
+		mux.Handle("/events", eventsHandler(store))
+		mux.Handle("/status", runtimeHandler(svc))
+		startHTTPServer(ctx, "API", cfg.APIAddr, mux)
+	}
+
+	if cfg.GRPCAddr != "" {
+		startGRPCServer(ctx, cfg.GRPCAddr, svc)
+	}
+
+	if cfg.ControlSocket != "" {
+		startControlSocket(ctx, cfg.ControlSocket, svc)
+	}
+
+	// SIGHUP reloads the configuration and triggers an immediate check
+	// instead of terminating. The channel holds a single signal, so a burst
+	// of them triggers one check.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	svc.live = live
+	if sd := newSystemdNotifier(); sd != nil {
+		if sd.watchdogInterval > 0 {
+			slog.Info("systemd watchdog enabled", "interval", sd.watchdogInterval.String())
+		}
+		svc.systemd = sd
+	}
+	svc.recheck = hup
+	svc.reload = func() (*Config, error) { return loadConfig(configPath) }
+	err = svc.Run(ctx)
+	if errors.Is(err, errMaxRuntime) {
+		slog.Info("Maximum runtime reached, exiting for a fresh start", "max_runtime", svc.config().MaxRuntime.String(), "uptime", svc.uptime().Round(time.Second).String())
+		return
+	}
+	if err != nil {
+		slog.Error("Giving up", "error", err)
+		svc.waitNotifications()
+		db.Close()
+		lock.release()
+		os.Exit(1)
+	}
+
+	slog.Info("Received shutdown signal, shutting down gracefully")
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	d := newDebouncer(3)
+
+	steps := []struct {
+		ip   string
+		want bool
+	}{
+		{"2.2.2.2", false},
+		{"2.2.2.2", false},
+		{"3.3.3.3", false}, // flipped to a different candidate, streak restarts
+		{"3.3.3.3", false},
+		{"3.3.3.3", true},
+		{"3.3.3.3", false}, // confirmation resets the streak
+	}
+
+	for i, step := range steps {
+		if got := d.observe(step.ip); got != step.want {
+			t.Fatalf("step %d: observe(%s) = %v, want %v", i, step.ip, got, step.want)
+		}
+	}
+
+	d.reset()
+	d.observe("4.4.4.4")
+	d.reset()
+	if d.observe("4.4.4.4") {
+		t.Fatal("reset should discard the pending streak")
+	}
+}
+
+func TestDebouncerSingleReading(t *testing.T) {
+	d := newDebouncer(1)
+	if !d.observe("2.2.2.2") {
+		t.Fatal("a required count of 1 should confirm immediately")
+	}
+}
+
+func TestBackoffDuration(t *testing.T) {
+	// Returning n-1 makes the jitter pick the ceiling itself.
+	maxJitter := func(n int64) int64 { return n - 1 }
+	noJitter := func(int64) int64 { return 0 }
+
+	base := 5 * time.Second
+	max := time.Minute
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, time.Minute},
+		{50, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := backoffDuration(tt.attempt, base, max, maxJitter); got != tt.want {
+			t.Errorf("backoffDuration(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+		if got := backoffDuration(tt.attempt, base, max, noJitter); got != 0 {
+			t.Errorf("backoffDuration(%d) with zero jitter = %v, want 0", tt.attempt, got)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	maxJitter := func(n int64) int64 { return n - 1 }
+
+	if got := jitter(time.Minute, maxJitter); got != time.Minute {
+		t.Errorf("jitter at ceiling = %v, want 1m", got)
+	}
+	if got := jitter(0, maxJitter); got != 0 {
+		t.Errorf("jitter(0) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultMetricsAddr = ":9101"
+
+var (
+	checksTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipupdater_checks_total",
+		Help: "Total number of IP checks performed.",
+	})
+	ipChangesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipupdater_ip_changes_total",
+		Help: "Total number of IP changes applied to .env.",
+	})
+	restartFailuresTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ipupdater_restart_failures_total",
+		Help: "Total number of failed Charon restarts.",
+	})
+	lastCheckTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipupdater_last_check_timestamp_seconds",
+		Help: "Unix timestamp of the last completed IP check.",
+	})
+	consecutiveErrorsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipupdater_consecutive_errors",
+		Help: "Number of consecutive failures to determine the current IP.",
+	})
+	startTimeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipupdater_start_time_seconds",
+		Help: "Unix timestamp of when the updater started.",
+	})
+	providerRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipupdater_provider_requests_total",
+		Help: "Total number of requests to each IP provider, by result: success, error, rejected (wrong IP version or non-public) or skipped (circuit open).",
+	}, []string{"provider", "result"})
+	lastRestartTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ipupdater_last_restart_timestamp_seconds",
+		Help: "Unix timestamp of the last successful Charon restart.",
+	})
+)
+
+func registerMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(
+		checksTotal,
+		ipChangesTotal,
+		restartFailuresTotal,
+		lastCheckTimestamp,
+		consecutiveErrorsGauge,
+		startTimeGauge,
+		lastRestartTimestamp,
+		providerRequestsTotal,
+	)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// migration is one step of schema evolution. Steps are applied in order and
+// recorded in schema_version, so a database created by an older release
+// receives exactly the steps it is missing. Released steps must never be
+// edited or reordered; changes go into a new step appended at the end.
+type migration struct {
+	description string
+	statements  func(d dialect) []string
+}
+
+var migrations = []migration{
+	{
+		// Releases before schema versioning created this table on every
+		// start, hence IF NOT EXISTS.
+		description: "create ip_store",
+		statements: func(d dialect) []string {
+			return []string{fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS ip_store (
+				id %s,
+				ip TEXT NOT NULL,
+				updated_at %s
+			)`, d.idColumn, d.timestampColumn)}
+		},
+	},
+	{
+		description: "create events",
+		statements: func(d dialect) []string {
+			return []string{fmt.Sprintf(`
+			CREATE TABLE IF NOT EXISTS events (
+				id %s,
+				type TEXT NOT NULL,
+				ip TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT '',
+				created_at %s
+			)`, d.idColumn, d.timestampColumn)}
+		},
+	},
+	{
+		// NULL for rows stored before the columns existed.
+		description: "record provider and latency in ip_store",
+		statements: func(d dialect) []string {
+			return []string{
+				"ALTER TABLE ip_store ADD COLUMN provider TEXT",
+				"ALTER TABLE ip_store ADD COLUMN latency_ms INTEGER",
+			}
+		},
+	},
+	{
+		// expires_at is in Unix milliseconds, so it compares the same way
+		// in every dialect.
+		description: "create leader_lease",
+		statements: func(d dialect) []string {
+			return []string{`
+			CREATE TABLE IF NOT EXISTS leader_lease (
+				name TEXT PRIMARY KEY,
+				holder TEXT NOT NULL,
+				expires_at BIGINT NOT NULL
+			)`}
+		},
+	},
+	{
+		// NULL for rows stored before the columns existed.
+		description: "record previous IP and reason in ip_store",
+		statements: func(d dialect) []string {
+			return []string{
+				"ALTER TABLE ip_store ADD COLUMN previous_ip TEXT",
+				"ALTER TABLE ip_store ADD COLUMN reason TEXT",
+			}
+		},
+	},
+	{
+		description: "create meta",
+		statements: func(d dialect) []string {
+			return []string{`
+			CREATE TABLE IF NOT EXISTS meta (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`}
+		},
+	},
+}
+
+// migrate brings the schema of db up to date, applying each pending
+// migration in its own transaction. It refuses to touch a database whose
+// schema is newer than this binary knows about.
+func migrate(db *sql.DB, d dialect) error {
+	if _, err := db.Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		applied_at %s
+	)`, d.timestampColumn)); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %v", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this release supports (%d)", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		if err := applyMigration(db, d, version, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", version, migrations[i].description, err)
+		}
+		slog.Info("Applied database migration", "version", version, "description", migrations[i].description)
+	}
+	return nil
+}
+
+// schemaVersion returns the number of migrations applied to db.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return version, nil
+}
+
+// appliedSchemaVersion is schemaVersion for a database that may never have
+// been migrated, such as one created before schema versioning: without a
+// schema_version table it is at version 0.
+func appliedSchemaVersion(db *sql.DB, d dialect) (int, error) {
+	var tables int
+	if err := db.QueryRow(d.rebind(d.tableExists), "schema_version").Scan(&tables); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	return schemaVersion(db)
+}
+
+func applyMigration(db *sql.DB, d dialect, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range m.statements(d) {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	// The primary key makes a concurrent run applying the same step fail
+	// here rather than commit it twice.
+	if _, err := tx.Exec(d.rebind("INSERT INTO schema_version (version) VALUES (?)"), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
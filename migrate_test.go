@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := migrate(db, sqliteDialect); err != nil {
+		t.Fatalf("second migrate: %v", err)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("schema version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	// A database created before schema versioning has ip_store but no
+	// schema_version table.
+	path := filepath.Join(t.TempDir(), "ip_store.db")
+	db, err := sql.Open(sqliteDialect.driver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE ip_store (id INTEGER PRIMARY KEY, ip TEXT NOT NULL, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO ip_store (ip) VALUES ('1.1.1.1')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, sqliteDialect); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	ip, err := (&sqlStore{dialect: sqliteDialect, db: db}).LatestIP()
+	if err != nil || ip != "1.1.1.1" {
+		t.Errorf("LatestIP = %q, %v; existing rows should survive", ip, err)
+	}
+	if err := (&sqlStore{dialect: sqliteDialect, db: db}).RecordEvent(eventRecord{Type: eventTypeIPChange}); err != nil {
+		t.Errorf("events table missing after migration: %v", err)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("INSERT INTO schema_version (version) VALUES (?)", len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, sqliteDialect); err == nil {
+		t.Fatal("expected an error for a schema newer than the binary")
+	}
+}
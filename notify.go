@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	notifyTimeout = 5 * time.Second
+	// A failed delivery is attempted up to notifyAttempts times in all,
+	// backing off exponentially from notifyRetryBackoff, and given up on
+	// after notifyDeadline.
+	notifyAttempts     = 3
+	notifyRetryBackoff = 2 * time.Second
+	notifyDeadline     = 30 * time.Second
+
+	notifyGeneric = "generic"
+	notifySlack   = "slack"
+	notifyDiscord = "discord"
+)
+
+// Notification kinds and their severities. Failures are critical so they
+// can be routed to a paging channel.
+const (
+	eventIPChange      = "ip_change"
+	eventUpdateFailure = "update_failure"
+	eventFlapping      = "flapping"
+	severityInfo       = "info"
+	severityCritical   = "critical"
+)
+
+// Notification describes an event worth telling the operator about.
+type Notification struct {
+	Event    string
+	Severity string
+	OldIP    string
+	NewIP    string
+	Error    string
+	Time     time.Time
+	// Count is the number of notifications a flapping summary stands for;
+	// the other fields are those of the latest one.
+	Count int
+}
+
+// Message renders the notification as a human-readable line.
+func (n Notification) Message() string {
+	if n.Event == eventFlapping {
+		return fmt.Sprintf("⚠️ Flapping detected: %d notifications suppressed, latest %s to %s", n.Count, n.OldIP, n.NewIP)
+	}
+	if n.Event == eventUpdateFailure {
+		return fmt.Sprintf("🚨 Failed to apply Charon external IP change from %s to %s: %s", n.OldIP, n.NewIP, n.Error)
+	}
+	return fmt.Sprintf("🔄 Charon external IP changed from %s to %s", n.OldIP, n.NewIP)
+}
+
+// Notifier delivers a notification to a single destination.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+type ipChangePayload struct {
+	Event     string    `json:"event"`
+	Severity  string    `json:"severity"`
+	OldIP     string    `json:"old_ip"`
+	NewIP     string    `json:"new_ip"`
+	Error     string    `json:"error,omitempty"`
+	Count     int       `json:"count,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+type discordPayload struct {
+	Content string `json:"content"`
+}
+
+// webhookNotifier POSTs a JSON payload to a URL, shaped by format for the
+// receiving service.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+	format func(Notification) any
+}
+
+func newWebhookNotifier(url, kind string) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+
+	switch kind {
+	case notifyGeneric:
+		n.format = func(event Notification) any {
+			return ipChangePayload{
+				Event:     event.Event,
+				Severity:  event.Severity,
+				OldIP:     event.OldIP,
+				NewIP:     event.NewIP,
+				Error:     event.Error,
+				Count:     event.Count,
+				Timestamp: event.Time,
+			}
+		}
+	case notifySlack:
+		n.format = func(event Notification) any {
+			return slackPayload{Text: event.Message()}
+		}
+	case notifyDiscord:
+		n.format = func(event Notification) any {
+			return discordPayload{Content: event.Message()}
+		}
+	default:
+		return nil, fmt.Errorf("unknown notification type %q", kind)
+	}
+
+	return n, nil
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, event Notification) error {
+	body, err := json.Marshal(n.format(event))
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// dispatcher fans notifications out to every configured Notifier in the
+// background, so a slow or failing destination never blocks the update loop.
+// A nil dispatcher is valid and drops all notifications.
+//
+// With a minInterval, a notification arriving within minInterval of the
+// last one of its kind is held back instead. When the interval is up, a
+// single held notification is sent as is, and several are coalesced into a
+// flapping summary.
+type dispatcher struct {
+	notifiers   []Notifier
+	minInterval time.Duration
+	// retryBackoff is the delay before the first retry of a failed
+	// delivery.
+	retryBackoff time.Duration
+	wg           sync.WaitGroup
+
+	mu       sync.Mutex
+	lastSent map[string]time.Time
+	held     map[string]*heldNotifications
+}
+
+// heldNotifications are the suppressed notifications of one kind, waiting
+// for timer to flush them.
+type heldNotifications struct {
+	latest Notification
+	count  int
+	timer  *time.Timer
+}
+
+// newDispatcher builds the notifiers enabled in cfg, returning nil if there
+// are none.
+func newDispatcher(cfg *Config) (*dispatcher, error) {
+	var notifiers []Notifier
+
+	if cfg.NotifyWebhookURL != "" {
+		webhook, err := newWebhookNotifier(cfg.NotifyWebhookURL, cfg.NotifyType)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+
+	if cfg.SMTPHost != "" {
+		notifiers = append(notifiers, newSMTPNotifier(cfg))
+	}
+
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+	return &dispatcher{
+		notifiers:    notifiers,
+		minInterval:  cfg.NotifyMinInterval,
+		retryBackoff: notifyRetryBackoff,
+		lastSent:     make(map[string]time.Time),
+		held:         make(map[string]*heldNotifications),
+	}, nil
+}
+
+func (d *dispatcher) notifyIPChange(oldIP, newIP string) {
+	d.send(Notification{
+		Event:    eventIPChange,
+		Severity: severityInfo,
+		OldIP:    oldIP,
+		NewIP:    newIP,
+		Time:     time.Now().UTC(),
+	})
+}
+
+// notifyFailure reports that applying an IP change failed, e.g. because the
+// restart command errored, leaving the node potentially unreachable.
+func (d *dispatcher) notifyFailure(oldIP, newIP string, err error) {
+	d.send(Notification{
+		Event:    eventUpdateFailure,
+		Severity: severityCritical,
+		OldIP:    oldIP,
+		NewIP:    newIP,
+		Error:    err.Error(),
+		Time:     time.Now().UTC(),
+	})
+}
+
+func (d *dispatcher) send(event Notification) {
+	if d == nil {
+		return
+	}
+
+	if d.minInterval > 0 {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		if h := d.held[event.Event]; h != nil {
+			h.latest = event
+			h.count++
+			return
+		}
+		if last, ok := d.lastSent[event.Event]; ok {
+			if remaining := d.minInterval - time.Since(last); remaining > 0 {
+				slog.Info("Notification rate limited, holding it back", "event", event.Event, "delay", remaining.String())
+				h := &heldNotifications{latest: event, count: 1}
+				h.timer = time.AfterFunc(remaining, func() { d.flush(event.Event) })
+				d.held[event.Event] = h
+				return
+			}
+		}
+		d.lastSent[event.Event] = time.Now()
+	}
+
+	d.deliver(event)
+}
+
+// flush sends the notifications of kind held back by send.
+func (d *dispatcher) flush(kind string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.held[kind]
+	if h == nil {
+		return
+	}
+	delete(d.held, kind)
+	h.timer.Stop()
+	d.lastSent[kind] = time.Now()
+
+	event := h.latest
+	if h.count > 1 {
+		event.Event = eventFlapping
+		event.Count = h.count
+	}
+	d.deliver(event)
+}
+
+// deliver hands event to every notifier in the background.
+func (d *dispatcher) deliver(event Notification) {
+	for _, notifier := range d.notifiers {
+		d.wg.Add(1)
+		go func(notifier Notifier) {
+			defer d.wg.Done()
+			d.deliverTo(notifier, event)
+		}(notifier)
+	}
+}
+
+// deliverTo sends event to notifier, retrying a failed attempt with a
+// jittered, exponential backoff until notifyAttempts or notifyDeadline is
+// reached.
+func (d *dispatcher) deliverTo(notifier Notifier, event Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyDeadline)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, notifyTimeout)
+		err := notifier.Notify(attemptCtx, event)
+		cancelAttempt()
+		if err == nil {
+			slog.Info("Sent notification", "event", event.Event, "old_ip", event.OldIP, "ip", event.NewIP)
+			return
+		}
+		if attempt == notifyAttempts {
+			slog.Error("Failed to send notification, giving up", "event", event.Event, "attempts", attempt, "error", err)
+			return
+		}
+
+		backoff := d.retryBackoff << (attempt - 1)
+		delay := backoff/2 + jitter(backoff/2, rand.Int64N)
+		slog.Warn("Failed to send notification, retrying", "event", event.Event, "attempt", attempt, "delay", delay.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			slog.Error("Failed to send notification, giving up", "event", event.Event, "attempts", attempt, "error", err)
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// adopt takes over the rate limiting state of old, the dispatcher a reload
+// replaces, so a reload neither resets NOTIFY_MIN_INTERVAL nor sends held
+// notifications early. Deliveries still in flight on old finish in the
+// background, and wait waits for them too. If d is nil, notifications are
+// now disabled and old's held notifications are dropped.
+func (d *dispatcher) adopt(old *dispatcher) {
+	if old == nil {
+		return
+	}
+
+	old.mu.Lock()
+	lastSent, held := old.lastSent, old.held
+	for _, h := range held {
+		h.timer.Stop()
+	}
+	old.held = make(map[string]*heldNotifications)
+	old.mu.Unlock()
+
+	if d == nil {
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		old.wg.Wait()
+	}()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for kind, last := range lastSent {
+		d.lastSent[kind] = last
+	}
+	for kind, h := range held {
+		remaining := d.minInterval - time.Since(d.lastSent[kind])
+		h.timer = time.AfterFunc(max(remaining, 0), func() { d.flush(kind) })
+		d.held[kind] = h
+	}
+}
+
+// wait flushes held notifications and blocks until in-flight deliveries
+// finish, so short-lived runs such as --once don't exit before their
+// notification is sent.
+func (d *dispatcher) wait() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	kinds := make([]string, 0, len(d.held))
+	for kind := range d.held {
+		kinds = append(kinds, kind)
+	}
+	d.mu.Unlock()
+	for _, kind := range kinds {
+		d.flush(kind)
+	}
+
+	d.wg.Wait()
+}
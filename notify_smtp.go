@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSMTPPort = 587
+
+// smtpNotifier emails notifications to a fixed list of recipients. It
+// upgrades the connection with STARTTLS whenever the server offers it and
+// authenticates only if a user is configured; smtp.PlainAuth refuses to
+// send credentials over an unencrypted connection to a remote host.
+type smtpNotifier struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from string
+	to   []string
+	// tlsConfig is used for STARTTLS; tests replace it to trust their
+	// server.
+	tlsConfig *tls.Config
+}
+
+func newSMTPNotifier(cfg *Config) *smtpNotifier {
+	n := &smtpNotifier{
+		addr:      net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+		host:      cfg.SMTPHost,
+		from:      cfg.SMTPFrom,
+		to:        cfg.SMTPTo,
+		tlsConfig: &tls.Config{ServerName: cfg.SMTPHost},
+	}
+	if cfg.SMTPUser != "" {
+		n.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPHost)
+	}
+	return n
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, event Notification) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		return fmt.Errorf("failed to greet SMTP server: %v", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(n.tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+	if n.auth != nil {
+		if err := client.Auth(n.auth); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(n.from); err != nil {
+		return fmt.Errorf("sender rejected: %v", err)
+	}
+	for _, to := range n.to {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.message(event)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %v", err)
+	}
+	return client.Quit()
+}
+
+// message renders event as a plain-text email.
+func (n *smtpNotifier) message(event Notification) []byte {
+	var subject string
+	switch event.Event {
+	case eventUpdateFailure:
+		subject = fmt.Sprintf("Failed to apply Charon external IP %s", event.NewIP)
+	case eventFlapping:
+		subject = fmt.Sprintf("Charon external IP flapping, latest %s", event.NewIP)
+	default:
+		subject = fmt.Sprintf("Charon external IP changed to %s", event.NewIP)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&b, "Subject: [obol-ip-updater] %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "%s\r\n\r\n", event.Message())
+	fmt.Fprintf(&b, "Event: %s\r\nSeverity: %s\r\nOld IP: %s\r\nNew IP: %s\r\n", event.Event, event.Severity, event.OldIP, event.NewIP)
+	if event.Error != "" {
+		fmt.Fprintf(&b, "Error: %s\r\n", event.Error)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single session, offering STARTTLS and AUTH PLAIN,
+// and records what the client sent.
+type fakeSMTPServer struct {
+	listener net.Listener
+	tls      *tls.Config
+	done     chan struct{}
+
+	startedTLS bool
+	auth       string
+	from       string
+	to         []string
+	data       string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	// httptest supplies a certificate valid for 127.0.0.1.
+	https := httptest.NewUnstartedServer(nil)
+	https.StartTLS()
+	t.Cleanup(https.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &fakeSMTPServer{listener: listener, tls: https.TLS, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTPServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if s.startedTLS {
+				tp.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+			} else {
+				tp.PrintfLine("250-localhost\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			s.startedTLS = true
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			s.auth = string(decoded)
+			tp.PrintfLine("235 Authenticated")
+		case "MAIL":
+			s.from = arg
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			s.to = append(s.to, arg)
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.data = string(data)
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	srv := newFakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(srv.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	n := newSMTPNotifier(&Config{
+		SMTPHost: host,
+		SMTPPort: portNum,
+		SMTPUser: "updater",
+		SMTPPass: "secret",
+		SMTPFrom: "updater@example.com",
+		SMTPTo:   []string{"ops@example.com", "oncall@example.com"},
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(mustParseCert(t, srv.tls.Certificates[0].Certificate[0]))
+	n.tlsConfig = &tls.Config{ServerName: host, RootCAs: roots}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	event := Notification{
+		Event:    eventUpdateFailure,
+		Severity: severityCritical,
+		OldIP:    "1.1.1.1",
+		NewIP:    "2.2.2.2",
+		Error:    "restart failed",
+		Time:     time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+	if err := n.Notify(ctx, event); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	<-srv.done
+
+	if !srv.startedTLS {
+		t.Error("client did not use STARTTLS")
+	}
+	if srv.auth != "\x00updater\x00secret" {
+		t.Errorf("auth = %q, want PLAIN credentials", srv.auth)
+	}
+	if srv.from != "FROM:<updater@example.com>" {
+		t.Errorf("MAIL %s", srv.from)
+	}
+	if len(srv.to) != 2 {
+		t.Errorf("RCPT = %v, want both recipients", srv.to)
+	}
+	for _, want := range []string{
+		"Subject: [obol-ip-updater] Failed to apply Charon external IP 2.2.2.2",
+		"To: ops@example.com, oncall@example.com",
+		"Error: restart failed",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("message missing %q:\n%s", want, srv.data)
+		}
+	}
+}
+
+func mustParseCert(t *testing.T, der []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifierFormats(t *testing.T) {
+	event := Notification{
+		Event:    eventIPChange,
+		Severity: severityInfo,
+		OldIP:    "1.1.1.1",
+		NewIP:    "2.2.2.2",
+		Time:     time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		kind string
+		want string
+	}{
+		{notifyGeneric, `{"event":"ip_change","severity":"info","old_ip":"1.1.1.1","new_ip":"2.2.2.2","timestamp":"2024-01-15T12:00:00Z"}`},
+		{notifySlack, `{"text":"🔄 Charon external IP changed from 1.1.1.1 to 2.2.2.2"}`},
+		{notifyDiscord, `{"content":"🔄 Charon external IP changed from 1.1.1.1 to 2.2.2.2"}`},
+	}
+
+	for _, tt := range tests {
+		var got []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = io.ReadAll(r.Body)
+		}))
+
+		n, err := newWebhookNotifier(srv.URL, tt.kind)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.kind, err)
+		}
+		if err := n.Notify(context.Background(), event); err != nil {
+			t.Fatalf("%s: Notify: %v", tt.kind, err)
+		}
+		srv.Close()
+
+		if string(got) != tt.want {
+			t.Errorf("%s payload = %s, want %s", tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookNotifierRejectsUnknownType(t *testing.T) {
+	if _, err := newWebhookNotifier("http://example.invalid", "teams"); err == nil {
+		t.Fatal("expected an error for an unknown notification type")
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	received := make(chan ipChangePayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload ipChangePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %v", err)
+		}
+		received <- payload
+	}))
+	defer srv.Close()
+
+	d, err := newDispatcher(&Config{NotifyWebhookURL: srv.URL, NotifyType: notifyGeneric})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.notifyIPChange("1.1.1.1", "2.2.2.2")
+	d.wait()
+
+	payload := <-received
+	if payload.OldIP != "1.1.1.1" || payload.NewIP != "2.2.2.2" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestNilDispatcher(t *testing.T) {
+	d, err := newDispatcher(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != nil {
+		t.Fatal("expected nil dispatcher when no notifiers are configured")
+	}
+	// Must not panic.
+	d.notifyIPChange("1.1.1.1", "2.2.2.2")
+	d.wait()
+}
+
+func TestFailureNotificationMessage(t *testing.T) {
+	event := Notification{
+		Event:    eventUpdateFailure,
+		Severity: severityCritical,
+		OldIP:    "1.1.1.1",
+		NewIP:    "2.2.2.2",
+		Error:    "docker: not found",
+	}
+
+	want := "🚨 Failed to apply Charon external IP change from 1.1.1.1 to 2.2.2.2: docker: not found"
+	if got := event.Message(); got != want {
+		t.Fatalf("Message() = %q, want %q", got, want)
+	}
+}
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []Notification
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, event Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return nil
+}
+
+func TestDispatcherRateLimits(t *testing.T) {
+	rec := &recordingNotifier{}
+	d := &dispatcher{
+		notifiers:   []Notifier{rec},
+		minInterval: 50 * time.Millisecond,
+		lastSent:    make(map[string]time.Time),
+		held:        make(map[string]*heldNotifications),
+	}
+
+	d.notifyIPChange("1.1.1.1", "2.2.2.2")
+	d.notifyIPChange("2.2.2.2", "1.1.1.1")
+	d.notifyIPChange("1.1.1.1", "2.2.2.2")
+	d.notifyFailure("1.1.1.1", "2.2.2.2", errors.New("restart failed"))
+	d.notifyFailure("1.1.1.1", "2.2.2.2", errors.New("restart failed"))
+
+	// The held failure is sent on its own once the interval is up, the
+	// two held changes as a flapping summary.
+	time.Sleep(100 * time.Millisecond)
+	d.wait()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var got []string
+	for _, event := range rec.events {
+		got = append(got, fmt.Sprintf("%s/%d", event.Event, event.Count))
+	}
+	sort.Strings(got)
+	want := []string{"flapping/2", "ip_change/0", "update_failure/0", "update_failure/0"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestDispatcherWaitFlushesHeld(t *testing.T) {
+	rec := &recordingNotifier{}
+	d := &dispatcher{
+		notifiers:   []Notifier{rec},
+		minInterval: time.Hour,
+		lastSent:    make(map[string]time.Time),
+		held:        make(map[string]*heldNotifications),
+	}
+
+	d.notifyIPChange("1.1.1.1", "2.2.2.2")
+	d.notifyIPChange("2.2.2.2", "3.3.3.3")
+	d.wait()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.events) != 2 {
+		t.Errorf("sent %+v, want the held notification flushed", rec.events)
+	}
+}
+
+func TestDispatcherAdoptKeepsRateLimit(t *testing.T) {
+	rec := &recordingNotifier{}
+	newHourly := func() *dispatcher {
+		return &dispatcher{
+			notifiers:   []Notifier{rec},
+			minInterval: time.Hour,
+			lastSent:    make(map[string]time.Time),
+			held:        make(map[string]*heldNotifications),
+		}
+	}
+	old := newHourly()
+	old.notifyIPChange("1.1.1.1", "2.2.2.2")
+	old.notifyIPChange("2.2.2.2", "3.3.3.3")
+
+	// As on a reload: the held notification must stay held, and a new one
+	// still falls within the interval of the one sent before.
+	d := newHourly()
+	d.adopt(old)
+	d.notifyIPChange("3.3.3.3", "4.4.4.4")
+	time.Sleep(20 * time.Millisecond)
+	rec.mu.Lock()
+	sent := len(rec.events)
+	rec.mu.Unlock()
+	if sent != 1 {
+		t.Fatalf("sent %d notifications across the reload, want only the first", sent)
+	}
+
+	d.wait()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.events) != 2 || rec.events[1].Event != eventFlapping || rec.events[1].Count != 2 {
+		t.Errorf("sent %+v, want the held notifications coalesced by the new dispatcher", rec.events)
+	}
+}
+
+// flakyNotifier fails its first failures deliveries.
+type flakyNotifier struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (n *flakyNotifier) Notify(ctx context.Context, event Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.attempts++
+	if n.attempts <= n.failures {
+		return errors.New("endpoint down")
+	}
+	return nil
+}
+
+func TestDispatcherRetriesFailedDelivery(t *testing.T) {
+	for _, tt := range []struct {
+		failures, wantAttempts int
+	}{
+		{failures: 0, wantAttempts: 1},
+		{failures: 2, wantAttempts: 3},
+		{failures: 5, wantAttempts: notifyAttempts},
+	} {
+		n := &flakyNotifier{failures: tt.failures}
+		d := &dispatcher{notifiers: []Notifier{n}, retryBackoff: time.Millisecond}
+
+		d.notifyIPChange("1.1.1.1", "2.2.2.2")
+		d.wait()
+
+		if n.attempts != tt.wantAttempts {
+			t.Errorf("with %d failures: %d attempts, want %d", tt.failures, n.attempts, tt.wantAttempts)
+		}
+	}
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ipifyAPI      = "https://api.ipify.org?format=json"
+	ipify6API     = "https://api6.ipify.org?format=json"
+	ipVersion4    = "4"
+	ipVersion6    = "6"
+	ipVersionDual = "dual"
+
+	// defaultIPJSONField is the key holding the address in JSON responses,
+	// as returned by ipify and most similar services.
+	defaultIPJSONField = "ip"
+)
+
+// defaultProviders are tried in order until one of them returns an IP.
+var defaultProviders = []string{
+	ipifyAPI,
+	"https://icanhazip.com",
+	"https://ifconfig.co/json",
+}
+
+// defaultIPv6Providers are used to detect the IPv6 address. Requests to them
+// are forced over IPv6 regardless of what the endpoint supports.
+var defaultIPv6Providers = []string{
+	ipify6API,
+	"https://icanhazip.com",
+	"https://ifconfig.co/json",
+}
+
+// IPProvider is a source for the host's current public IP address.
+type IPProvider interface {
+	Fetch(ctx context.Context) (string, error)
+}
+
+// httpProvider fetches the IP from an HTTP endpoint returning either a JSON
+// object such as {"ip": "..."}, with the address under jsonField, or the
+// bare address as plain text.
+type httpProvider struct {
+	url       string
+	userAgent string
+	headers   http.Header
+	jsonField string
+	client    *http.Client
+}
+
+// fetchOptions configure how providers reach their endpoints.
+type fetchOptions struct {
+	timeout time.Duration
+	// userAgent identifies HTTP requests, as some providers block Go's
+	// default one.
+	userAgent string
+	// headers are added to every HTTP request.
+	headers http.Header
+	// jsonField is the key holding the address in JSON responses.
+	jsonField string
+	// localAddr, if non-nil, pins the source address of every connection.
+	localAddr net.IP
+	// proxy, if non-nil, carries HTTP requests instead of the HTTP_PROXY
+	// and HTTPS_PROXY environment variables. STUN queries are never proxied.
+	proxy *url.URL
+	// tlsInsecure skips certificate verification. pinnedSHA256, if set, is
+	// the SHA-256 fingerprint the provider's leaf certificate must have.
+	tlsInsecure  bool
+	pinnedSHA256 []byte
+}
+
+// newProviders builds providers for urls whose connections are forced onto
+// the given IP version, so dual-stack endpoints report the right address.
+// Entries of the form stun:host:port query a STUN server, aws-metadata and
+// gcp-metadata the cloud instance metadata service; anything else is an
+// HTTP endpoint. All HTTP providers share one transport.
+func newProviders(urls []string, version string, opts fetchOptions) []IPProvider {
+	network, udpNetwork := "tcp4", "udp4"
+	if version == ipVersion6 {
+		network, udpNetwork = "tcp6", "udp6"
+	}
+
+	dialer := &net.Dialer{Timeout: opts.timeout}
+	if opts.localAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: opts.localAddr}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if opts.proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.proxy)
+	}
+	if opts.tlsInsecure || opts.pinnedSHA256 != nil {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.tlsInsecure}
+		if opts.pinnedSHA256 != nil {
+			transport.TLSClientConfig.VerifyPeerCertificate = verifyPinnedCert(opts.pinnedSHA256)
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   opts.timeout,
+		Transport: transport,
+	}
+
+	providers := make([]IPProvider, 0, len(urls))
+	for _, url := range urls {
+		switch url {
+		case awsMetadataProvider:
+			providers = append(providers, &awsMetadata{endpoint: awsMetadataEndpoint, client: newMetadataClient(opts.timeout)})
+			continue
+		case gcpMetadataProvider:
+			providers = append(providers, &gcpMetadata{endpoint: gcpMetadataEndpoint, client: newMetadataClient(opts.timeout)})
+			continue
+		}
+		if isSTUNProvider(url) {
+			server := strings.TrimPrefix(url, stunScheme)
+			providers = append(providers, &stunProvider{server: server, network: udpNetwork, timeout: opts.timeout, localAddr: opts.localAddr})
+			continue
+		}
+		providers = append(providers, &httpProvider{url: url, userAgent: opts.userAgent, headers: opts.headers, jsonField: opts.jsonField, client: client})
+	}
+	return providers
+}
+
+// verifyPinnedCert returns a VerifyPeerCertificate callback rejecting servers
+// whose leaf certificate does not have the given SHA-256 fingerprint. It runs
+// after, not instead of, the usual chain verification.
+func verifyPinnedCert(pin []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], pin) {
+			return fmt.Errorf("certificate fingerprint %x does not match IP_TLS_PINNED_SHA256", sum)
+		}
+		return nil
+	}
+}
+
+// parseHeaders parses "Name: value" pairs separated by semicolons, as in
+// IP_FETCH_HEADERS.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not of the form Name: value", strings.TrimSpace(pair))
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if err := checkHeader(name, value); err != nil {
+			return nil, err
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// checkHeader rejects header names that are not HTTP tokens and values
+// that would break out of the header line.
+func checkHeader(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("empty header name")
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("header %s has a line break in its value", name)
+	}
+	return nil
+}
+
+// parseFingerprint decodes a hex SHA-256 fingerprint, with or without the
+// colons openssl prints between bytes.
+func parseFingerprint(s string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("not a hex fingerprint: %v", err)
+	}
+	if len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", sha256.Size, len(fingerprint))
+	}
+	return fingerprint, nil
+}
+
+func (p *httpProvider) String() string {
+	return p.url
+}
+
+func (p *httpProvider) Fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %v", err)
+	}
+	if p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	for name, values := range p.headers {
+		req.Header[name] = values
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("network error while fetching IP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	body, err := readLimited(resp.Body, maxIPResponseBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	return parseIPResponse(resp.Header.Get("Content-Type"), body, p.jsonField)
+}
+
+// maxIPResponseBytes caps how much of a provider response is read. An
+// address, even wrapped in JSON with a few other fields, fits many times.
+const maxIPResponseBytes = 4 << 10
+
+// readLimited reads all of r, failing instead of reading past max bytes so
+// a hostile or broken endpoint can't make the updater buffer without bound.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("response is larger than %d bytes", max)
+	}
+	return body, nil
+}
+
+// parseIPResponse extracts the IP from a provider response body. JSON bodies
+// must be an object holding the address under field (ip if empty); anything
+// else must be a single bare address. When the Content-Type is missing both
+// forms are attempted.
+func parseIPResponse(contentType string, body []byte, field string) (string, error) {
+	if field == "" {
+		field = defaultIPJSONField
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	var ip string
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", fmt.Errorf("failed to parse response: %v", err)
+		}
+		value, err := jsonIPField(fields, field)
+		if err != nil {
+			return "", err
+		}
+		ip = value
+	case mediaType == "":
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err == nil {
+			value, err := jsonIPField(fields, field)
+			if err != nil {
+				return "", err
+			}
+			ip = value
+		} else {
+			ip = strings.TrimSpace(string(body))
+		}
+	default:
+		ip = strings.TrimSpace(string(body))
+	}
+
+	if ip == "" {
+		return "", fmt.Errorf("received empty IP from API")
+	}
+
+	if net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("response is not a valid IP address: %q", truncate(ip, 64))
+	}
+
+	return ip, nil
+}
+
+// jsonIPField returns the string stored under field in a decoded JSON object.
+func jsonIPField(fields map[string]any, field string) (string, error) {
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("response has no %q field", field)
+	}
+	ip, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("response field %q is not a string", field)
+	}
+	return ip, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// cgnatNet is the shared address space used by carrier-grade NAT (RFC 6598).
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkPublicIP returns an error naming the reserved range ip belongs to, or
+// nil if ip is a globally routable address.
+func checkPublicIP(ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address: %q", ip)
+	}
+
+	var kind string
+	switch {
+	case parsed.IsUnspecified():
+		kind = "unspecified"
+	case parsed.IsLoopback():
+		kind = "loopback"
+	case parsed.IsPrivate():
+		kind = "private (RFC1918/ULA)"
+	case cgnatNet.Contains(parsed):
+		kind = "carrier-grade NAT (100.64.0.0/10)"
+	case parsed.IsLinkLocalUnicast(), parsed.IsLinkLocalMulticast():
+		kind = "link-local"
+	case parsed.IsMulticast():
+		kind = "multicast"
+	default:
+		return nil
+	}
+
+	return fmt.Errorf("%s is a %s address, not a public one", ip, kind)
+}
+
+// isIPv6 reports whether ip is an IPv6 address (including bracketed form).
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	return parsed != nil && parsed.To4() == nil
+}
+
+// parsePrefixLength parses a prefix length such as "/24" or "24" for an
+// address of bits bits. An empty string yields 0.
+func parsePrefixLength(s string, bits int) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ones, err := strconv.Atoi(strings.TrimPrefix(s, "/"))
+	if err != nil || ones < 0 || ones > bits {
+		return 0, fmt.Errorf("%q is not a prefix length between /0 and /%d", s, bits)
+	}
+	return ones, nil
+}
+
+// sameNetwork reports whether a and b are in the same network of the
+// prefix length given by mask4 or mask6 for their address family. A zero
+// prefix length means only equal addresses match.
+func sameNetwork(a, b string, mask4, mask6 int) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil || isIPv6(a) != isIPv6(b) {
+		return false
+	}
+
+	mask := net.CIDRMask(mask4, 32)
+	if isIPv6(a) {
+		mask = net.CIDRMask(mask6, 128)
+	} else {
+		ipA, ipB = ipA.To4(), ipB.To4()
+	}
+	if ones, _ := mask.Size(); ones == 0 {
+		return ipA.Equal(ipB)
+	}
+	return ipA.Mask(mask).Equal(ipB.Mask(mask))
+}
+
+// fetchResult is a detected IP together with where it came from.
+type fetchResult struct {
+	IP string
+	// Provider names the provider that answered; with a quorum, the
+	// comma-separated providers that agreed.
+	Provider string
+	// Latency is how long the provider took; with a quorum, the slowest of
+	// those that agreed.
+	Latency time.Duration
+}
+
+// IPFetcher determines the host's current public IP. envIP is the address
+// currently configured in .env, if any, and hints which family to prefer.
+type IPFetcher interface {
+	FetchIP(ctx context.Context, envIP string) (fetchResult, error)
+}
+
+// providerSet is the IPFetcher backed by the configured HTTP providers for
+// each IP version.
+type providerSet struct {
+	IPv4         []IPProvider
+	IPv6         []IPProvider
+	version      string
+	allowPrivate bool
+	// timeout bounds a whole FetchIP call; zero means no overall deadline.
+	timeout time.Duration
+	// quorum, if non-zero, queries all providers at once and requires this
+	// many to agree.
+	quorum int
+}
+
+func newProviderSet(cfg *Config) (*providerSet, error) {
+	opts := fetchOptions{
+		timeout:     cfg.HTTPTimeout,
+		userAgent:   cfg.HTTPUserAgent,
+		jsonField:   cfg.IPJSONField,
+		tlsInsecure: cfg.IPTLSInsecure,
+	}
+	if cfg.IPTLSPinnedSHA256 != "" {
+		pin, err := parseFingerprint(cfg.IPTLSPinnedSHA256)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP_TLS_PINNED_SHA256: %v", err)
+		}
+		opts.pinnedSHA256 = pin
+	}
+	if len(cfg.IPFetchHeaders) > 0 {
+		opts.headers = make(http.Header, len(cfg.IPFetchHeaders))
+		for name, value := range cfg.IPFetchHeaders {
+			opts.headers.Set(name, value)
+		}
+	}
+	if cfg.IPFetchProxy != "" {
+		proxy, err := url.Parse(cfg.IPFetchProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP_FETCH_PROXY: %v", err)
+		}
+		opts.proxy = proxy
+	}
+
+	opts4, opts6 := opts, opts
+	var err error
+	if opts4.localAddr, err = sourceAddr(cfg, ipVersion4); err != nil {
+		return nil, err
+	}
+	if opts6.localAddr, err = sourceAddr(cfg, ipVersion6); err != nil {
+		return nil, err
+	}
+
+	return &providerSet{
+		IPv4:         withBreakers(newProviders(cfg.Providers, ipVersion4, opts4), cfg.ProviderFailureThreshold, cfg.ProviderCooldown),
+		IPv6:         withBreakers(newProviders(cfg.IPv6Providers, ipVersion6, opts6), cfg.ProviderFailureThreshold, cfg.ProviderCooldown),
+		version:      cfg.IPVersion,
+		allowPrivate: cfg.AllowPrivateIP,
+		timeout:      cfg.FetchTimeout,
+		quorum:       cfg.ProviderQuorum,
+	}, nil
+}
+
+// sourceAddr returns the local address lookups of the given IP version are
+// sent from: SOURCE_IP, or an address of SOURCE_INTERFACE, of that version.
+// It returns nil, letting the system choose, when neither is set or, in
+// dual-stack mode, when the source has no address of that version.
+func sourceAddr(cfg *Config, version string) (net.IP, error) {
+	switch {
+	case cfg.SourceIP != "":
+		if isIPv6(cfg.SourceIP) != (version == ipVersion6) {
+			return nil, nil
+		}
+		return net.ParseIP(cfg.SourceIP), nil
+	case cfg.SourceInterface != "":
+		iface, err := net.InterfaceByName(cfg.SourceInterface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOURCE_INTERFACE: %v", err)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %v", iface.Name, err)
+		}
+		ip := pickSourceAddr(addrs, version)
+		if ip == nil && cfg.IPVersion == version {
+			return nil, fmt.Errorf("interface %s has no usable IPv%s address", iface.Name, version)
+		}
+		return ip, nil
+	}
+	return nil, nil
+}
+
+// pickSourceAddr returns the first address in addrs of the given IP version
+// that can reach the internet, skipping loopback and link-local ones.
+func pickSourceAddr(addrs []net.Addr, version string) net.IP {
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if (ip.To4() == nil) != (version == ipVersion6) {
+			continue
+		}
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		return ip
+	}
+	return nil
+}
+
+// fetch detects the IP of the given version using the configured strategy.
+func (s *providerSet) fetch(ctx context.Context, providers []IPProvider, version string) (fetchResult, error) {
+	if s.quorum > 0 {
+		return getQuorumIP(ctx, providers, version, s.allowPrivate, s.quorum)
+	}
+	return getCurrentIP(ctx, providers, version, s.allowPrivate)
+}
+
+// FetchIP returns the current IP for the configured IP version. In
+// dual-stack mode both versions are detected and the one matching the family
+// of envIP is preferred, falling back to the other if unavailable.
+// Cancelling ctx aborts any request in flight.
+func (s *providerSet) FetchIP(ctx context.Context, envIP string) (fetchResult, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	switch s.version {
+	case ipVersion4:
+		return s.fetch(ctx, s.IPv4, ipVersion4)
+	case ipVersion6:
+		return s.fetch(ctx, s.IPv6, ipVersion6)
+	}
+
+	res4, err4 := s.fetch(ctx, s.IPv4, ipVersion4)
+	if ctx.Err() != nil {
+		return fetchResult{}, ctx.Err()
+	}
+	res6, err6 := s.fetch(ctx, s.IPv6, ipVersion6)
+	if err4 != nil && err6 != nil {
+		return fetchResult{}, fmt.Errorf("IPv4: %v; IPv6: %v", err4, err6)
+	}
+
+	slog.Info("Dual-stack detection", "ipv4", res4.IP, "ipv6", res6.IP)
+
+	preferred, fallback := res4, res6
+	if isIPv6(envIP) {
+		preferred, fallback = res6, res4
+	}
+	if preferred.IP != "" {
+		return preferred, nil
+	}
+	slog.Warn("Preferred address family unavailable, using fallback", "ip", fallback.IP)
+	return fallback, nil
+}
+
+// getCurrentIP asks each provider in turn and returns the first IP obtained.
+// Addresses of the wrong IP version are rejected, as are non-public ones
+// unless allowPrivate is set; the next provider is then tried. It only fails
+// if every provider fails.
+func getCurrentIP(ctx context.Context, providers []IPProvider, version string, allowPrivate bool) (fetchResult, error) {
+	if len(providers) == 0 {
+		return fetchResult{}, fmt.Errorf("no IP providers configured")
+	}
+
+	for _, provider := range providers {
+		result, err := fetchFrom(ctx, provider, version, allowPrivate)
+		if ctx.Err() != nil {
+			return fetchResult{}, ctx.Err()
+		}
+		if err == nil {
+			return result, nil
+		}
+	}
+
+	return fetchResult{}, fmt.Errorf("all %d IP providers failed", len(providers))
+}
+
+// getQuorumIP asks all providers concurrently and returns the IP reported by
+// the most of them, provided at least quorum agree on it.
+func getQuorumIP(ctx context.Context, providers []IPProvider, version string, allowPrivate bool, quorum int) (fetchResult, error) {
+	if len(providers) < quorum {
+		return fetchResult{}, fmt.Errorf("quorum of %d cannot be reached with %d IP providers", quorum, len(providers))
+	}
+
+	results := make([]fetchResult, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = fetchFrom(ctx, provider, version, allowPrivate)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return fetchResult{}, ctx.Err()
+	}
+
+	votes := make(map[string]int)
+	var best string
+	for _, result := range results {
+		if result.IP == "" {
+			continue
+		}
+		votes[result.IP]++
+		if votes[result.IP] > votes[best] {
+			best = result.IP
+		}
+	}
+
+	if len(votes) > 1 {
+		tally := make([]string, 0, len(providers))
+		for i, provider := range providers {
+			tally = append(tally, fmt.Sprintf("%v=%s", provider, results[i].IP))
+		}
+		slog.Warn("IP providers disagree", "results", strings.Join(tally, ","))
+	}
+
+	if votes[best] < quorum {
+		return fetchResult{}, fmt.Errorf("quorum not reached: best candidate %q has %d of %d required votes", best, votes[best], quorum)
+	}
+
+	agreed := fetchResult{IP: best}
+	var names []string
+	for _, result := range results {
+		if result.IP != best {
+			continue
+		}
+		names = append(names, result.Provider)
+		agreed.Latency = max(agreed.Latency, result.Latency)
+	}
+	agreed.Provider = strings.Join(names, ",")
+
+	slog.Info("IP confirmed by quorum", "ip", best, "votes", votes[best], "quorum", quorum)
+	return agreed, nil
+}
+
+// fetchFrom fetches the IP from a single provider, rejecting addresses of
+// the wrong IP version and, unless allowPrivate is set, non-public ones.
+func fetchFrom(ctx context.Context, provider IPProvider, version string, allowPrivate bool) (fetchResult, error) {
+	name := fmt.Sprint(provider)
+	slog.Debug("Fetching current IP", "provider", name)
+	start := time.Now()
+	ip, err := provider.Fetch(ctx)
+	latency := time.Since(start)
+	if errors.Is(err, errCircuitOpen) {
+		slog.Debug("Skipping provider", "provider", name, "reason", err)
+		providerRequestsTotal.WithLabelValues(name, "skipped").Inc()
+		return fetchResult{}, err
+	}
+	if err != nil {
+		// A request cut short by cancellation says nothing about the
+		// provider, so it is not counted.
+		if ctx.Err() == nil {
+			slog.Warn("Provider failed", "provider", name, "duration_ms", latency.Milliseconds(), "error", err)
+			providerRequestsTotal.WithLabelValues(name, "error").Inc()
+		}
+		return fetchResult{}, err
+	}
+
+	if isIPv6(ip) != (version == ipVersion6) {
+		slog.Warn("Rejecting IP of the wrong version", "provider", name, "ip", ip, "ip_version", version)
+		providerRequestsTotal.WithLabelValues(name, "rejected").Inc()
+		return fetchResult{}, fmt.Errorf("%s is not an IPv%s address", ip, version)
+	}
+
+	if !allowPrivate {
+		if err := checkPublicIP(ip); err != nil {
+			slog.Warn("Rejecting non-public IP (set ALLOW_PRIVATE_IP=true to accept it)", "provider", name, "ip", ip, "reason", err)
+			providerRequestsTotal.WithLabelValues(name, "rejected").Inc()
+			return fetchResult{}, err
+		}
+	}
+
+	slog.Debug("Successfully fetched current IP", "provider", name, "ip", ip, "duration_ms", latency.Milliseconds())
+	providerRequestsTotal.WithLabelValues(name, "success").Inc()
+	return fetchResult{IP: ip, Provider: name, Latency: latency}, nil
+}
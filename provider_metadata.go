@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// awsMetadataProvider and gcpMetadataProvider are provider entries
+	// reading the public IPv4 address from the cloud instance metadata
+	// service instead of an external endpoint.
+	awsMetadataProvider = "aws-metadata"
+	gcpMetadataProvider = "gcp-metadata"
+
+	awsMetadataEndpoint = "http://169.254.169.254"
+	gcpMetadataEndpoint = "http://metadata.google.internal"
+
+	// awsTokenTTL is how long an IMDSv2 session token is requested for.
+	// Tokens are refreshed a minute before they expire.
+	awsTokenTTL = 6 * time.Hour
+)
+
+// isMetadataProvider reports whether a provider entry names a cloud
+// metadata service.
+func isMetadataProvider(entry string) bool {
+	return entry == awsMetadataProvider || entry == gcpMetadataProvider
+}
+
+// newMetadataClient returns the client used for metadata requests. The
+// service is link-local, so requests are never proxied or pinned to a
+// source address.
+func newMetadataClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// awsMetadata reads the instance's public IPv4 address from the EC2
+// instance metadata service, using an IMDSv2 session token.
+type awsMetadata struct {
+	endpoint string
+	client   *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func (p *awsMetadata) String() string {
+	return awsMetadataProvider
+}
+
+func (p *awsMetadata) Fetch(ctx context.Context) (string, error) {
+	token, err := p.sessionToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"/latest/meta-data/public-ipv4", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+
+	ip, status, err := fetchMetadata(p.client, req)
+	if status == http.StatusUnauthorized {
+		// The token was revoked or expired early; get a new one next time.
+		p.mu.Lock()
+		p.token = ""
+		p.mu.Unlock()
+	}
+	if status == http.StatusNotFound {
+		return "", fmt.Errorf("instance has no public IPv4 address")
+	}
+	return ip, err
+}
+
+// sessionToken returns a cached IMDSv2 token, requesting a new one when it
+// is about to expire.
+func (p *awsMetadata) sessionToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", fmt.Sprint(int(awsTokenTTL.Seconds())))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get IMDSv2 token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get IMDSv2 token: received status code %d", resp.StatusCode)
+	}
+	body, err := readLimited(resp.Body, maxIPResponseBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read IMDSv2 token: %v", err)
+	}
+
+	p.token = strings.TrimSpace(string(body))
+	p.tokenExpiry = time.Now().Add(awsTokenTTL - time.Minute)
+	return p.token, nil
+}
+
+// gcpMetadata reads the external IPv4 address of the instance's first
+// network interface from the Compute Engine metadata server.
+type gcpMetadata struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (p *gcpMetadata) String() string {
+	return gcpMetadataProvider
+}
+
+func (p *gcpMetadata) Fetch(ctx context.Context) (string, error) {
+	url := p.endpoint + "/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %v", err)
+	}
+	// Required by the metadata server, which rejects requests without it.
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	ip, status, err := fetchMetadata(p.client, req)
+	if status == http.StatusNotFound {
+		return "", fmt.Errorf("instance has no external IPv4 address")
+	}
+	return ip, err
+}
+
+// fetchMetadata sends a metadata request and parses the bare address it
+// returns. The status code is returned alongside any error so callers can
+// explain the service-specific ones.
+func fetchMetadata(client *http.Client, req *http.Request) (string, int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("network error while fetching IP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", resp.StatusCode, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	body, err := readLimited(resp.Body, maxIPResponseBytes)
+	if err != nil {
+		return "", resp.StatusCode, fmt.Errorf("failed to read response: %v", err)
+	}
+	ip, err := parseIPResponse("text/plain", body, "")
+	return ip, resp.StatusCode, err
+}
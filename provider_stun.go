@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+const (
+	// stunScheme prefixes provider entries that name a STUN server.
+	stunScheme = "stun:"
+
+	defaultSTUNServer = "stun.l.google.com:19302"
+
+	// stunAttemptTimeout is how long to wait for a response before the
+	// binding request is retransmitted, as UDP may drop it.
+	stunAttemptTimeout = 500 * time.Millisecond
+)
+
+// stunProvider learns the public IP from the reflexive address a STUN server
+// reports for a binding request, which is how NAT traversal sees the host.
+type stunProvider struct {
+	server    string
+	network   string
+	timeout   time.Duration
+	localAddr net.IP
+}
+
+func (p *stunProvider) String() string {
+	return stunScheme + p.server
+}
+
+func (p *stunProvider) Fetch(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	if p.localAddr != nil {
+		dialer.LocalAddr = &net.UDPAddr{IP: p.localAddr}
+	}
+	conn, err := dialer.DialContext(ctx, p.network, p.server)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach STUN server: %v", err)
+	}
+	defer conn.Close()
+
+	// Unblock any pending read as soon as ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+	if err != nil {
+		return "", fmt.Errorf("failed to build binding request: %v", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		if _, err := conn.Write(req.Raw); err != nil {
+			return "", fmt.Errorf("failed to send binding request: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(stunAttemptTimeout))
+
+		ip, err := readBindingResponse(conn, buf, req.TransactionID)
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("no response from STUN server: %v", ctx.Err())
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			continue
+		}
+		return ip, err
+	}
+}
+
+// readBindingResponse reads until the response to the transaction id
+// arrives and returns the mapped address it carries.
+func readBindingResponse(conn net.Conn, buf []byte, id [stun.TransactionIDSize]byte) (string, error) {
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return "", err
+		}
+
+		res := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := res.Decode(); err != nil || res.TransactionID != id {
+			continue
+		}
+		if res.Type != stun.BindingSuccess {
+			return "", fmt.Errorf("STUN server answered with %s", res.Type)
+		}
+
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(res); err == nil {
+			return xorAddr.IP.String(), nil
+		}
+		var addr stun.MappedAddress
+		if err := addr.GetFrom(res); err == nil {
+			return addr.IP.String(), nil
+		}
+		return "", fmt.Errorf("STUN response carries no mapped address")
+	}
+}
+
+// isSTUNProvider reports whether a provider entry names a STUN server.
+func isSTUNProvider(entry string) bool {
+	return strings.HasPrefix(entry, stunScheme)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// hangingServer never answers until the client gives up on the request.
+func hangingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchIPOverallDeadline(t *testing.T) {
+	srv := hangingServer(t)
+	urls := []string{srv.URL, srv.URL, srv.URL}
+	set := &providerSet{
+		IPv4:         newProviders(urls, ipVersion4, fetchOptions{timeout: time.Minute}),
+		version:      ipVersion4,
+		allowPrivate: true,
+		timeout:      100 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err := set.FetchIP(context.Background(), ""); err == nil {
+		t.Fatal("expected an error from hanging providers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("FetchIP took %v, overall deadline not applied", elapsed)
+	}
+}
+
+func TestFetchIPCancelled(t *testing.T) {
+	srv := hangingServer(t)
+	set := &providerSet{
+		IPv4:    newProviders([]string{srv.URL}, ipVersion4, fetchOptions{timeout: time.Minute}),
+		version: ipVersion4,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := set.FetchIP(ctx, "")
+	if err != context.Canceled {
+		t.Fatalf("FetchIP error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("FetchIP took %v after cancellation", elapsed)
+	}
+}
+
+// fakeIpify serves a canned response in place of ipify.
+func fakeIpify(t *testing.T, status int, contentType, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPProviderAgainstFakeIpify(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		wantIP      string
+		wantErr     string
+	}{
+		{"json", http.StatusOK, "application/json", `{"ip":"203.0.113.7"}`, "203.0.113.7", ""},
+		{"plain text", http.StatusOK, "text/plain", "203.0.113.7\n", "203.0.113.7", ""},
+		{"server error", http.StatusServiceUnavailable, "text/plain", "try later", "", "non-200 status code: 503"},
+		{"rate limited", http.StatusTooManyRequests, "application/json", `{"ip":"203.0.113.7"}`, "", "non-200 status code: 429"},
+		{"malformed json", http.StatusOK, "application/json", `{"ip":`, "", "failed to parse response"},
+		{"missing field", http.StatusOK, "application/json", `{"address":"203.0.113.7"}`, "", `no "ip" field`},
+		{"empty ip", http.StatusOK, "application/json", `{"ip":""}`, "", "empty IP"},
+		{"not an ip", http.StatusOK, "text/plain", "<html>blocked</html>", "", "not a valid IP address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeIpify(t, tt.status, tt.contentType, tt.body)
+			provider := newProviders([]string{srv.URL}, ipVersion4, fetchOptions{timeout: time.Second})[0]
+
+			ip, err := provider.Fetch(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Fetch = %q, %v; want an error containing %q", ip, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || ip != tt.wantIP {
+				t.Fatalf("Fetch = %q, %v; want %s", ip, err, tt.wantIP)
+			}
+		})
+	}
+}
+
+func TestGetCurrentIPFallsBackPastFakeIpifyFailures(t *testing.T) {
+	down := fakeIpify(t, http.StatusBadGateway, "", "")
+	private := fakeIpify(t, http.StatusOK, "application/json", `{"ip":"192.168.1.10"}`)
+	working := fakeIpify(t, http.StatusOK, "application/json", `{"ip":"203.0.113.7"}`)
+	providers := newProviders([]string{down.URL, private.URL, working.URL}, ipVersion4, fetchOptions{timeout: time.Second})
+
+	result, err := getCurrentIP(context.Background(), providers, ipVersion4, false)
+	if err != nil || result.IP != "203.0.113.7" || result.Provider != working.URL {
+		t.Fatalf("getCurrentIP = %+v, %v; want 203.0.113.7 from the third provider", result, err)
+	}
+
+	_, err = getCurrentIP(context.Background(), providers[:2], ipVersion4, false)
+	if err == nil || !strings.Contains(err.Error(), "all 2 IP providers failed") {
+		t.Errorf("err = %v, want every provider reported failed", err)
+	}
+}
+
+func TestHTTPProviderUserAgent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+		w.Write([]byte("1.2.3.4"))
+	}))
+	defer srv.Close()
+
+	provider := newProviders([]string{srv.URL}, ipVersion4, fetchOptions{timeout: time.Second, userAgent: "obol-ip-updater/test"})[0]
+	if _, err := provider.Fetch(context.Background()); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got != "obol-ip-updater/test" {
+		t.Errorf("User-Agent = %q, want obol-ip-updater/test", got)
+	}
+}
+
+func TestHTTPProviderHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("1.2.3.4"))
+	}))
+	defer srv.Close()
+
+	headers, err := parseHeaders("Authorization: Bearer xyz; X-Api-Key: abc;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultConfig()
+	cfg.Providers = []string{srv.URL}
+	cfg.IPFetchHeaders = headers
+	cfg.AllowPrivateIP = true
+	set, err := newProviderSet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := set.FetchIP(context.Background(), ""); err != nil {
+		t.Fatalf("FetchIP: %v", err)
+	}
+	if got.Get("Authorization") != "Bearer xyz" || got.Get("X-Api-Key") != "abc" {
+		t.Errorf("request headers = %v", got)
+	}
+}
+
+func TestParseHeadersRejectsInvalid(t *testing.T) {
+	for _, raw := range []string{"Authorization Bearer xyz", ": value", "Bad Name: value"} {
+		if _, err := parseHeaders(raw); err == nil {
+			t.Errorf("parseHeaders(%q): expected an error", raw)
+		}
+	}
+}
+
+func TestHTTPProviderRejectsOversizedResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1.2.3.4"))
+		// An endless body, as from a hostile provider.
+		padding := bytes.Repeat([]byte(" "), 1<<10)
+		for {
+			if _, err := w.Write(padding); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	provider := newProviders([]string{srv.URL}, ipVersion4, fetchOptions{timeout: 5 * time.Second})[0]
+	_, err := provider.Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("Fetch error = %v, want the oversized response rejected", err)
+	}
+}
+
+// fakeSTUNServer answers binding requests with a fixed mapped address.
+func fakeSTUNServer(t *testing.T, mapped net.IP) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if err := req.Decode(); err != nil {
+				continue
+			}
+			res, err := stun.Build(
+				stun.NewTransactionIDSetter(req.TransactionID),
+				stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: mapped, Port: 4242},
+				stun.Fingerprint,
+			)
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(res.Raw, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestParseIPResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		field       string
+		want        string
+		wantErr     bool
+	}{
+		{"ipify JSON", "application/json", `{"ip":"203.0.113.7"}`, "", "203.0.113.7", false},
+		{"plain text", "text/plain", "203.0.113.7\n", "", "203.0.113.7", false},
+		{"untyped JSON", "", `{"ip":"203.0.113.7"}`, "", "203.0.113.7", false},
+		{"untyped text", "", "203.0.113.7", "", "203.0.113.7", false},
+		{"custom field", "application/json", `{"address":"203.0.113.7","ip":"ignored"}`, "address", "203.0.113.7", false},
+		{"missing field", "application/json", `{"address":"203.0.113.7"}`, "", "", true},
+		{"non-string field", "application/json", `{"ip":42}`, "", "", true},
+		{"not an IP", "text/plain", "<html>", "", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIPResponse(tt.contentType, []byte(tt.body), tt.field)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPProviderSourceAddr(t *testing.T) {
+	var remote string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+		w.Write([]byte("203.0.113.7"))
+	}))
+	defer srv.Close()
+
+	// 127.0.0.2 is still loopback, so the server sees it as the peer only
+	// if the dialer bound it.
+	provider := newProviders([]string{srv.URL}, ipVersion4, fetchOptions{timeout: time.Second, localAddr: net.ParseIP("127.0.0.2")})[0]
+	if _, err := provider.Fetch(context.Background()); err != nil {
+		t.Skipf("cannot bind 127.0.0.2 on this host: %v", err)
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.2" {
+		t.Errorf("request came from %s, want 127.0.0.2", remote)
+	}
+}
+
+func TestHTTPProviderProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A proxied request carries the absolute URL of the target.
+		if r.URL.Host != "ip.example" {
+			http.Error(w, "unexpected target "+r.URL.String(), http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("203.0.113.7"))
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := newProviders([]string{"http://ip.example/"}, ipVersion4, fetchOptions{timeout: time.Second, proxy: proxyURL})[0]
+
+	ip, err := provider.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if ip != "203.0.113.7" {
+		t.Errorf("Fetch = %q, want 203.0.113.7", ip)
+	}
+}
+
+func TestHTTPProviderTLSPinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("203.0.113.7"))
+	}))
+	defer srv.Close()
+
+	pin := sha256.Sum256(srv.Certificate().Raw)
+	wrongPin := sha256.Sum256([]byte("another certificate"))
+
+	tests := []struct {
+		name    string
+		opts    fetchOptions
+		wantErr bool
+	}{
+		// The test server's certificate is self-signed.
+		{"strict", fetchOptions{}, true},
+		{"insecure", fetchOptions{tlsInsecure: true}, false},
+		{"pinned", fetchOptions{tlsInsecure: true, pinnedSHA256: pin[:]}, false},
+		{"pin mismatch", fetchOptions{tlsInsecure: true, pinnedSHA256: wrongPin[:]}, true},
+	}
+
+	for _, tt := range tests {
+		tt.opts.timeout = time.Second
+		provider := newProviders([]string{srv.URL}, ipVersion4, tt.opts)[0]
+		_, err := provider.Fetch(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	plain := hex.EncodeToString(sum[:])
+
+	var colons []string
+	for i := 0; i < len(plain); i += 2 {
+		colons = append(colons, strings.ToUpper(plain[i:i+2]))
+	}
+
+	for _, s := range []string{plain, strings.Join(colons, ":")} {
+		got, err := parseFingerprint(s)
+		if err != nil || !bytes.Equal(got, sum[:]) {
+			t.Errorf("parseFingerprint(%q) = %x, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"abc", plain[:10], "zz" + plain[2:]} {
+		if _, err := parseFingerprint(s); err == nil {
+			t.Errorf("parseFingerprint(%q) should fail", s)
+		}
+	}
+}
+
+func TestPickSourceAddr(t *testing.T) {
+	ipNet := func(s string) net.Addr {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = net.ParseIP(strings.Split(s, "/")[0])
+		return n
+	}
+	addrs := []net.Addr{
+		ipNet("fe80::1/64"),
+		ipNet("192.0.2.10/24"),
+		ipNet("2001:db8::10/64"),
+	}
+
+	if got := pickSourceAddr(addrs, ipVersion4); !got.Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("IPv4 source = %v, want 192.0.2.10", got)
+	}
+	if got := pickSourceAddr(addrs, ipVersion6); !got.Equal(net.ParseIP("2001:db8::10")) {
+		t.Errorf("IPv6 source = %v, want 2001:db8::10 (link-local must be skipped)", got)
+	}
+	if got := pickSourceAddr(addrs[:1], ipVersion6); got != nil {
+		t.Errorf("source with only link-local = %v, want nil", got)
+	}
+}
+
+func TestSTUNProvider(t *testing.T) {
+	server := fakeSTUNServer(t, net.ParseIP("203.0.113.7"))
+	provider := newProviders([]string{stunScheme + server}, ipVersion4, fetchOptions{timeout: time.Second})[0]
+
+	ip, err := provider.Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if ip != "203.0.113.7" {
+		t.Errorf("Fetch = %q, want 203.0.113.7", ip)
+	}
+}
+
+func TestSTUNProviderTimeout(t *testing.T) {
+	// Nothing answers on a port we just released.
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := conn.LocalAddr().String()
+	conn.Close()
+
+	provider := newProviders([]string{stunScheme + server}, ipVersion4, fetchOptions{timeout: 200 * time.Millisecond})[0]
+	if _, err := provider.Fetch(context.Background()); err == nil {
+		t.Fatal("expected an error when the STUN server does not answer")
+	}
+}
+
+func TestAWSMetadataProvider(t *testing.T) {
+	tokens := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			tokens++
+			w.Write([]byte("session-token"))
+		case r.URL.Path == "/latest/meta-data/public-ipv4":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "session-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("3.3.3.3"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	provider := &awsMetadata{endpoint: srv.URL, client: newMetadataClient(time.Second)}
+	for i := 0; i < 2; i++ {
+		ip, err := provider.Fetch(context.Background())
+		if err != nil || ip != "3.3.3.3" {
+			t.Fatalf("Fetch = %q, %v; want 3.3.3.3", ip, err)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("requested %d IMDSv2 tokens, want the first one reused", tokens)
+	}
+}
+
+func TestGCPMetadataProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("4.4.4.4"))
+	}))
+	defer srv.Close()
+
+	provider := &gcpMetadata{endpoint: srv.URL, client: newMetadataClient(time.Second)}
+	ip, err := provider.Fetch(context.Background())
+	if err != nil || ip != "4.4.4.4" {
+		t.Fatalf("Fetch = %q, %v; want 4.4.4.4", ip, err)
+	}
+}
+
+func TestGetQuorumIP(t *testing.T) {
+	providers := func(ips ...string) []IPProvider {
+		var list []IPProvider
+		for _, ip := range ips {
+			p := &fakeProvider{ip: ip}
+			if ip == "" {
+				p.err = errors.New("unavailable")
+			}
+			list = append(list, p)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name    string
+		ips     []string
+		quorum  int
+		want    string
+		wantErr bool
+	}{
+		{"unanimous", []string{"1.1.1.1", "1.1.1.1", "1.1.1.1"}, 2, "1.1.1.1", false},
+		{"majority", []string{"1.1.1.1", "9.9.9.9", "1.1.1.1"}, 2, "1.1.1.1", false},
+		{"split", []string{"1.1.1.1", "9.9.9.9", ""}, 2, "", true},
+		{"too few providers", []string{"1.1.1.1"}, 2, "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := getQuorumIP(context.Background(), providers(tt.ips...), ipVersion4, true, tt.quorum)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got.IP != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got.IP, tt.want)
+		}
+	}
+}
+
+func TestSameNetwork(t *testing.T) {
+	tests := []struct {
+		a, b         string
+		mask4, mask6 int
+		want         bool
+	}{
+		{"203.0.113.10", "203.0.113.10", 0, 0, true},
+		{"203.0.113.10", "203.0.113.99", 0, 0, false},
+		{"203.0.113.10", "203.0.113.99", 24, 0, true},
+		{"203.0.113.10", "203.0.114.10", 24, 0, false},
+		{"203.0.113.10", "203.0.113.99", 0, 64, false},
+		{"2001:db8:1:2::1", "2001:db8:1:2::ff", 24, 64, true},
+		{"2001:db8:1:2::1", "2001:db8:1:3::1", 24, 64, false},
+		{"203.0.113.10", "2001:db8::1", 24, 64, false},
+	}
+
+	for _, tt := range tests {
+		if got := sameNetwork(tt.a, tt.b, tt.mask4, tt.mask6); got != tt.want {
+			t.Errorf("sameNetwork(%s, %s, /%d, /%d) = %v, want %v", tt.a, tt.b, tt.mask4, tt.mask6, got, tt.want)
+		}
+	}
+}
+
+func TestParsePrefixLength(t *testing.T) {
+	for in, want := range map[string]int{"": 0, "/24": 24, "16": 16, "/32": 32} {
+		if got, err := parsePrefixLength(in, 32); err != nil || got != want {
+			t.Errorf("parsePrefixLength(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"/33", "-1", "/x", "255.255.255.0"} {
+		if _, err := parsePrefixLength(in, 32); err == nil {
+			t.Errorf("parsePrefixLength(%q) expected an error", in)
+		}
+	}
+}
+
+func TestGetCurrentIPCountsProviderRequests(t *testing.T) {
+	down := fakeIpify(t, http.StatusBadGateway, "", "")
+	private := fakeIpify(t, http.StatusOK, "text/plain", "192.168.1.10")
+	working := fakeIpify(t, http.StatusOK, "text/plain", "203.0.113.7")
+	providers := newProviders([]string{down.URL, private.URL, working.URL}, ipVersion4, fetchOptions{timeout: time.Second})
+
+	if _, err := getCurrentIP(context.Background(), providers, ipVersion4, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		provider, result string
+	}{
+		{down.URL, "error"},
+		{private.URL, "rejected"},
+		{working.URL, "success"},
+	} {
+		if got := testutil.ToFloat64(providerRequestsTotal.WithLabelValues(tt.provider, tt.result)); got != 1 {
+			t.Errorf("%s %s requests = %v, want 1", tt.provider, tt.result, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultP2PPort is Charon's default libp2p TCP port.
+	defaultP2PPort = 3610
+
+	reachabilityTimeout = 5 * time.Second
+)
+
+// reachabilityProbe checks whether Charon's P2P port can be reached on the
+// public IP. The check is advisory: it cannot see the host from outside, so
+// it combines two signals that each point at an unreachable node.
+//
+//   - The host's own outbound address being in the carrier-grade NAT range,
+//     meaning the ISP shares the public IP and inbound connections never
+//     reach this network.
+//   - A TCP connection to ip:port failing. Routers without NAT loopback
+//     (hairpinning) also refuse this, so a failure is a hint, not proof.
+type reachabilityProbe struct {
+	port int
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// localAddr returns the host's address on the default route.
+	localAddr func() (net.IP, error)
+}
+
+func newReachabilityProbe(port int) *reachabilityProbe {
+	dialer := &net.Dialer{Timeout: reachabilityTimeout}
+	return &reachabilityProbe{
+		port:      port,
+		dial:      dialer.DialContext,
+		localAddr: outboundIP,
+	}
+}
+
+// outboundIP returns the source address the kernel picks for Internet
+// traffic. Connecting a UDP socket sends no packets.
+func outboundIP() (net.IP, error) {
+	conn, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// check returns an error describing why ip:port looks unreachable, or nil.
+func (p *reachabilityProbe) check(ctx context.Context, ip string) error {
+	if local, err := p.localAddr(); err == nil && cgnatNet.Contains(local) {
+		return fmt.Errorf("host address %s is behind carrier-grade NAT (100.64.0.0/10), inbound P2P connections cannot reach it", local)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reachabilityTimeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(p.port))
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("P2P port %s is not reachable (check port forwarding; routers without NAT loopback also fail this test): %v", addr, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// warnIfUnreachable logs a prominent warning when check fails, returning
+// the failure for callers that treat it as fatal.
+func (p *reachabilityProbe) warnIfUnreachable(ctx context.Context, ip string) error {
+	if err := p.check(ctx, ip); err != nil {
+		slog.Warn("⚠️ Charon P2P port appears UNREACHABLE from outside, peers may not be able to connect", "ip", ip, "port", p.port, "reason", err)
+		return err
+	}
+	slog.Info("Charon P2P port is reachable", "ip", ip, "port", p.port)
+	return nil
+}
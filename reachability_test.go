@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestReachabilityProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	openPort := ln.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	publicHost := func() (net.IP, error) { return net.ParseIP("192.168.1.10"), nil }
+	cgnatHost := func() (net.IP, error) { return net.ParseIP("100.64.12.34"), nil }
+
+	tests := []struct {
+		name      string
+		port      int
+		localAddr func() (net.IP, error)
+		wantErr   bool
+	}{
+		{"open port", openPort, publicHost, false},
+		{"closed port", closedPort, publicHost, true},
+		{"behind CGNAT", openPort, cgnatHost, true},
+	}
+
+	for _, tt := range tests {
+		probe := newReachabilityProbe(tt.port)
+		probe.localAddr = tt.localAddr
+
+		err := probe.check(context.Background(), "127.0.0.1")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: check(127.0.0.1:%d) error = %v, wantErr %v", tt.name, tt.port, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+// restartRequired lists the settings, by YAML key, that are wired into
+// listeners, the database or the update actions at startup. A reload keeps
+// their current values and warns that they need a restart.
+var restartRequired = map[string]bool{
+	"db_driver":               true,
+	"db_path":                 true,
+	"database_url":            true,
+	"sqlite_journal_mode":     true,
+	"sqlite_synchronous":      true,
+	"sqlite_busy_timeout":     true,
+	"history_max_rows":        true,
+	"history_max_age":         true,
+	"env_file":                true,
+	"env_keys":                true,
+	"env_backup_dir":          true,
+	"env_backup_keep":         true,
+	"ha_enable":               true,
+	"instance_id":             true,
+	"ha_lease_duration":       true,
+	"metrics_addr":            true,
+	"health_addr":             true,
+	"api_addr":                true,
+	"grpc_addr":               true,
+	"control_socket":          true,
+	"restart_backend":         true,
+	"restart_command":         true,
+	"restart_container":       true,
+	"restart_container_label": true,
+	"compose_file":            true,
+	"compose_services":        true,
+	"k8s_namespace":           true,
+	"k8s_deployment":          true,
+	"nomad_addr":              true,
+	"nomad_token":             true,
+	"nomad_job":               true,
+	"restart_retries":         true,
+	"restart_retry_backoff":   true,
+	"check_reachability":      true,
+	"require_reachable":       true,
+	"charon_p2p_port":         true,
+	"charon_enr_url":          true,
+	"pre_update_hook":         true,
+	"post_update_hook":        true,
+	"update_mode":             true,
+	"ddns_provider":           true,
+	"ddns_hostname":           true,
+	"duckdns_token":           true,
+	"cloudflare_api_token":    true,
+	"cloudflare_zone_id":      true,
+}
+
+// configChange is a setting that differs between two configurations.
+type configChange struct {
+	key      string
+	field    int
+	old, new any
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.key, c.old, c.new)
+}
+
+// diffConfig lists the settings that differ between old and new, keyed by
+// their YAML names. Credentials are compared but reported redacted.
+func diffConfig(old, new *Config) []configChange {
+	oldValue := reflect.ValueOf(old.redacted())
+	newValue := reflect.ValueOf(new.redacted())
+	rawOld, rawNew := reflect.ValueOf(*old), reflect.ValueOf(*new)
+
+	var changes []configChange
+	for i := 0; i < oldValue.NumField(); i++ {
+		if reflect.DeepEqual(rawOld.Field(i).Interface(), rawNew.Field(i).Interface()) {
+			continue
+		}
+		key, _, _ := strings.Cut(oldValue.Type().Field(i).Tag.Get("yaml"), ",")
+		changes = append(changes, configChange{
+			key:   key,
+			field: i,
+			old:   oldValue.Field(i).Interface(),
+			new:   newValue.Field(i).Interface(),
+		})
+	}
+	return changes
+}
+
+// reloadConfig re-reads the configuration and swaps it in, along with the
+// providers and notifier built from it. An invalid configuration is logged
+// and the current one kept.
+func (s *Service) reloadConfig() {
+	cfg, err := s.reload()
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	providers, err := newProviderSet(cfg)
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+	notifier, err := newDispatcher(cfg)
+	if err != nil {
+		slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	old, oldNotifier := s.cfg, s.notifier
+
+	var applied []string
+	for _, change := range diffConfig(old, cfg) {
+		if restartRequired[change.key] {
+			slog.Warn("Setting changed but requires a restart to take effect", "key", change.key)
+			reflect.ValueOf(cfg).Elem().Field(change.field).Set(reflect.ValueOf(old).Elem().Field(change.field))
+			continue
+		}
+		applied = append(applied, change.String())
+	}
+
+	// The old dispatcher's held and in-flight notifications carry over,
+	// so reloading neither blocks the loop nor bypasses the rate limit.
+	notifier.adopt(oldNotifier)
+	s.cfg = cfg
+	s.fetcher = providers
+	s.notifier = notifier
+	if cfg.ConfirmCount != old.ConfirmCount {
+		s.state.confirm = newDebouncer(cfg.ConfirmCount)
+	}
+	s.mu.Unlock()
+
+	if len(applied) == 0 {
+		slog.Info("Configuration reloaded, nothing changed")
+		return
+	}
+	slog.Info("Configuration reloaded", "changes", strings.Join(applied, "; "))
+}
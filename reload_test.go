@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := defaultConfig()
+	new := defaultConfig()
+	new.CheckInterval = time.Minute
+	new.NotifyWebhookURL = "https://hooks.example/secret"
+
+	changes := diffConfig(old, new)
+	if len(changes) != 2 {
+		t.Fatalf("changes = %v, want 2", changes)
+	}
+	if got := changes[0].String(); got != "check_interval: 10s -> 1m0s" {
+		t.Errorf("first change = %q", got)
+	}
+	if changes[1].key != "notify_webhook_url" || changes[1].new != "REDACTED" {
+		t.Errorf("webhook change = %v, want the URL redacted", changes[1])
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	cfg := defaultConfig()
+	svc := &Service{cfg: cfg, state: newCheckState(cfg.ConfirmCount)}
+
+	reloaded := defaultConfig()
+	reloaded.CheckInterval = time.Minute
+	reloaded.ConfirmCount = 3
+	reloaded.DBPath = "/elsewhere/ip_store.db"
+	reloaded.HistoryMaxRows = 10
+	reloaded.EnvBackupKeep = 1
+	reloaded.EnvFile = "/elsewhere/.env"
+	reloaded.EnvKeys = []string{"CHARON_P2P_EXTERNAL_HOST"}
+	svc.reload = func() (*Config, error) { return reloaded, nil }
+	svc.reloadConfig()
+
+	active := svc.config()
+	if active.CheckInterval != time.Minute {
+		t.Errorf("CheckInterval = %v, want the reloaded 1m", active.CheckInterval)
+	}
+	if active.DBPath != defaultDBPath {
+		t.Errorf("DBPath = %q, want it kept until a restart", active.DBPath)
+	}
+	// The store and .env writer copy these at startup, so the active
+	// configuration must keep reporting the values they still use.
+	if active.HistoryMaxRows != defaultHistoryRows || active.EnvBackupKeep != defaultEnvBackupKeep {
+		t.Errorf("HistoryMaxRows, EnvBackupKeep = %d, %d; want them kept until a restart", active.HistoryMaxRows, active.EnvBackupKeep)
+	}
+	if active.EnvFile != defaultEnvFile || len(active.EnvKeys) != 1 || active.EnvKeys[0] != envKey {
+		t.Errorf("EnvFile, EnvKeys = %q, %v; want them kept until a restart", active.EnvFile, active.EnvKeys)
+	}
+	if svc.state.confirm.required != 3 {
+		t.Errorf("confirm count = %d, want 3", svc.state.confirm.required)
+	}
+
+	svc.reload = func() (*Config, error) { return nil, errors.New("bad config") }
+	svc.reloadConfig()
+	if svc.config() != active {
+		t.Error("a failed reload replaced the active configuration")
+	}
+}
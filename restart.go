@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultComposeService   = "charon"
+	defaultRestartContainer = "charon"
+
+	backendCompose = "compose"
+	backendDocker  = "docker"
+	backendPodman  = "podman"
+	backendCommand = "command"
+)
+
+// Restarter restarts Charon so it picks up the updated .env.
+type Restarter interface {
+	Restart(ctx context.Context) error
+}
+
+// commandRestarter restarts Charon by running an external command, which
+// covers docker compose, docker/podman restart and user-supplied commands.
+type commandRestarter struct {
+	args []string
+}
+
+// newRestarter builds the Restarter for the configured RESTART_BACKEND,
+// retrying failed restarts as configured.
+func newRestarter(cfg *Config) (Restarter, error) {
+	restarter, err := newBackendRestarter(cfg)
+	if err != nil || cfg.RestartRetries == 0 {
+		return restarter, err
+	}
+	return &retryingRestarter{
+		Restarter:  restarter,
+		retries:    cfg.RestartRetries,
+		backoff:    cfg.RestartRetryBackoff,
+		randInt63n: rand.Int64N,
+	}, nil
+}
+
+func newBackendRestarter(cfg *Config) (Restarter, error) {
+	switch cfg.RestartBackend {
+	case backendCompose:
+		args := append(composeCommand(cfg), "up")
+		args = append(args, cfg.ComposeServices...)
+		args = append(args, "-d", "--force-recreate")
+		return &commandRestarter{args: args}, nil
+	case backendDocker, backendPodman:
+		return &commandRestarter{args: []string{cfg.RestartBackend, "restart", cfg.RestartContainer}}, nil
+	case backendDockerAPI:
+		return newDockerAPIRestarter(cfg)
+	case backendKubernetes:
+		if cfg.K8SDeployment == "" {
+			return nil, fmt.Errorf("RESTART_BACKEND=kubernetes requires K8S_DEPLOYMENT")
+		}
+		return newKubernetesRestarter(cfg)
+	case backendNomad:
+		return newNomadRestarter(cfg)
+	case backendCommand:
+		if len(cfg.RestartCommand) == 0 {
+			return nil, fmt.Errorf("RESTART_BACKEND=command requires RESTART_COMMAND")
+		}
+		return &commandRestarter{args: cfg.RestartCommand}, nil
+	default:
+		return nil, fmt.Errorf("unknown restart backend %q", cfg.RestartBackend)
+	}
+}
+
+// composeCommand returns the docker compose invocation for the configured
+// compose project, to which a subcommand is appended.
+func composeCommand(cfg *Config) []string {
+	args := []string{"docker", "compose"}
+	if cfg.ComposeFile != "" {
+		args = append(args, "-f", cfg.ComposeFile)
+	}
+	return args
+}
+
+// checkComposeServices fails unless every configured compose service is
+// defined in the compose project, as listed by docker compose config
+// --services, so a misspelt service is caught at startup rather than on the
+// first IP change. output runs a command and returns its standard output,
+// as exec.Cmd.Output.
+func checkComposeServices(ctx context.Context, cfg *Config, output func(ctx context.Context, args []string) ([]byte, error)) error {
+	args := append(composeCommand(cfg), "config", "--services")
+	out, err := output(ctx, args)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%v: %s", err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return fmt.Errorf("failed to list compose services with %s: %v", strings.Join(args, " "), err)
+	}
+
+	defined := strings.Fields(string(out))
+	for _, service := range cfg.ComposeServices {
+		if !slices.Contains(defined, service) {
+			return fmt.Errorf("compose service %q is not defined in the compose project, which has: %s; set COMPOSE_SERVICES to the service running Charon",
+				service, strings.Join(defined, ", "))
+		}
+	}
+	return nil
+}
+
+// commandOutput runs args and returns its standard output.
+func commandOutput(ctx context.Context, args []string) ([]byte, error) {
+	return exec.CommandContext(ctx, args[0], args[1:]...).Output()
+}
+
+func (r *commandRestarter) String() string {
+	return strings.Join(r.args, " ")
+}
+
+func (r *commandRestarter) Restart(ctx context.Context) error {
+	slog.Info("Restarting Charon container", "command", r.String())
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, r.args[0], r.args[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart Charon: %w, output: %s", err, string(output))
+	}
+	slog.Info("Successfully restarted Charon container", "duration_ms", time.Since(start).Milliseconds())
+	return nil
+}
+
+// dryRunRestarter logs the restart the wrapped Restarter would perform.
+type dryRunRestarter struct {
+	Restarter
+}
+
+func (r dryRunRestarter) String() string {
+	return fmt.Sprintf("dry run of %v", r.Restarter)
+}
+
+func (r dryRunRestarter) Restart(ctx context.Context) error {
+	slog.Info("Dry run: would restart Charon", "command", fmt.Sprint(r.Restarter))
+	return nil
+}
+
+const (
+	defaultRestartRetries      = 3
+	defaultRestartRetryBackoff = 5 * time.Second
+	maxRestartRetries          = 10
+)
+
+// permanentRestartError marks a restart error that retrying cannot fix,
+// such as a missing container, deployment or job or a denied API request.
+// The API backends return it for the specific responses that mean this.
+type permanentRestartError struct {
+	err error
+}
+
+func (e *permanentRestartError) Error() string { return e.err.Error() }
+func (e *permanentRestartError) Unwrap() error { return e.err }
+
+// permanentRestartFailures are substrings of docker compose and docker CLI
+// errors that retrying cannot fix, as opposed to a daemon that is still
+// starting up.
+var permanentRestartFailures = []string{
+	"no such service",
+	"no such container",
+	"no configuration file provided",
+}
+
+// isPermanentRestartFailure reports whether err should not be retried.
+func isPermanentRestartFailure(err error) bool {
+	var permanent *permanentRestartError
+	if errors.As(err, &permanent) || errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range permanentRestartFailures {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryingRestarter retries the wrapped Restarter up to retries more times
+// with exponential backoff from backoff, for transient failures such as
+// "Cannot connect to the Docker daemon" right after the host boots.
+type retryingRestarter struct {
+	Restarter
+	retries int
+	backoff time.Duration
+	// randInt63n jitters the backoff like backoffDuration; tests make it
+	// deterministic.
+	randInt63n func(int64) int64
+}
+
+func (r *retryingRestarter) String() string {
+	return fmt.Sprint(r.Restarter)
+}
+
+func (r *retryingRestarter) Restart(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := r.Restarter.Restart(ctx)
+		if err == nil || ctx.Err() != nil || attempt > r.retries || isPermanentRestartFailure(err) {
+			return err
+		}
+
+		delay := backoffDuration(attempt, r.backoff, r.backoff<<r.retries, r.randInt63n)
+		slog.Warn("Restart failed, retrying", "attempt", attempt, "delay", delay.String(), "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+const (
+	defaultHealthCheckURL     = "http://localhost:3620/readyz"
+	defaultHealthCheckTimeout = 2 * time.Minute
+	healthCheckPollInterval   = 5 * time.Second
+)
+
+// UnhealthyError reports that the restart command succeeded but Charon did
+// not become healthy afterwards, as opposed to the restart itself failing.
+type UnhealthyError struct {
+	URL string
+	Err error
+}
+
+func (e *UnhealthyError) Error() string {
+	return fmt.Sprintf("Charon did not become healthy at %s: %v", e.URL, e.Err)
+}
+
+func (e *UnhealthyError) Unwrap() error {
+	return e.Err
+}
+
+// waitHealthy polls url until it answers 200 OK or timeout elapses.
+func waitHealthy(ctx context.Context, url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: healthCheckPollInterval}
+	ticker := time.NewTicker(healthCheckPollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		lastErr = checkHealth(ctx, client, url)
+		if lastErr == nil {
+			slog.Info("Charon is healthy", "attempt", attempt)
+			return nil
+		}
+		slog.Warn("Health check failed", "attempt", attempt, "url", url, "error", lastErr)
+
+		select {
+		case <-ctx.Done():
+			return &UnhealthyError{URL: url, Err: lastErr}
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// splitCommand splits s into words the way a POSIX shell would for a simple
+// command: whitespace separates words, single quotes preserve everything
+// literally, and double quotes and backslashes escape as usual. Variable
+// expansion, globbing and operators are not supported.
+func splitCommand(s string) ([]string, error) {
+	var (
+		words   []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes a few characters.
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash")
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+
+	return words, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+const backendDockerAPI = "docker-api"
+
+// dockerAPIRestarter restarts Charon through the Docker Engine API, so the
+// docker CLI doesn't need to be installed. The daemon is located from the
+// standard DOCKER_HOST / DOCKER_* environment variables.
+type dockerAPIRestarter struct {
+	client *client.Client
+	// Containers are selected by label (e.g. com.docker.compose.service=charon)
+	// when set, otherwise by name.
+	name  string
+	label string
+}
+
+func newDockerAPIRestarter(cfg *Config) (*dockerAPIRestarter, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %v", err)
+	}
+
+	return &dockerAPIRestarter{
+		client: cli,
+		name:   cfg.RestartContainer,
+		label:  cfg.RestartContainerLabel,
+	}, nil
+}
+
+func (r *dockerAPIRestarter) String() string {
+	if r.label != "" {
+		return fmt.Sprintf("Docker API restart of containers labelled %s via %s", r.label, r.client.DaemonHost())
+	}
+	return fmt.Sprintf("Docker API restart of container %s via %s", r.name, r.client.DaemonHost())
+}
+
+func (r *dockerAPIRestarter) Restart(ctx context.Context) error {
+	ids, err := r.containerIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		slog.Info("Restarting Charon container via Docker API", "container", id)
+		start := time.Now()
+		if err := r.client.ContainerRestart(ctx, id, container.StopOptions{}); err != nil {
+			return fmt.Errorf("failed to restart container %s: %v", id, err)
+		}
+		slog.Info("Successfully restarted Charon container", "container", id, "duration_ms", time.Since(start).Milliseconds())
+	}
+
+	return nil
+}
+
+// containerIDs resolves the configured name or label to container IDs,
+// reporting a missing container as a clear error.
+func (r *dockerAPIRestarter) containerIDs(ctx context.Context) ([]string, error) {
+	if r.label != "" {
+		containers, err := r.client.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", r.label)),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers: %v", err)
+		}
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("no container found with label %q", r.label)
+		}
+
+		ids := make([]string, 0, len(containers))
+		for _, c := range containers {
+			ids = append(ids, c.ID)
+		}
+		return ids, nil
+	}
+
+	info, err := r.client.ContainerInspect(ctx, r.name)
+	if errdefs.IsNotFound(err) {
+		return nil, &permanentRestartError{fmt.Errorf("container %q not found", r.name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %q: %v", r.name, err)
+	}
+
+	return []string{info.ID}, nil
+}
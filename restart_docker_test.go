@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDockerAPIRestarter(t *testing.T) {
+	var restarted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_ping"):
+			w.Header().Set("API-Version", "1.41")
+			w.WriteHeader(http.StatusOK)
+		case strings.HasSuffix(r.URL.Path, "/containers/charon/json"):
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"Id":"abc123","Name":"/charon"}`))
+		case strings.HasSuffix(r.URL.Path, "/containers/missing/json"):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container: missing"}`))
+		case strings.HasSuffix(r.URL.Path, "/restart"):
+			parts := strings.Split(r.URL.Path, "/")
+			restarted = append(restarted, parts[len(parts)-2])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(srv.URL, "http://"))
+
+	r, err := newDockerAPIRestarter(&Config{RestartContainer: "charon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Restart(context.Background()); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	if len(restarted) != 1 || restarted[0] != "abc123" {
+		t.Fatalf("restarted containers = %v, want [abc123]", restarted)
+	}
+
+	r.name = "missing"
+	err = r.Restart(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `container "missing" not found`) {
+		t.Fatalf("expected a not-found error, got %v", err)
+	}
+}
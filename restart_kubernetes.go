@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	backendKubernetes = "kubernetes"
+
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// restartedAtAnnotation is the pod template annotation kubectl rollout
+	// restart sets; changing it rolls the Deployment's pods.
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+// kubernetesRestarter performs the equivalent of kubectl rollout restart on
+// a Deployment, authenticating with the pod's service account. The API is
+// called directly, which needs nothing beyond a strategic merge patch.
+type kubernetesRestarter struct {
+	client     *http.Client
+	apiURL     string
+	namespace  string
+	deployment string
+	// tokenPath is re-read for every restart, as the kubelet rotates
+	// projected service account tokens.
+	tokenPath string
+}
+
+// newKubernetesRestarter builds a restarter from the in-cluster
+// configuration. The namespace defaults to the pod's own.
+func newKubernetesRestarter(cfg *Config) (*kubernetesRestarter, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("RESTART_BACKEND=kubernetes must run inside a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	caPEM, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s/ca.crt", serviceAccountDir)
+	}
+
+	namespace := cfg.K8SNamespace
+	if namespace == "" {
+		raw, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("K8S_NAMESPACE is not set and the pod namespace is unknown: %v", err)
+		}
+		namespace = strings.TrimSpace(string(raw))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+
+	return &kubernetesRestarter{
+		client:     &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		apiURL:     "https://" + net.JoinHostPort(host, port),
+		namespace:  namespace,
+		deployment: cfg.K8SDeployment,
+		tokenPath:  serviceAccountDir + "/token",
+	}, nil
+}
+
+func (r *kubernetesRestarter) String() string {
+	return fmt.Sprintf("rollout restart of deployment %s/%s", r.namespace, r.deployment)
+}
+
+func (r *kubernetesRestarter) Restart(ctx context.Context) error {
+	token, err := os.ReadFile(r.tokenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read service account token: %v", err)
+	}
+
+	patch := map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						restartedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/apis/apps/v1/namespaces/%s/deployments/%s", r.apiURL, r.namespace, r.deployment)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	slog.Info("Restarting Charon deployment", "namespace", r.namespace, "deployment", r.deployment)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to restart deployment: %v", err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &permanentRestartError{fmt.Errorf("deployment %s/%s not found", r.namespace, r.deployment)}
+	case resp.StatusCode == http.StatusForbidden:
+		return &permanentRestartError{fmt.Errorf("restarting deployment %s/%s forbidden: %s", r.namespace, r.deployment, bytes.TrimSpace(detail))}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("failed to restart deployment: received status code %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+
+	slog.Info("Successfully triggered a rolling restart of the Charon deployment", "namespace", r.namespace, "deployment", r.deployment)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKubernetesRestarter(t *testing.T) {
+	var (
+		gotPath, gotType, gotAuth string
+		gotPatch                  struct {
+			Spec struct {
+				Template struct {
+					Metadata struct {
+						Annotations map[string]string `json:"annotations"`
+					} `json:"metadata"`
+				} `json:"template"`
+			} `json:"spec"`
+		}
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("method = %s, want PATCH", r.Method)
+		}
+		gotPath, gotType, gotAuth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&gotPatch); err != nil {
+			t.Errorf("decoding patch: %v", err)
+		}
+		if r.URL.Path != "/apis/apps/v1/namespaces/obol/deployments/charon" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &kubernetesRestarter{
+		client:     srv.Client(),
+		apiURL:     srv.URL,
+		namespace:  "obol",
+		deployment: "charon",
+		tokenPath:  tokenPath,
+	}
+	if err := r.Restart(context.Background()); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+
+	if gotPath != "/apis/apps/v1/namespaces/obol/deployments/charon" {
+		t.Errorf("path = %s", gotPath)
+	}
+	if gotType != "application/strategic-merge-patch+json" {
+		t.Errorf("Content-Type = %s", gotType)
+	}
+	if gotAuth != "Bearer secret-token" {
+		t.Errorf("Authorization = %q", gotAuth)
+	}
+	restartedAt := gotPatch.Spec.Template.Metadata.Annotations[restartedAtAnnotation]
+	if _, err := time.Parse(time.RFC3339, restartedAt); err != nil {
+		t.Errorf("%s annotation = %q, want an RFC 3339 time", restartedAtAnnotation, restartedAt)
+	}
+
+	r.deployment = "missing"
+	err := r.Restart(context.Background())
+	if err == nil || !isPermanentRestartFailure(err) {
+		t.Errorf("Restart of a missing deployment: err = %v, want a permanent failure", err)
+	}
+}
+
+func TestNewKubernetesRestarterOutsideCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	cfg := Config{RestartBackend: backendKubernetes, K8SDeployment: "charon"}
+	if _, err := newRestarter(&cfg); err == nil {
+		t.Fatal("expected an error outside a cluster")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	backendNomad = "nomad"
+
+	defaultNomadAddr = "http://127.0.0.1:4646"
+	// nomadRestartedAtMeta is set on every update so the job changes, and
+	// its allocations are replaced, even when the IP in the meta does not.
+	nomadRestartedAtMeta = "ip_updater_restarted_at"
+	// nomadEvalPollInterval is how often the scheduling evaluation is
+	// polled after the job is updated.
+	nomadEvalPollInterval = time.Second
+	nomadEvalTimeout      = 2 * time.Minute
+)
+
+// nomadRestarter redeploys Charon's Nomad job through the HTTP API. The
+// values of the env keys are copied from the .env file, which has already
+// been written by the time of the restart, into the job meta, so a job
+// can read them as NOMAD_META_<key> as well as from a templated .env.
+// Changing the meta makes Nomad replace the job's allocations.
+type nomadRestarter struct {
+	client  *http.Client
+	addr    string
+	token   string
+	job     string
+	envFile string
+	envKeys []string
+	// pollInterval is how often the evaluation is checked.
+	pollInterval time.Duration
+}
+
+func newNomadRestarter(cfg *Config) (*nomadRestarter, error) {
+	if cfg.NomadJob == "" {
+		return nil, fmt.Errorf("RESTART_BACKEND=nomad requires NOMAD_JOB")
+	}
+	u, err := url.Parse(cfg.NomadAddr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("NOMAD_ADDR must be an http or https URL, got %q", cfg.NomadAddr)
+	}
+
+	return &nomadRestarter{
+		client:       &http.Client{Timeout: 30 * time.Second},
+		addr:         strings.TrimSuffix(cfg.NomadAddr, "/"),
+		token:        cfg.NomadToken,
+		job:          cfg.NomadJob,
+		envFile:      cfg.EnvFile,
+		envKeys:      cfg.EnvKeys,
+		pollInterval: nomadEvalPollInterval,
+	}, nil
+}
+
+func (r *nomadRestarter) String() string {
+	return fmt.Sprintf("redeploy of Nomad job %s via %s", r.job, r.addr)
+}
+
+func (r *nomadRestarter) Restart(ctx context.Context) error {
+	env, err := godotenv.Read(r.envFile)
+	if err != nil {
+		return fmt.Errorf("failed to read .env file: %v", err)
+	}
+
+	// The job is round-tripped as a generic map so fields this updater
+	// doesn't know about are sent back unchanged.
+	var job map[string]any
+	if err := r.do(ctx, http.MethodGet, "/v1/job/"+url.PathEscape(r.job), nil, &job); err != nil {
+		return err
+	}
+
+	meta, _ := job["Meta"].(map[string]any)
+	if meta == nil {
+		meta = map[string]any{}
+	}
+	for _, key := range r.envKeys {
+		meta[key] = env[key]
+	}
+	meta[nomadRestartedAtMeta] = time.Now().UTC().Format(time.RFC3339)
+	job["Meta"] = meta
+
+	// EnforceIndex makes Nomad reject the update if the job was changed
+	// since it was read, rather than overwrite that change.
+	register := map[string]any{
+		"Job":            job,
+		"EnforceIndex":   true,
+		"JobModifyIndex": job["JobModifyIndex"],
+	}
+	var resp struct {
+		EvalID string
+	}
+	slog.Info("Redeploying Charon Nomad job", "job", r.job)
+	if err := r.do(ctx, http.MethodPost, "/v1/job/"+url.PathEscape(r.job), register, &resp); err != nil {
+		return err
+	}
+	if resp.EvalID == "" {
+		// Nothing to schedule, such as for a stopped job.
+		slog.Info("Updated Nomad job, no allocations to replace", "job", r.job)
+		return nil
+	}
+
+	if err := r.waitEvaluation(ctx, resp.EvalID); err != nil {
+		return err
+	}
+	slog.Info("Successfully redeployed the Charon Nomad job", "job", r.job, "eval_id", resp.EvalID)
+	return nil
+}
+
+// waitEvaluation waits for Nomad to finish scheduling the replacement
+// allocations. Allocations Nomad could not place, for lack of resources for
+// example, are reported as an error; Nomad keeps trying to place them
+// through a blocked evaluation of its own.
+func (r *nomadRestarter) waitEvaluation(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, nomadEvalTimeout)
+	defer cancel()
+
+	for {
+		var eval struct {
+			Status            string
+			StatusDescription string
+			FailedTGAllocs    map[string]any
+		}
+		if err := r.do(ctx, http.MethodGet, "/v1/evaluation/"+url.PathEscape(id), nil, &eval); err != nil {
+			return err
+		}
+
+		switch eval.Status {
+		case "complete":
+			if len(eval.FailedTGAllocs) > 0 {
+				groups := make([]string, 0, len(eval.FailedTGAllocs))
+				for group := range eval.FailedTGAllocs {
+					groups = append(groups, group)
+				}
+				return fmt.Errorf("Nomad could not place allocations for task groups %s of job %s", strings.Join(groups, ", "), r.job)
+			}
+			return nil
+		case "failed", "canceled":
+			return fmt.Errorf("Nomad evaluation %s %s: %s", id, eval.Status, eval.StatusDescription)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for Nomad evaluation %s: %v", id, ctx.Err())
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// do sends a request to the Nomad API, encoding body and decoding the
+// response into out.
+func (r *nomadRestarter) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.addr+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.token != "" {
+		req.Header.Set("X-Nomad-Token", r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Nomad API request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return &permanentRestartError{fmt.Errorf("Nomad job %s not found", r.job)}
+	case resp.StatusCode == http.StatusForbidden:
+		return &permanentRestartError{fmt.Errorf("Nomad API request forbidden, check NOMAD_TOKEN")}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("Nomad API %s %s: received status code %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(detail))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode Nomad API response: %v", err)
+	}
+	return nil
+}
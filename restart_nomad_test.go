@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNomad serves a single job, recording the registration it receives.
+// Evaluations report pending once before completing with failed.
+type fakeNomad struct {
+	t          *testing.T
+	failed     map[string]any
+	registered map[string]any
+	evalPolls  int
+}
+
+func (n *fakeNomad) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if got := r.Header.Get("X-Nomad-Token"); got != "secret-token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/job/charon":
+		json.NewEncoder(w).Encode(map[string]any{
+			"ID":             "charon",
+			"JobModifyIndex": 42,
+			"Meta":           map[string]any{"owner": "obol"},
+			"TaskGroups":     []any{map[string]any{"Name": "charon"}},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/job/charon":
+		if err := json.NewDecoder(r.Body).Decode(&n.registered); err != nil {
+			n.t.Errorf("decoding registration: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"EvalID": "eval-1"})
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/evaluation/eval-1":
+		n.evalPolls++
+		status := "pending"
+		if n.evalPolls > 1 {
+			status = "complete"
+		}
+		json.NewEncoder(w).Encode(map[string]any{"Status": status, "FailedTGAllocs": n.failed})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestNomadRestarter(t *testing.T, srv *httptest.Server) *nomadRestarter {
+	t.Helper()
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte(envKey+"=2.2.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newNomadRestarter(&Config{
+		NomadAddr:  srv.URL + "/",
+		NomadToken: "secret-token",
+		NomadJob:   "charon",
+		EnvFile:    envFile,
+		EnvKeys:    []string{envKey},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.pollInterval = time.Millisecond
+	return r
+}
+
+func TestNomadRestarter(t *testing.T) {
+	nomad := &fakeNomad{t: t}
+	srv := httptest.NewServer(nomad)
+	defer srv.Close()
+
+	r := newTestNomadRestarter(t, srv)
+	if err := r.Restart(context.Background()); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+
+	if nomad.registered["EnforceIndex"] != true || nomad.registered["JobModifyIndex"] != float64(42) {
+		t.Errorf("registration = %v, want the index read enforced", nomad.registered)
+	}
+	job, _ := nomad.registered["Job"].(map[string]any)
+	if _, ok := job["TaskGroups"]; !ok {
+		t.Errorf("job = %v, want its other fields sent back", job)
+	}
+	meta, _ := job["Meta"].(map[string]any)
+	if meta[envKey] != "2.2.2.2" || meta["owner"] != "obol" {
+		t.Errorf("meta = %v, want the new IP added to the existing meta", meta)
+	}
+	if _, err := time.Parse(time.RFC3339, meta[nomadRestartedAtMeta].(string)); err != nil {
+		t.Errorf("%s meta = %v, want an RFC 3339 time", nomadRestartedAtMeta, meta[nomadRestartedAtMeta])
+	}
+	if nomad.evalPolls != 2 {
+		t.Errorf("evaluation polled %d times, want until complete", nomad.evalPolls)
+	}
+}
+
+func TestNomadRestarterFailures(t *testing.T) {
+	nomad := &fakeNomad{t: t, failed: map[string]any{"charon": map[string]any{}}}
+	srv := httptest.NewServer(nomad)
+	defer srv.Close()
+
+	r := newTestNomadRestarter(t, srv)
+	if err := r.Restart(context.Background()); err == nil || !strings.Contains(err.Error(), "could not place") {
+		t.Errorf("Restart with unplaced allocations: err = %v", err)
+	}
+
+	r.job = "missing"
+	if err := r.Restart(context.Background()); err == nil || !isPermanentRestartFailure(err) {
+		t.Errorf("Restart of a missing job: err = %v, want a permanent failure", err)
+	}
+
+	r.job, r.token = "charon", "wrong"
+	if err := r.Restart(context.Background()); err == nil || !isPermanentRestartFailure(err) {
+		t.Errorf("Restart with a bad token: err = %v, want a permanent failure", err)
+	}
+}
+
+func TestNewNomadRestarterValidation(t *testing.T) {
+	for _, cfg := range []*Config{
+		{NomadAddr: defaultNomadAddr},
+		{NomadAddr: "127.0.0.1:4646", NomadJob: "charon"},
+	} {
+		if _, err := newNomadRestarter(cfg); err == nil {
+			t.Errorf("newNomadRestarter(%+v) succeeded", cfg)
+		}
+	}
+}
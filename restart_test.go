@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"docker compose up charon -d", []string{"docker", "compose", "up", "charon", "-d"}},
+		{"  docker   restart\tcharon  ", []string{"docker", "restart", "charon"}},
+		{`docker compose -f "/opt/my stack/compose.yml" up`, []string{"docker", "compose", "-f", "/opt/my stack/compose.yml", "up"}},
+		{`sh -c 'echo "$IP"'`, []string{"sh", "-c", `echo "$IP"`}},
+		{`echo a\ b "c\"d" "e\f"`, []string{"echo", "a b", `c"d`, `e\f`}},
+		{`echo ""`, []string{"echo", ""}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil {
+			t.Errorf("splitCommand(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandErrors(t *testing.T) {
+	for _, in := range []string{`echo "unterminated`, `echo 'unterminated`, `echo trailing\`} {
+		if _, err := splitCommand(in); err == nil {
+			t.Errorf("splitCommand(%q) expected an error", in)
+		}
+	}
+}
+
+func TestNewRestarter(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []string
+	}{
+		{
+			name: "compose default",
+			cfg:  Config{RestartBackend: backendCompose, ComposeServices: []string{"charon"}},
+			want: []string{"docker", "compose", "up", "charon", "-d", "--force-recreate"},
+		},
+		{
+			name: "compose with file",
+			cfg:  Config{RestartBackend: backendCompose, ComposeServices: []string{"node"}, ComposeFile: "/srv/compose.yml"},
+			want: []string{"docker", "compose", "-f", "/srv/compose.yml", "up", "node", "-d", "--force-recreate"},
+		},
+		{
+			name: "compose multiple services",
+			cfg:  Config{RestartBackend: backendCompose, ComposeServices: []string{"charon", "sidecar"}},
+			want: []string{"docker", "compose", "up", "charon", "sidecar", "-d", "--force-recreate"},
+		},
+		{
+			name: "docker restart",
+			cfg:  Config{RestartBackend: backendDocker, RestartContainer: "charon-1"},
+			want: []string{"docker", "restart", "charon-1"},
+		},
+		{
+			name: "podman restart",
+			cfg:  Config{RestartBackend: backendPodman, RestartContainer: "charon"},
+			want: []string{"podman", "restart", "charon"},
+		},
+		{
+			name: "custom command",
+			cfg:  Config{RestartBackend: backendCommand, RestartCommand: []string{"systemctl", "restart", "charon"}},
+			want: []string{"systemctl", "restart", "charon"},
+		},
+	}
+
+	for _, tt := range tests {
+		restarter, err := newRestarter(&tt.cfg)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		got := restarter.(*commandRestarter).args
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: args = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewRestarterErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{RestartBackend: "systemd"},
+		{RestartBackend: backendCommand},
+		{RestartBackend: backendKubernetes},
+	} {
+		if _, err := newRestarter(&cfg); err == nil {
+			t.Errorf("newRestarter(%+v) expected an error", cfg)
+		}
+	}
+}
+
+func TestCheckComposeServices(t *testing.T) {
+	var gotArgs []string
+	output := func(ctx context.Context, args []string) ([]byte, error) {
+		gotArgs = args
+		return []byte("lighthouse\ncharon\nmev-boost\n"), nil
+	}
+
+	cfg := &Config{ComposeFile: "/srv/charon/compose.yaml", ComposeServices: []string{"charon", "mev-boost"}}
+	if err := checkComposeServices(context.Background(), cfg, output); err != nil {
+		t.Fatalf("checkComposeServices: %v", err)
+	}
+	want := []string{"docker", "compose", "-f", "/srv/charon/compose.yaml", "config", "--services"}
+	if !reflect.DeepEqual(gotArgs, want) {
+		t.Errorf("args = %v, want %v", gotArgs, want)
+	}
+
+	cfg.ComposeServices = []string{"charon-1"}
+	err := checkComposeServices(context.Background(), cfg, output)
+	if err == nil || !strings.Contains(err.Error(), `"charon-1" is not defined`) || !strings.Contains(err.Error(), "lighthouse, charon, mev-boost") {
+		t.Errorf("err = %v, want the missing service and the defined ones named", err)
+	}
+
+	failing := func(ctx context.Context, args []string) ([]byte, error) {
+		return nil, errors.New("no configuration file provided")
+	}
+	if err := checkComposeServices(context.Background(), cfg, failing); err == nil {
+		t.Error("checkComposeServices succeeded without a compose project")
+	}
+}
+
+// scriptedRestarter fails with errs in turn, then succeeds.
+type scriptedRestarter struct {
+	errs  []error
+	calls int
+}
+
+func (r *scriptedRestarter) Restart(ctx context.Context) error {
+	r.calls++
+	if r.calls <= len(r.errs) {
+		return r.errs[r.calls-1]
+	}
+	return nil
+}
+
+func TestRetryingRestarter(t *testing.T) {
+	daemonDown := errors.New("failed to restart Charon: exit status 1, output: Cannot connect to the Docker daemon at unix:///var/run/docker.sock. Is the docker daemon running?")
+	noService := errors.New("failed to restart Charon: exit status 1, output: no such service: charon")
+	// A proxy in front of the daemon, not a missing container.
+	proxyDown := errors.New("failed to restart Charon: exit status 1, output: error during connect: upstream not found")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"succeeds first time", nil, 1, false},
+		{"transient failure", []error{daemonDown, daemonDown}, 3, false},
+		{"retries exhausted", []error{daemonDown, daemonDown, daemonDown, daemonDown}, 3, true},
+		{"permanent failure", []error{noService, noService}, 1, true},
+		{"transient not found", []error{proxyDown}, 2, false},
+		{"missing binary", []error{fmt.Errorf("failed to restart Charon: %w", exec.ErrNotFound)}, 1, true},
+	}
+
+	for _, tt := range tests {
+		backend := &scriptedRestarter{errs: tt.errs}
+		r := &retryingRestarter{
+			Restarter:  backend,
+			retries:    2,
+			backoff:    time.Millisecond,
+			randInt63n: func(n int64) int64 { return n - 1 },
+		}
+
+		err := r.Restart(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if backend.calls != tt.wantCalls {
+			t.Errorf("%s: attempts = %d, want %d", tt.name, backend.calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestNewRestarterRetries(t *testing.T) {
+	cfg := Config{RestartBackend: backendDocker, RestartContainer: "charon", RestartRetries: 2, RestartRetryBackoff: time.Second}
+	restarter, err := newRestarter(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrying, ok := restarter.(*retryingRestarter)
+	if !ok || retrying.retries != 2 {
+		t.Fatalf("newRestarter = %#v, want a retryingRestarter with 2 retries", restarter)
+	}
+	if _, ok := retrying.Restarter.(*commandRestarter); !ok {
+		t.Errorf("wrapped restarter = %T, want *commandRestarter", retrying.Restarter)
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	if err := waitHealthy(context.Background(), healthy.URL, time.Second); err != nil {
+		t.Fatalf("expected healthy, got %v", err)
+	}
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	err := waitHealthy(context.Background(), unhealthy.URL, 100*time.Millisecond)
+	var unhealthyErr *UnhealthyError
+	if !errors.As(err, &unhealthyErr) {
+		t.Fatalf("expected *UnhealthyError, got %v", err)
+	}
+	if unhealthyErr.URL != unhealthy.URL {
+		t.Errorf("UnhealthyError.URL = %q, want %q", unhealthyErr.URL, unhealthy.URL)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const serverShutdownTimeout = 5 * time.Second
+
+// startHTTPServer serves handler on addr in the background until ctx is
+// cancelled. Listen errors are logged rather than fatal, since the HTTP
+// endpoints are auxiliary to the update loop.
+func startHTTPServer(ctx context.Context, name, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Starting HTTP server", "server", name, "addr", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server failed", "server", name, "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+}
+
+// liveness tracks when the update loop last completed a successful check and
+// serves /healthz, reporting unhealthy once that is older than window. The
+// window is read on every probe, so it follows a reloaded CHECK_INTERVAL.
+type liveness struct {
+	mu                  sync.Mutex
+	lastSuccessfulCheck time.Time
+	window              func() time.Duration
+}
+
+// newLiveness starts the clock at creation so the first check gets a full
+// window to complete.
+func newLiveness(window func() time.Duration) *liveness {
+	return &liveness{lastSuccessfulCheck: time.Now(), window: window}
+}
+
+func (l *liveness) markSuccess() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastSuccessfulCheck = time.Now()
+}
+
+func (l *liveness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	since := time.Since(l.lastSuccessfulCheck)
+	l.mu.Unlock()
+
+	if since > l.window() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "no successful check for %v\n", since.Round(time.Second))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "ok, last successful check %v ago\n", since.Round(time.Second))
+}
+
+const (
+	defaultHistoryLimit = 10
+	maxHistoryLimit     = 1000
+)
+
+// parseLimit reads the limit query parameter, writing a 400 response and
+// returning false if it is invalid.
+func parseLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit := defaultHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxHistoryLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", maxHistoryLimit), http.StatusBadRequest)
+			return 0, false
+		}
+		limit = value
+	}
+	return limit, true
+}
+
+// listHandler serves the newest entries returned by list as a JSON array.
+func listHandler[T any](what string, list func(limit int) ([]T, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		limit, ok := parseLimit(w, r)
+		if !ok {
+			return
+		}
+
+		records, err := list(limit)
+		if err != nil {
+			slog.Error("Failed to query "+what, "error", err)
+			http.Error(w, "failed to query "+what, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+	})
+}
+
+// runtimeStatus is served by GET /status.
+type runtimeStatus struct {
+	StartedAt       time.Time `json:"started_at"`
+	UptimeSeconds   int64     `json:"uptime_seconds"`
+	ChecksPerformed int64     `json:"checks_performed"`
+	// LastRestartAt is omitted until Charon has been restarted.
+	LastRestartAt *time.Time `json:"last_restart_at,omitempty"`
+	// DetectedIP and NextCheckAt are omitted until the first check.
+	DetectedIP  string     `json:"detected_ip,omitempty"`
+	NextCheckAt *time.Time `json:"next_check_at,omitempty"`
+	// StoredIP is the latest IP in the database and EnvIP the one in .env,
+	// omitted in dns mode. Synced is true once both match DetectedIP.
+	StoredIP string `json:"stored_ip,omitempty"`
+	EnvIP    string `json:"env_ip,omitempty"`
+	Synced   bool   `json:"synced"`
+}
+
+// currentStatus reports the state served on /status and the control
+// socket. Unlike status it does not fetch the current IP, DetectedIP is the
+// one the last check saw.
+func (s *Service) currentStatus() (runtimeStatus, error) {
+	status := runtimeStatus{
+		StartedAt:       s.started,
+		UptimeSeconds:   int64(s.uptime().Seconds()),
+		ChecksPerformed: s.checks.Load(),
+	}
+	status.DetectedIP, _ = s.detectedIP.Load().(string)
+	if next := s.nextCheck.Load(); next != 0 {
+		at := time.Unix(0, next)
+		status.NextCheckAt = &at
+	}
+
+	restarted, err := lastRestart(s.store)
+	switch {
+	case err == nil:
+		status.LastRestartAt = &restarted
+	case err != sql.ErrNoRows:
+		return status, fmt.Errorf("failed to query the last restart time: %v", err)
+	}
+	status.StoredIP, err = s.store.LatestIP()
+	if err != nil && err != sql.ErrNoRows {
+		return status, fmt.Errorf("failed to query database: %v", err)
+	}
+
+	report := statusReport{DetectedIP: status.DetectedIP, StoredIP: status.StoredIP, EnvChecked: s.config().updatesEnv()}
+	if report.EnvChecked {
+		if envIP, err := s.env.CurrentIP(); err == nil {
+			report.EnvIP = normalizeEnvIP(envIP)
+		}
+		status.EnvIP = report.EnvIP
+	}
+	status.Synced = report.synced()
+	return status, nil
+}
+
+// runtimeHandler serves GET /status: how long the updater has been running,
+// how many checks it has performed, the IP it last detected and whether
+// the database and .env match it, when it checks next and when it last
+// restarted Charon.
+func runtimeHandler(svc *Service) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		status, err := svc.currentStatus()
+		if err != nil {
+			slog.Error("Failed to report status", "error", err)
+			http.Error(w, "failed to report status", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// historyHandler serves GET /ip: the current IP followed by earlier ones,
+// newest first, as a JSON array. The ?limit= parameter bounds the length.
+func historyHandler(store Store) http.Handler {
+	return listHandler("IP history", store.History)
+}
+
+// eventsHandler serves GET /events: the audit log of IP changes, .env
+// resyncs and restarts, newest first, bounded by ?limit= like /ip.
+func eventsHandler(store Store) http.Handler {
+	return listHandler("events", store.Events)
+}
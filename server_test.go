@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLiveness(t *testing.T) {
+	window := time.Minute
+	live := newLiveness(func() time.Duration { return window })
+
+	rec := httptest.NewRecorder()
+	live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("fresh liveness status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	live.lastSuccessfulCheck = time.Now().Add(-2 * time.Minute)
+	rec = httptest.NewRecorder()
+	live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("stale liveness status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// A reloaded, longer check interval widens the window.
+	window = 5 * time.Minute
+	rec = httptest.NewRecorder()
+	live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("liveness status with a widened window = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	live.markSuccess()
+	rec = httptest.NewRecorder()
+	live.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("liveness status after success = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	db := newTestDB(t)
+	for i, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		ts := time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if _, err := db.Exec("INSERT INTO ip_store (ip, updated_at) VALUES (?, ?)", ip, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	historyHandler(&sqlStore{dialect: sqliteDialect, db: db}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ip?limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var records []ipRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].IP != "3.3.3.3" || records[1].IP != "2.2.2.2" {
+		t.Fatalf("unexpected history: %+v", records)
+	}
+}
+
+func TestHistoryHandlerRejectsBadLimit(t *testing.T) {
+	db := newTestDB(t)
+	for _, limit := range []string{"abc", "0", "-1", "100000", "1;DROP TABLE ip_store"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ip?limit="+url.QueryEscape(limit), nil)
+		historyHandler(&sqlStore{dialect: sqliteDialect, db: db}).ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("limit=%q: status = %d, want %d", limit, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRuntimeHandler(t *testing.T) {
+	store := &memoryStore{}
+	svc := &Service{cfg: &Config{}, started: time.Now().Add(-90 * time.Second), store: store, env: &memoryEnvWriter{}}
+	svc.checks.Add(3)
+
+	get := func() runtimeStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		runtimeHandler(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		var got runtimeStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := get()
+	if got.ChecksPerformed != 3 || got.UptimeSeconds < 90 || !got.StartedAt.Equal(svc.started) || got.LastRestartAt != nil {
+		t.Errorf("runtime status = %+v", got)
+	}
+
+	restarted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc.recordRestart(restarted)
+	if got := get(); got.LastRestartAt == nil || !got.LastRestartAt.Equal(restarted) {
+		t.Errorf("last_restart_at = %v, want %v", got.LastRestartAt, restarted)
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errFetchIP marks failures to determine the current public IP, which the
+// polling loop treats differently from local (database / .env) failures.
+var errFetchIP = errors.New("failed to get current IP")
+
+// checkState carries what the polling loop remembers between checks.
+type checkState struct {
+	confirm *debouncer
+	// resyncedFrom is the divergent .env value most recently overwritten
+	// by a resync; seeing it again means the resync didn't take and
+	// restarting again would only loop.
+	resyncedFrom string
+	// flap remembers recent readings to tell a flapping IP from a change.
+	flap *flapDetector
+	// hostnameUpdatedTo is the IP the DNS record of the .env hostname was
+	// last updated to, so it is not updated again while the change
+	// propagates.
+	hostnameUpdatedTo string
+	// dnsPending is the IP whose DNS update failed after .env was already
+	// updated and Charon restarted. Later checks retry only the DNS
+	// update, without touching .env or restarting again.
+	dnsPending string
+}
+
+func newCheckState(confirmCount int) *checkState {
+	return &checkState{confirm: newDebouncer(confirmCount), flap: &flapDetector{}}
+}
+
+// Service ties IP detection to the actions taken when the IP changes. Its
+// dependencies are interfaces so main can wire the real implementations and
+// tests can wire fakes.
+type Service struct {
+	cfg       *Config
+	fetcher   IPFetcher
+	store     Store
+	env       EnvWriter
+	restarter Restarter
+	// dns is nil unless a DDNS provider is configured.
+	dns DNSUpdater
+	// reach, if set, checks the P2P port whenever the IP changes.
+	reach *reachabilityProbe
+	// enr, if set, reads the IP Charon advertises, which is compared
+	// instead of the one in .env.
+	enr *charonENR
+	// preHook and postHook are nil unless configured.
+	preHook  Hook
+	postHook Hook
+	notifier *dispatcher
+	state    *checkState
+	// live, if set, is marked after every successful check.
+	live *liveness
+	// systemd is nil unless running as a Type=notify systemd service.
+	systemd *systemdNotifier
+	// lease is nil unless HA is enabled, in which case only its holder
+	// applies updates.
+	lease *leaderLease
+	// lookupIP resolves a hostname found in .env; nil means
+	// net.DefaultResolver.LookupIP.
+	lookupIP func(ctx context.Context, network, host string) ([]net.IP, error)
+	// recheck, if set, cuts the wait between checks short, e.g. on SIGHUP.
+	recheck <-chan os.Signal
+	// reload, if set, is called on recheck to re-read the configuration.
+	reload func() (*Config, error)
+
+	// started is when the updater started, and checks how many checks the
+	// polling loop has run, whatever their outcome.
+	started time.Time
+	checks  atomic.Int64
+	// detectedIP is the IP the last successful detection returned, and
+	// nextCheck when the polling loop checks next, in Unix nanoseconds.
+	detectedIP atomic.Value
+	nextCheck  atomic.Int64
+
+	// mu serializes checks from the polling loop and the control API, and
+	// guards the fields a configuration reload replaces: cfg, fetcher,
+	// notifier and state.
+	mu sync.Mutex
+}
+
+// Check performs a single check: it fetches the current IP, compares it
+// against the database and .env, and updates .env and restarts Charon if
+// anything is out of date.
+func (s *Service) Check(ctx context.Context) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, state := s.cfg, s.state
+
+	ctx, span := tracer.Start(ctx, "check")
+	defer func() { endSpan(span, err) }()
+
+	// Check if .env and DB are in sync. In dns mode .env holds a hostname
+	// and is left alone. If .env holds a hostname in the other modes too,
+	// it is never rewritten; its DNS record is kept up to date instead.
+	var envIP, envHostname string
+	if cfg.updatesEnv() {
+		var err error
+		envIP, err = s.env.CurrentIP()
+		if err != nil {
+			slog.Warn("Could not get IP from .env", "error", err)
+		}
+		envIP = normalizeEnvIP(envIP)
+		if isHostname(envIP) {
+			envHostname, envIP = envIP, ""
+		}
+	}
+	if s.enr != nil && cfg.updatesEnv() && envHostname == "" {
+		envIP = s.advertisedIP(ctx, cfg, envIP)
+	}
+
+	fetchCtx, fetchSpan := tracer.Start(ctx, "fetch_ip")
+	fetched, err := s.fetcher.FetchIP(fetchCtx, envIP)
+	fetchSpan.SetAttributes(attribute.String("new_ip", fetched.IP), attribute.String("provider", fetched.Provider))
+	endSpan(fetchSpan, err)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errFetchIP, err)
+	}
+	currentIP := fetched.IP
+
+	_, querySpan := tracer.Start(ctx, "db.latest_ip")
+	storedIP, err := s.store.LatestIP()
+	if err == sql.ErrNoRows {
+		endSpan(querySpan, nil)
+	} else {
+		endSpan(querySpan, err)
+	}
+	span.SetAttributes(attribute.String("old_ip", storedIP), attribute.String("new_ip", currentIP))
+	if err == nil && storedIP != currentIP && cfg.withinChangeMask(storedIP, currentIP) {
+		slog.Info("IP changed within IP_CHANGE_MASK, keeping the stored IP", "ip", currentIP, "stored_ip", storedIP)
+		currentIP = storedIP
+	}
+	s.detectedIP.Store(currentIP)
+	state.flap.observe(currentIP, time.Now())
+
+	if err == sql.ErrNoRows {
+		slog.Info("No IP found in database, storing first IP", "ip", currentIP)
+	} else if err != nil {
+		return fmt.Errorf("failed to query database: %v", err)
+	} else {
+		slog.Debug("Current stored IP", "ip", storedIP)
+
+		if storedIP == currentIP {
+			state.confirm.reset()
+		} else if !state.confirm.observe(currentIP) {
+			slog.Info("Possible IP change, waiting for confirmation",
+				"old_ip", storedIP, "ip", currentIP, "readings", state.confirm.streak, "required", state.confirm.required)
+			return nil
+		}
+	}
+
+	ipChanged := storedIP != currentIP
+	envOutOfSync := envIP != "" && envIP != storedIP
+
+	if ipChanged && storedIP != "" && cfg.FlapThreshold > 0 {
+		if reverts := state.flap.reverts(time.Now().Add(-cfg.FlapWindow)); reverts > cfg.FlapThreshold {
+			slog.Warn("IP is flapping, not restarting until it settles",
+				"old_ip", storedIP, "ip", currentIP, "reverts", reverts, "window", cfg.FlapWindow.String())
+			return nil
+		}
+	}
+
+	if !envOutOfSync {
+		state.resyncedFrom = ""
+	} else if !ipChanged && envIP == state.resyncedFrom {
+		slog.Warn(".env still differs from the stored IP after a resync, not restarting again",
+			"ip", currentIP, "env_ip", envIP)
+		return nil
+	}
+
+	dnsStale := envHostname != "" && s.hostnameStale(ctx, envHostname, currentIP)
+
+	dnsRetry := s.dns != nil && state.dnsPending == currentIP
+
+	// Update if: no IP in DB, IP changed, .env is out of sync, the .env
+	// hostname does not resolve to the current IP or a DNS update is still
+	// outstanding
+	if !ipChanged && !envOutOfSync && !dnsStale && !dnsRetry {
+		slog.Debug("No IP change detected", "ip", currentIP)
+		return nil
+	}
+
+	if !s.lease.isLeader() {
+		slog.Info("Not the leader, leaving the update to the leader", "ip", currentIP, "stored_ip", storedIP)
+		return nil
+	}
+
+	if !ipChanged && !envOutOfSync && !dnsStale {
+		// .env and Charon already have the IP; only the DNS update that
+		// failed after them is retried.
+		if err := s.dns.Update(ctx, currentIP); err != nil {
+			return fmt.Errorf("failed to update DNS record: %w", err)
+		}
+		slog.Info("Successfully updated DNS record", "hostname", cfg.DDNSHostname, "ip", currentIP)
+		state.dnsPending = ""
+		return nil
+	}
+
+	// On a cold start with an empty database, .env may already hold the
+	// current IP; the database is then only seeded, without a restart.
+	seedOnly := storedIP == "" && envIP == currentIP
+	restarts := cfg.updatesEnv() && !seedOnly && envHostname == ""
+
+	if restarts && cfg.RestartEnabled && cfg.MinRestartInterval > 0 {
+		if wait, err := s.restartThrottle(cfg.MinRestartInterval); err != nil {
+			slog.Warn("Could not read the last restart time", "error", err)
+		} else if wait > 0 {
+			slog.Warn("Restart throttled, deferring the update", "ip", currentIP, "retry_in", wait.Round(time.Second).String())
+			if ipChanged {
+				state.confirm.hold(currentIP)
+			}
+			return nil
+		}
+	}
+
+	if ipChanged && s.reach != nil {
+		if err := s.reach.warnIfUnreachable(ctx, currentIP); err != nil && cfg.RequireReachable {
+			return fmt.Errorf("update aborted, REQUIRE_REACHABLE is set: %w", err)
+		}
+	}
+
+	if s.preHook != nil {
+		if err := s.preHook.Run(ctx, storedIP, currentIP); err != nil {
+			return fmt.Errorf("update aborted: %w", err)
+		}
+	}
+
+	if seedOnly {
+		slog.Info(".env already has the current IP, seeding the database without a restart", "ip", currentIP)
+	}
+
+	if restarts {
+		restarter := &auditedRestarter{Restarter: s.restarter, service: s, ip: currentIP}
+		if err := updateEnvFile(ctx, cfg, s.env, restarter, formatEnvIP(currentIP, cfg.IPv6Brackets)); err != nil {
+			s.notifier.notifyFailure(storedIP, currentIP, err)
+
+			var unhealthy *UnhealthyError
+			if errors.As(err, &unhealthy) {
+				return fmt.Errorf("restart succeeded but Charon is unhealthy: %w", err)
+			}
+			return fmt.Errorf("failed to update .env file: %w", err)
+		}
+	}
+
+	var dnsErr error
+	if (ipChanged || dnsStale) && s.dns != nil {
+		if dnsErr = s.dns.Update(ctx, currentIP); dnsErr != nil {
+			s.notifier.notifyFailure(storedIP, currentIP, dnsErr)
+			if !restarts {
+				return fmt.Errorf("failed to update DNS record: %w", dnsErr)
+			}
+			// Charon already restarted with the new IP, so it is stored
+			// below and later checks retry only the DNS update.
+			state.dnsPending = currentIP
+		} else {
+			slog.Info("Successfully updated DNS record", "hostname", cfg.DDNSHostname, "ip", currentIP)
+			state.dnsPending = ""
+			if envHostname != "" {
+				state.hostnameUpdatedTo = currentIP
+			}
+		}
+	}
+
+	if s.postHook != nil {
+		if err := s.postHook.Run(ctx, storedIP, currentIP); err != nil {
+			slog.Warn("Post-update hook failed", "error", err)
+		}
+	}
+
+	if !ipChanged && !envOutOfSync {
+		// Only the hostname's DNS record needed updating.
+		return nil
+	}
+	if !ipChanged {
+		slog.Info("Resynced .env with the stored IP, IP unchanged", "ip", currentIP, "env_ip", envIP)
+		s.recordEvent(eventTypeEnvResync, currentIP, nil)
+		state.resyncedFrom = envIP
+		return nil
+	}
+
+	_, insertSpan := tracer.Start(ctx, "db.insert", trace.WithAttributes(attribute.String("new_ip", currentIP)))
+	reason := reasonIPChange
+	if storedIP == "" {
+		reason = reasonColdStart
+	}
+	err = s.store.Insert(ipRecord{
+		IP:         currentIP,
+		PreviousIP: storedIP,
+		Reason:     reason,
+		Provider:   fetched.Provider,
+		LatencyMS:  fetched.Latency.Milliseconds(),
+	})
+	endSpan(insertSpan, err)
+	if err != nil {
+		return fmt.Errorf("failed to store IP in database: %v", err)
+	}
+	slog.Info("Successfully stored new IP in database", "ip", currentIP)
+	s.recordEvent(eventTypeIPChange, currentIP, nil)
+
+	if storedIP != "" {
+		ipChangesTotal.Inc()
+		s.notifier.notifyIPChange(storedIP, currentIP)
+	}
+
+	if dnsErr != nil {
+		return fmt.Errorf("failed to update DNS record, retrying without restarting: %w", dnsErr)
+	}
+	return nil
+}
+
+// hostnameStale reports whether host, the hostname in .env, needs its DNS
+// record updated to ip. A record just updated to ip is given time to
+// propagate rather than updated again.
+func (s *Service) hostnameStale(ctx context.Context, host, ip string) bool {
+	lookup := s.lookupIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupIP
+	}
+	network := "ip4"
+	if isIPv6(ip) {
+		network = "ip6"
+	}
+
+	addrs, err := lookup(ctx, network, host)
+	if err != nil {
+		slog.Warn("Could not resolve the .env hostname", "hostname", host, "error", err)
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.Equal(net.ParseIP(ip)) {
+			s.state.hostnameUpdatedTo = ""
+			return false
+		}
+	}
+
+	switch {
+	case s.dns == nil:
+		slog.Warn(".env holds a hostname that does not resolve to the current IP; set DDNS_PROVIDER to update its record",
+			"hostname", host, "ip", ip)
+		return false
+	case s.state.hostnameUpdatedTo == ip:
+		slog.Debug("Waiting for the DNS record update to propagate", "hostname", host, "ip", ip)
+		return false
+	}
+	slog.Info(".env hostname does not resolve to the current IP", "hostname", host, "ip", ip)
+	return true
+}
+
+// advertisedIP returns the IP Charon advertises in its ENR, which is what
+// its peers dial, or envIP if the ENR cannot be read.
+func (s *Service) advertisedIP(ctx context.Context, cfg *Config, envIP string) string {
+	ip, err := s.enr.advertisedIP(ctx, cfg.IPVersion == ipVersion6 || isIPv6(envIP))
+	if err != nil {
+		slog.Warn("Could not read the IP Charon advertises, comparing against .env", "error", err)
+		return envIP
+	}
+	if ip != envIP {
+		slog.Info("Charon advertises a different IP than .env", "advertised_ip", ip, "env_ip", envIP)
+	}
+	return ip
+}
+
+// restartThrottle returns how much longer a restart must wait to keep
+// MIN_RESTART_INTERVAL between restarts. The last restart is read from the
+// audit log, so the interval is kept across restarts of the updater.
+func (s *Service) restartThrottle(interval time.Duration) (time.Duration, error) {
+	last, err := s.store.LastEvent(eventTypeRestartSuccess)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return interval - time.Since(last.CreatedAt), nil
+}
+
+// recordEvent appends to the audit log. Failures are only logged, as the
+// audit log must never block an update.
+func (s *Service) recordEvent(kind, ip string, err error) {
+	event := eventRecord{Type: kind, IP: ip}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if err := s.store.RecordEvent(event); err != nil {
+		slog.Warn("Failed to record event", "type", kind, "error", err)
+	}
+}
+
+// auditedRestarter records the outcome of every restart, including those
+// performed while rolling back, in the audit log and as a trace span.
+type auditedRestarter struct {
+	Restarter
+	service *Service
+	ip      string
+}
+
+func (r *auditedRestarter) Restart(ctx context.Context) error {
+	ctx, span := tracer.Start(ctx, "restart", trace.WithAttributes(attribute.String("new_ip", r.ip)))
+	err := r.Restarter.Restart(ctx)
+	endSpan(span, err)
+	if err != nil {
+		r.service.recordEvent(eventTypeRestartFailure, r.ip, err)
+	} else {
+		r.service.recordEvent(eventTypeRestartSuccess, r.ip, nil)
+		r.service.recordRestart(time.Now())
+	}
+	return err
+}
+
+// recordRestart stores the time of a successful restart for /status and
+// the last restart gauge. Like recordEvent, failures are only logged.
+func (s *Service) recordRestart(at time.Time) {
+	lastRestartTimestamp.Set(float64(at.Unix()))
+	if err := s.store.SetMeta(metaLastRestart, at.UTC().Format(time.RFC3339)); err != nil {
+		slog.Warn("Failed to record the restart time", "error", err)
+	}
+}
+
+// uptime returns how long the updater has been running, or zero if the
+// start time is not known.
+func (s *Service) uptime() time.Duration {
+	if s.started.IsZero() {
+		return 0
+	}
+	return time.Since(s.started)
+}
+
+// errTooManyFailures is returned by Run when EXIT_ON_MAX_ERRORS is set and
+// MAX_CONSECUTIVE_ERRORS fetches in a row have failed.
+var errTooManyFailures = errors.New("too many consecutive failures to get the current IP")
+
+// errMaxRuntime is returned by Run once MAX_RUNTIME has elapsed, between
+// checks.
+var errMaxRuntime = errors.New("maximum runtime reached")
+
+// Run checks the IP every CheckInterval until ctx is cancelled, backing off
+// exponentially while the IP cannot be fetched. It only returns an error if
+// configured to give up after MaxConsecutiveErrors failures.
+func (s *Service) Run(ctx context.Context) error {
+	s.resume()
+
+	if startupJitter := s.config().StartupJitter; startupJitter > 0 {
+		delay := jitter(startupJitter, rand.Int64N)
+		slog.Info("Delaying first check", "delay", delay.String())
+		if !s.wait(ctx, delay) {
+			return nil
+		}
+	}
+
+	consecutiveErrors := 0
+
+	for {
+		err := s.Check(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.dropRechecks()
+		cfg := s.config()
+		s.checks.Add(1)
+		checksTotal.Inc()
+		lastCheckTimestamp.SetToCurrentTime()
+
+		var wait time.Duration
+		switch {
+		case errors.Is(err, errFetchIP):
+			consecutiveErrors++
+			consecutiveErrorsGauge.Set(float64(consecutiveErrors))
+			slog.Error("Error getting current IP", "attempt", consecutiveErrors, "error", err)
+
+			if consecutiveErrors >= cfg.MaxConsecutiveErrors {
+				if cfg.ExitOnMaxErrors {
+					return fmt.Errorf("%w: %d in a row", errTooManyFailures, consecutiveErrors)
+				}
+				if consecutiveErrors == cfg.MaxConsecutiveErrors {
+					slog.Warn("Multiple consecutive errors detected, continuing to back off")
+				}
+			}
+
+			wait = backoffDuration(consecutiveErrors, cfg.RetryInterval, cfg.MaxBackoff, rand.Int64N)
+			slog.Info("Retrying after backoff", "delay", wait.String())
+		case err != nil:
+			consecutiveErrors = 0 // Reset error counter on successful IP fetch
+			consecutiveErrorsGauge.Set(0)
+			slog.Error("Check failed", "error", err)
+			slog.Info("Retrying", "delay", cfg.RetryInterval.String())
+			wait = cfg.RetryInterval
+		default:
+			consecutiveErrors = 0
+			consecutiveErrorsGauge.Set(0)
+			if s.live != nil {
+				s.live.markSuccess()
+			}
+			s.systemd.markReady()
+			wait = cfg.CheckInterval + jitter(cfg.CheckIntervalJitter, rand.Int64N)
+			slog.Debug("Waiting before next check", "delay", wait.String())
+		}
+
+		if maxRuntime := cfg.MaxRuntime; maxRuntime > 0 {
+			left := maxRuntime - s.uptime()
+			if left <= 0 {
+				return errMaxRuntime
+			}
+			wait = min(wait, left)
+		}
+
+		s.nextCheck.Store(time.Now().Add(wait).UnixNano())
+		s.systemd.pingWatchdog()
+		if !s.wait(ctx, wait) {
+			return nil
+		}
+		if maxRuntime := s.config().MaxRuntime; maxRuntime > 0 && s.uptime() >= maxRuntime {
+			return errMaxRuntime
+		}
+	}
+}
+
+// resume logs the last stored IP at startup. It only reports it: checks
+// compare against the database anyway, and pending confirmations are not
+// persisted, so a differing reading after a restart still needs
+// CONFIRM_COUNT readings like any other change.
+func (s *Service) resume() {
+	ip, err := s.store.LatestIP()
+	switch {
+	case err == sql.ErrNoRows:
+		slog.Info("No stored IP yet, the first check will seed the database")
+	case err != nil:
+		slog.Warn("Could not read the last stored IP", "error", err)
+	default:
+		slog.Info("Last stored IP, checks compare against it", "ip", ip)
+	}
+}
+
+// wait waits for d to elapse or a recheck to be requested, whichever comes
+// first, returning false early if ctx is cancelled. The systemd watchdog is
+// kept alive meanwhile, so only a check that hangs trips it.
+func (s *Service) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	watchdog, stop := s.systemd.watchdogTicks()
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-watchdog:
+			s.systemd.pingWatchdog()
+		case sig := <-s.recheck:
+			slog.Info("Check requested", "signal", sig.String())
+			if s.reload != nil {
+				s.reloadConfig()
+			}
+			return true
+		}
+	}
+}
+
+// dropRechecks discards a recheck requested while a check was running, so
+// a burst of SIGHUPs results in one check rather than a queue of them. The
+// configuration is still reloaded, as the signal may have been sent to pick
+// up an edit made after the running check read it.
+func (s *Service) dropRechecks() {
+	select {
+	case sig := <-s.recheck:
+		slog.Info("Check requested while one was running, not checking again", "signal", sig.String())
+		if s.reload != nil {
+			s.reloadConfig()
+		}
+	default:
+	}
+}
+
+// config returns the active configuration, which a reload may replace.
+func (s *Service) config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// waitNotifications waits for the notifications of the active dispatcher,
+// which includes those a reload handed over to it, to be sent.
+func (s *Service) waitNotifications() {
+	s.mu.Lock()
+	notifier := s.notifier
+	s.mu.Unlock()
+	notifier.wait()
+}
+
+// status reports on the IP like the status subcommand, using the active
+// configuration.
+func (s *Service) status(ctx context.Context) (statusReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return collectStatus(ctx, s.cfg, s.fetcher, s.env, s.store)
+}
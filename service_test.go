@@ -0,0 +1,1072 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	ip  string
+	err error
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context) (string, error) {
+	return p.ip, p.err
+}
+
+type fakeRestarter struct {
+	calls int
+	err   error
+}
+
+func (r *fakeRestarter) Restart(ctx context.Context) error {
+	r.calls++
+	return r.err
+}
+
+// stuckEnvWriter always reports ip, as if writes to the env file were lost.
+type stuckEnvWriter struct {
+	ip string
+}
+
+func (w stuckEnvWriter) CurrentIP() (string, error) { return w.ip, nil }
+func (w stuckEnvWriter) SetIP(string) error         { return nil }
+func (w stuckEnvWriter) Rollback() error            { return nil }
+
+// checkHarness runs Service.Check against a temporary working directory holding
+// the .env file, with a fake provider and restarter.
+type checkHarness struct {
+	t         *testing.T
+	dir       string
+	store     *sqlStore
+	cfg       *Config
+	provider  *fakeProvider
+	restarter *fakeRestarter
+	state     *checkState
+}
+
+func newCheckHarness(t *testing.T, env string) *checkHarness {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if env != "" {
+		if err := os.WriteFile(filepath.Join(dir, defaultEnvFile), []byte(env), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &checkHarness{
+		t:     t,
+		dir:   dir,
+		store: &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows},
+		cfg: &Config{
+			IPVersion:      ipVersion4,
+			EnvFile:        filepath.Join(dir, defaultEnvFile),
+			EnvKeys:        []string{envKey},
+			EnvBackupDir:   filepath.Join(dir, "backups"),
+			RestartEnabled: true,
+		},
+		provider:  &fakeProvider{},
+		restarter: &fakeRestarter{},
+		state:     newCheckState(1),
+	}
+}
+
+func (h *checkHarness) run(ip string) error {
+	h.t.Helper()
+
+	h.provider.ip = ip
+	return h.service().Check(context.Background())
+}
+
+// service wires the harness fakes into a Service.
+func (h *checkHarness) service() *Service {
+	return &Service{
+		cfg:       h.cfg,
+		fetcher:   &providerSet{IPv4: []IPProvider{h.provider}, version: ipVersion4},
+		store:     h.store,
+		env:       newFileEnvWriter(h.cfg),
+		restarter: h.restarter,
+		state:     h.state,
+	}
+}
+
+func (h *checkHarness) history() []string {
+	h.t.Helper()
+	records, err := h.store.History(maxHistoryLimit)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	var ips []string
+	for _, record := range records {
+		ips = append(ips, record.IP)
+	}
+	return ips
+}
+
+func (h *checkHarness) envContent() string {
+	h.t.Helper()
+	content, err := os.ReadFile(h.cfg.EnvFile)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestCheckEnvResyncDoesNotInsertDuplicate(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=9.9.9.9\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env not resynced, got %q", got)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("expected no new row for an env-only resync, history = %v", got)
+	}
+}
+
+func TestCheckIPChangeInsertsRow(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if got := h.history(); len(got) != 2 || got[0] != "2.2.2.2" {
+		t.Errorf("expected the new IP to be stored, history = %v", got)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+}
+
+func TestCheckRecordsReason(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	records, err := h.store.History(maxHistoryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("history = %+v, want 2 rows", records)
+	}
+	if got := records[1]; got.Reason != reasonColdStart || got.PreviousIP != "" {
+		t.Errorf("first row = %+v, want a cold start", got)
+	}
+	if got := records[0]; got.Reason != reasonIPChange || got.PreviousIP != "1.1.1.1" {
+		t.Errorf("second row = %+v, want an IP change from 1.1.1.1", got)
+	}
+}
+
+func TestCheckColdStartWithCurrentEnv(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 when .env already has the IP", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 || got[0] != "1.1.1.1" {
+		t.Errorf("expected the database to be seeded, history = %v", got)
+	}
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env changed to %q", got)
+	}
+}
+
+func TestCheckColdStartWithStaleEnv(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=9.9.9.9\n")
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env not updated, got %q", got)
+	}
+}
+
+func TestCheckAfterUpdaterRestart(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	dbPath := filepath.Join(h.dir, "ip_store.db")
+	db, err := initDB(dbPath, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.store = &sqlStore{dialect: sqliteDialect, db: db, maxRows: defaultHistoryRows}
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	db.Close()
+
+	// Simulate a new process: a fresh database handle and empty state.
+	db, err = initDB(dbPath, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	h.store = &sqlStore{dialect: sqliteDialect, db: db, maxRows: defaultHistoryRows}
+	h.state = newCheckState(2)
+
+	h.provider.err = errors.New("unreachable")
+	if err := h.run("1.1.1.1"); !errors.Is(err, errFetchIP) {
+		t.Fatalf("Check error = %v, want errFetchIP", err)
+	}
+	h.provider.err = nil
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 after restarting the updater", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 || got[0] != "1.1.1.1" {
+		t.Errorf("history = %v, want only the confirmed IP", got)
+	}
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env changed to %q", got)
+	}
+}
+
+func TestCheckThrottlesRestarts(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	h.cfg.MinRestartInterval = time.Hour
+	h.state = newCheckState(2)
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.RecordEvent(eventRecord{Type: eventTypeRestartSuccess, IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := h.run("2.2.2.2"); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+	if h.restarter.calls != 0 {
+		t.Fatalf("restart calls = %d, want 0 within MIN_RESTART_INTERVAL", h.restarter.calls)
+	}
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Fatalf(".env changed to %q while throttled", got)
+	}
+
+	// Once the interval is up the deferred change is applied at once,
+	// without having to be confirmed again.
+	h.cfg.MinRestartInterval = time.Nanosecond
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 2 || got[0] != "2.2.2.2" {
+		t.Errorf("history = %v, want the new IP stored", got)
+	}
+}
+
+func TestCheckIgnoresChangeWithinMask(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=203.0.113.10\n")
+	h.cfg.IPChangeMask = "/24"
+	if err := h.store.Insert(ipRecord{IP: "203.0.113.10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("203.0.113.99"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 for a change within the mask", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("history = %v, want only the stored IP", got)
+	}
+
+	if err := h.run("198.51.100.7"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1 for a change outside the mask", h.restarter.calls)
+	}
+}
+
+func TestCheckFlappingIPDoesNotRestart(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	h.cfg.FlapThreshold = 2
+	h.cfg.FlapWindow = time.Hour
+	h.state = newCheckState(2)
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A provider intermittently reporting its own address.
+	for _, ip := range []string{"9.9.9.9", "1.1.1.1", "9.9.9.9", "1.1.1.1", "9.9.9.9", "1.1.1.1", "9.9.9.9", "9.9.9.9"} {
+		if err := h.run(ip); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 while the IP is flapping", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("history = %v, want only the stored IP", got)
+	}
+}
+
+func TestCheckFollowerDoesNotUpdate(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := h.service()
+	svc.lease = &leaderLease{holder: "follower"}
+	h.provider.ip = "2.2.2.2"
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 for a follower", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("history = %v, want the follower to leave it alone", got)
+	}
+}
+
+func TestCheckRequireReachable(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+		if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+			t.Fatal(err)
+		}
+		h.cfg.RequireReachable = strict
+
+		svc := h.service()
+		svc.reach = &reachabilityProbe{
+			port:      defaultP2PPort,
+			dial:      func(context.Context, string, string) (net.Conn, error) { return nil, errors.New("connection refused") },
+			localAddr: func() (net.IP, error) { return net.ParseIP("192.168.1.10"), nil },
+		}
+		h.provider.ip = "2.2.2.2"
+		err := svc.Check(context.Background())
+
+		if strict {
+			if err == nil || h.restarter.calls != 0 {
+				t.Errorf("strict: err = %v, restart calls = %d; want the update aborted", err, h.restarter.calls)
+			}
+			continue
+		}
+		if err != nil || h.restarter.calls != 1 {
+			t.Errorf("advisory: err = %v, restart calls = %d; want the update applied", err, h.restarter.calls)
+		}
+	}
+}
+
+func TestCheckRecordsLastRestart(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.restarter.err = errors.New("boom")
+	if err := h.run("2.2.2.2"); err == nil {
+		t.Fatal("Check succeeded with a failing restart")
+	}
+	if _, err := lastRestart(h.store); err != sql.ErrNoRows {
+		t.Fatalf("lastRestart after a failed restart: err = %v, want sql.ErrNoRows", err)
+	}
+
+	h.restarter.err = nil
+	before := time.Now().Truncate(time.Second)
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	restarted, err := lastRestart(h.store)
+	if err != nil || restarted.Before(before) {
+		t.Errorf("lastRestart = %v, %v; want the time of the restart", restarted, err)
+	}
+}
+
+func TestCheckComparesAgainstCharonENR(t *testing.T) {
+	advertised := "1.1.1.1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testENR("ip", string(net.ParseIP(advertised).To4()))))
+	}))
+	defer srv.Close()
+
+	h := newCheckHarness(t, envKey+"=2.2.2.2\n")
+	if err := h.store.Insert(ipRecord{IP: "2.2.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+	svc := h.service()
+	svc.enr = newCharonENR(srv.URL)
+	h.provider.ip = "2.2.2.2"
+
+	// .env is up to date, but Charon still advertises the old IP.
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if h.restarter.calls != 1 {
+		t.Fatalf("restart calls = %d, want Charon restarted to advertise the current IP", h.restarter.calls)
+	}
+
+	advertised = "2.2.2.2"
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	// An unreachable API falls back to comparing against .env.
+	svc.enr.url = "http://127.0.0.1:1/enr"
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want none once Charon advertises the current IP", h.restarter.calls)
+	}
+}
+
+func TestCheckRestartDisabled(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	h.cfg.RestartEnabled = false
+	h.cfg.MinRestartInterval = time.Hour
+
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0 with restarts disabled", h.restarter.calls)
+	}
+	if got := h.envContent(); got != envKey+"=2.2.2.2\n" {
+		t.Errorf(".env = %q, want the new IP written", got)
+	}
+	if got := h.history(); len(got) != 2 || got[0] != "2.2.2.2" {
+		t.Errorf("history = %v, want the new IP stored", got)
+	}
+}
+
+func TestCheckNoChange(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("history = %v, want a single row", got)
+	}
+}
+
+func TestCheckQuotedEnvValueDoesNotRestart(t *testing.T) {
+	for _, line := range []string{
+		envKey + "=\"1.1.1.1\"\n",
+		envKey + "=' 1.1.1.1 '\n",
+	} {
+		h := newCheckHarness(t, line)
+		if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if err := h.run("1.1.1.1"); err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+		}
+
+		if h.restarter.calls != 0 {
+			t.Errorf("%q: restart calls = %d, want 0", line, h.restarter.calls)
+		}
+	}
+}
+
+func TestCheckPersistentDivergenceRestartsOnce(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=9.9.9.9\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write never takes, so the divergence survives the resync.
+	svc := h.service()
+	svc.env = stuckEnvWriter{ip: "9.9.9.9"}
+	h.provider.ip = "1.1.1.1"
+
+	for i := 0; i < 3; i++ {
+		if err := svc.Check(context.Background()); err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+	}
+
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+}
+
+func TestCheckDryRunChangesNothing(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.provider.ip = "2.2.2.2"
+	svc := h.service()
+	svc.store = dryRunStore{svc.store}
+	svc.env = &dryRunEnvWriter{path: h.cfg.EnvFile, keys: []string{envKey}}
+	svc.restarter = dryRunRestarter{svc.restarter}
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env modified in dry run, got %q", got)
+	}
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 1 {
+		t.Errorf("history = %v, want only the original IP", got)
+	}
+}
+
+type fakeDNSUpdater struct {
+	ips []string
+	err error
+}
+
+func (u *fakeDNSUpdater) Update(ctx context.Context, ip string) error {
+	u.ips = append(u.ips, ip)
+	return u.err
+}
+
+func TestCheckDNSModeLeavesEnvAlone(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=node.example.com\n")
+	h.cfg.UpdateMode = updateModeDNS
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dns := &fakeDNSUpdater{}
+	svc := h.service()
+	svc.dns = dns
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		h.provider.ip = ip
+		if err := svc.Check(context.Background()); err != nil {
+			t.Fatalf("Check(%s): %v", ip, err)
+		}
+	}
+
+	if len(dns.ips) != 1 || dns.ips[0] != "2.2.2.2" {
+		t.Errorf("DNS updates = %v, want [2.2.2.2]", dns.ips)
+	}
+	if got := h.envContent(); got != envKey+"=node.example.com\n" {
+		t.Errorf(".env modified in dns mode, got %q", got)
+	}
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0", h.restarter.calls)
+	}
+}
+
+func TestCheckDNSFailureAfterRestartDoesNotRestartAgain(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	h.cfg.UpdateMode = updateModeBoth
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dns := &fakeDNSUpdater{err: errors.New("DNS API unavailable")}
+	svc := h.service()
+	svc.dns = dns
+	h.provider.ip = "2.2.2.2"
+	for i := 0; i < 3; i++ {
+		if err := svc.Check(context.Background()); err == nil {
+			t.Fatalf("Check %d succeeded with the DNS API down", i)
+		}
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 2 || got[0] != "2.2.2.2" {
+		t.Errorf("history = %v, want the new IP stored after the restart", got)
+	}
+
+	dns.err = nil
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check after the DNS API recovered: %v", err)
+	}
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(dns.ips) != 4 {
+		t.Errorf("DNS updates = %v, want 3 failures and 1 success", dns.ips)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d after the DNS retries, want 1", h.restarter.calls)
+	}
+}
+
+func TestCheckHostnameInEnvUpdatesDNS(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=node.example.com\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := "1.1.1.1"
+	dns := &fakeDNSUpdater{}
+	svc := h.service()
+	svc.dns = dns
+	svc.lookupIP = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if host != "node.example.com" || network != "ip4" {
+			t.Errorf("lookup(%s, %s)", network, host)
+		}
+		return []net.IP{net.ParseIP(resolved)}, nil
+	}
+
+	// The record still points at the old IP while the update propagates,
+	// which must not cause a second update; once it resolves, a record
+	// changed behind the updater's back is corrected again.
+	for _, step := range []struct{ ip, resolved string }{
+		{"1.1.1.1", "1.1.1.1"},
+		{"2.2.2.2", "1.1.1.1"},
+		{"2.2.2.2", "1.1.1.1"},
+		{"2.2.2.2", "2.2.2.2"},
+		{"2.2.2.2", "3.3.3.3"},
+	} {
+		h.provider.ip, resolved = step.ip, step.resolved
+		if err := svc.Check(context.Background()); err != nil {
+			t.Fatalf("Check(%s): %v", step.ip, err)
+		}
+	}
+
+	if len(dns.ips) != 2 || dns.ips[0] != "2.2.2.2" || dns.ips[1] != "2.2.2.2" {
+		t.Errorf("DNS updates = %v, want [2.2.2.2 2.2.2.2]", dns.ips)
+	}
+	if got := h.envContent(); got != envKey+"=node.example.com\n" {
+		t.Errorf(".env hostname overwritten, got %q", got)
+	}
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0", h.restarter.calls)
+	}
+	if got := h.history(); len(got) != 2 || got[0] != "2.2.2.2" {
+		t.Errorf("history = %v, want the change stored", got)
+	}
+}
+
+// recorder collects the calls made on the in-memory fakes below, in order.
+type recorder struct {
+	calls []string
+}
+
+type fakeFetcher struct{ ip string }
+
+func (f fakeFetcher) FetchIP(ctx context.Context, envIP string) (fetchResult, error) {
+	return fetchResult{IP: f.ip}, nil
+}
+
+type memoryStore struct {
+	rec  *recorder
+	ips  []string
+	meta map[string]string
+}
+
+func (s *memoryStore) LatestIP() (string, error) {
+	if len(s.ips) == 0 {
+		return "", sql.ErrNoRows
+	}
+	return s.ips[len(s.ips)-1], nil
+}
+
+func (s *memoryStore) Insert(record ipRecord) error {
+	s.rec.calls = append(s.rec.calls, "store "+record.IP)
+	s.ips = append(s.ips, record.IP)
+	return nil
+}
+
+func (s *memoryStore) History(limit int) ([]ipRecord, error) {
+	return nil, nil
+}
+
+func (s *memoryStore) RecordEvent(event eventRecord) error {
+	return nil
+}
+
+func (s *memoryStore) Events(limit int) ([]eventRecord, error) {
+	return nil, nil
+}
+
+func (s *memoryStore) LastEvent(kind string) (eventRecord, error) {
+	return eventRecord{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) SetMeta(key, value string) error {
+	if s.meta == nil {
+		s.meta = map[string]string{}
+	}
+	s.meta[key] = value
+	return nil
+}
+
+func (s *memoryStore) Meta(key string) (string, error) {
+	value, ok := s.meta[key]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return value, nil
+}
+
+type memoryEnvWriter struct {
+	rec *recorder
+	ip  string
+}
+
+func (w *memoryEnvWriter) CurrentIP() (string, error) { return w.ip, nil }
+
+func (w *memoryEnvWriter) SetIP(ip string) error {
+	w.rec.calls = append(w.rec.calls, "write "+ip)
+	w.ip = ip
+	return nil
+}
+
+func (w *memoryEnvWriter) Rollback() error { return nil }
+
+type recordingRestarter struct{ rec *recorder }
+
+func (r recordingRestarter) Restart(ctx context.Context) error {
+	r.rec.calls = append(r.rec.calls, "restart")
+	return nil
+}
+
+func TestServiceCheckOrder(t *testing.T) {
+	rec := &recorder{}
+	svc := &Service{
+		cfg:       &Config{RestartEnabled: true},
+		fetcher:   fakeFetcher{ip: "2.2.2.2"},
+		store:     &memoryStore{rec: rec, ips: []string{"1.1.1.1"}},
+		env:       &memoryEnvWriter{rec: rec, ip: "1.1.1.1"},
+		restarter: recordingRestarter{rec},
+		state:     newCheckState(1),
+	}
+
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	want := []string{"write 2.2.2.2", "restart", "store 2.2.2.2"}
+	if len(rec.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", rec.calls, want)
+	}
+	for i := range want {
+		if rec.calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", rec.calls, want)
+		}
+	}
+
+	rec.calls = nil
+	if err := svc.Check(context.Background()); err != nil {
+		t.Fatalf("second Check: %v", err)
+	}
+	if len(rec.calls) != 0 {
+		t.Errorf("unchanged IP caused calls %v", rec.calls)
+	}
+}
+
+func TestCheckFailingPreHookAbortsUpdate(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.provider.ip = "2.2.2.2"
+	svc := h.service()
+	svc.preHook = newScriptHook("pre-update", writeScript(t, "exit 1\n"))
+	if err := svc.Check(context.Background()); err == nil {
+		t.Fatal("expected the failing pre-update hook to abort the check")
+	}
+
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env modified despite failing hook, got %q", got)
+	}
+	if h.restarter.calls != 0 {
+		t.Errorf("restart calls = %d, want 0", h.restarter.calls)
+	}
+}
+
+func TestCheckCreatesMissingEnvFile(t *testing.T) {
+	h := newCheckHarness(t, "")
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	if got := h.envContent(); got != envKey+"=1.1.1.1\n" {
+		t.Errorf(".env = %q, want it created with the new IP", got)
+	}
+	if h.restarter.calls != 1 {
+		t.Errorf("restart calls = %d, want 1", h.restarter.calls)
+	}
+}
+
+func TestCheckRecordsEvents(t *testing.T) {
+	h := newCheckHarness(t, envKey+"=9.9.9.9\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("1.1.1.1"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	h.restarter.err = errors.New("boom")
+	if err := h.run("2.2.2.2"); err == nil {
+		t.Fatal("expected the failing restart to fail the check")
+	}
+	h.restarter.err = nil
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	events, err := h.store.Events(maxHistoryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Type+" "+event.IP)
+	}
+
+	// Newest first.
+	want := []string{
+		"ip_change 2.2.2.2",
+		"restart_success 2.2.2.2",
+		"restart_failure 2.2.2.2",
+		"env_resync 1.1.1.1",
+		"restart_success 1.1.1.1",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if events[2].Error == "" {
+		t.Error("restart_failure event has no error message")
+	}
+}
+
+type failingFetcher struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *failingFetcher) FetchIP(ctx context.Context, envIP string) (fetchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return fetchResult{}, errors.New("unreachable")
+}
+
+func (f *failingFetcher) checks() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestRunExitsAfterMaxConsecutiveErrors(t *testing.T) {
+	fetcher := &failingFetcher{}
+	rec := &recorder{}
+	svc := &Service{
+		cfg: &Config{
+			RetryInterval:        time.Millisecond,
+			MaxBackoff:           time.Millisecond,
+			MaxConsecutiveErrors: 3,
+			ExitOnMaxErrors:      true,
+		},
+		fetcher: fetcher,
+		store:   &memoryStore{rec: rec},
+		env:     &memoryEnvWriter{rec: rec},
+		state:   newCheckState(1),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := svc.Run(ctx); !errors.Is(err, errTooManyFailures) {
+		t.Fatalf("Run error = %v, want errTooManyFailures", err)
+	}
+	if fetcher.calls != 3 {
+		t.Errorf("fetch attempts = %d, want 3", fetcher.calls)
+	}
+}
+
+// signalingFetcher fails every fetch, requesting a recheck during the
+// first as if SIGHUP arrived mid-check.
+type signalingFetcher struct {
+	failingFetcher
+	recheck chan os.Signal
+}
+
+func (f *signalingFetcher) FetchIP(ctx context.Context, envIP string) (fetchResult, error) {
+	if f.checks() == 0 {
+		f.recheck <- syscall.SIGHUP
+	}
+	return f.failingFetcher.FetchIP(ctx, envIP)
+}
+
+func TestRunDropsRecheckDuringCheck(t *testing.T) {
+	recheck := make(chan os.Signal, 1)
+	fetcher := &signalingFetcher{recheck: recheck}
+	reloads := 0
+	svc := &Service{
+		cfg:     &Config{RetryInterval: time.Hour, MaxBackoff: time.Hour, MaxConsecutiveErrors: 100},
+		fetcher: fetcher,
+		store:   &memoryStore{rec: &recorder{}},
+		env:     &memoryEnvWriter{rec: &recorder{}},
+		state:   newCheckState(1),
+		recheck: recheck,
+		reload: func() (*Config, error) {
+			reloads++
+			return nil, errors.New("unchanged")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.Run(ctx)
+		close(done)
+	}()
+
+	for svc.checks.Load() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if fetcher.checks() != 1 {
+		t.Errorf("checks = %d, want the signal received mid-check dropped", fetcher.checks())
+	}
+	if reloads != 1 {
+		t.Errorf("reloads = %d, want the configuration still reloaded", reloads)
+	}
+}
+
+func TestRunExitsAfterMaxRuntime(t *testing.T) {
+	fetcher := &failingFetcher{}
+	svc := &Service{
+		cfg: &Config{
+			RetryInterval:        time.Hour,
+			MaxBackoff:           time.Hour,
+			MaxConsecutiveErrors: 100,
+			MaxRuntime:           50 * time.Millisecond,
+		},
+		fetcher: fetcher,
+		store:   &memoryStore{rec: &recorder{}},
+		env:     &memoryEnvWriter{rec: &recorder{}},
+		state:   newCheckState(1),
+		started: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := svc.Run(ctx); !errors.Is(err, errMaxRuntime) {
+		t.Fatalf("Run error = %v, want errMaxRuntime", err)
+	}
+	if ctx.Err() != nil {
+		t.Error("Run waited out the retry interval instead of MAX_RUNTIME")
+	}
+	if fetcher.calls != 1 {
+		t.Errorf("fetch attempts = %d, want no check after MAX_RUNTIME", fetcher.calls)
+	}
+}
+
+func TestRunRechecksOnSignal(t *testing.T) {
+	fetcher := &failingFetcher{}
+	recheck := make(chan os.Signal, 1)
+	svc := &Service{
+		cfg:     &Config{RetryInterval: time.Hour, MaxBackoff: time.Hour, MaxConsecutiveErrors: 100},
+		fetcher: fetcher,
+		store:   &memoryStore{rec: &recorder{}},
+		env:     &memoryEnvWriter{rec: &recorder{}},
+		state:   newCheckState(1),
+		recheck: recheck,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		svc.Run(ctx)
+		close(done)
+	}()
+
+	// A signal sent during the first check would be dropped.
+	for svc.checks.Load() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Without the signal the second check would be an hour away.
+	recheck <- syscall.SIGHUP
+	deadline := time.After(5 * time.Second)
+	for fetcher.checks() < 2 {
+		select {
+		case <-deadline:
+			t.Fatal("signal did not trigger a check")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setupConfig is the subset of Config the setup wizard asks about, written
+// as YAML with the same keys. Unset fields are left out so the defaults
+// apply.
+type setupConfig struct {
+	RestartBackend   string   `yaml:"restart_backend"`
+	ComposeServices  []string `yaml:"compose_services,omitempty"`
+	RestartContainer string   `yaml:"restart_container,omitempty"`
+	EnvFile          string   `yaml:"env_file"`
+	CheckInterval    string   `yaml:"check_interval"`
+	NotifyWebhookURL string   `yaml:"notify_webhook_url,omitempty"`
+	NotifyType       string   `yaml:"notify_type,omitempty"`
+}
+
+// setupWizard asks for the settings new users most often need, one prompt
+// at a time, re-asking until each answer is valid.
+type setupWizard struct {
+	in  *bufio.Scanner
+	out io.Writer
+	// lookPath finds the container runtimes on PATH, as exec.LookPath.
+	lookPath func(string) (string, error)
+}
+
+// ask prompts for a value, returning def for an empty answer. check, if
+// non-nil, rejects invalid answers, which are asked for again.
+func (w *setupWizard) ask(prompt, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", prompt)
+		}
+		if !w.in.Scan() {
+			if err := w.in.Err(); err != nil {
+				return "", err
+			}
+			return "", io.ErrUnexpectedEOF
+		}
+
+		answer := strings.TrimSpace(w.in.Text())
+		if answer == "" {
+			answer = def
+		}
+		if check == nil {
+			return answer, nil
+		}
+		if err := check(answer); err != nil {
+			fmt.Fprintf(w.out, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// detectBackend picks the restart backend matching the container runtime
+// found on PATH, preferring Docker Compose.
+func (w *setupWizard) detectBackend() string {
+	for _, runtime := range []struct{ binary, backend string }{
+		{"docker", backendCompose},
+		{"podman", backendPodman},
+	} {
+		if path, err := w.lookPath(runtime.binary); err == nil {
+			fmt.Fprintf(w.out, "Found %s at %s\n", runtime.binary, path)
+			return runtime.backend
+		}
+	}
+	fmt.Fprintln(w.out, "Neither docker nor podman was found on PATH")
+	return backendCompose
+}
+
+func (w *setupWizard) run() (*setupConfig, error) {
+	var (
+		cfg setupConfig
+		err error
+	)
+
+	cfg.RestartBackend, err = w.ask("Restart backend (compose, docker or podman)", w.detectBackend(), func(s string) error {
+		switch s {
+		case backendCompose, backendDocker, backendPodman:
+			return nil
+		}
+		return fmt.Errorf("must be compose, docker or podman")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.RestartBackend == backendCompose {
+		service, err := w.ask("Compose service running Charon", defaultComposeService, nonEmpty)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ComposeServices = []string{service}
+	} else {
+		if cfg.RestartContainer, err = w.ask("Container running Charon", defaultRestartContainer, nonEmpty); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.EnvFile, err = w.ask("Path of Charon's .env file", defaultEnvFile, checkEnvFile); err != nil {
+		return nil, err
+	}
+
+	cfg.CheckInterval, err = w.ask("Check interval", defaultCheckInterval.String(), func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		if err != nil {
+			return fmt.Errorf("not a duration such as 30s or 5m: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.NotifyWebhookURL, err = w.ask("Webhook URL to notify of IP changes (empty for none)", "", func(s string) error {
+		if s == "" {
+			return nil
+		}
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("must be an http or https URL")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.NotifyWebhookURL != "" {
+		cfg.NotifyType, err = w.ask("Webhook type (generic, slack or discord)", notifyGeneric, func(s string) error {
+			switch s {
+			case notifyGeneric, notifySlack, notifyDiscord:
+				return nil
+			}
+			return fmt.Errorf("must be generic, slack or discord")
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+func nonEmpty(s string) error {
+	if s == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	return nil
+}
+
+// runSetup implements the setup subcommand, writing the answers to a YAML
+// config file. An existing file is only replaced with --force.
+func runSetup(in io.Reader, out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	output := fs.String("output", "config.yaml", "config file to write")
+	force := fs.Bool("force", false, "overwrite the config file if it exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		return fmt.Errorf("%s already exists, pass --force to overwrite it", *output)
+	}
+
+	wizard := &setupWizard{in: bufio.NewScanner(in), out: out, lookPath: exec.LookPath}
+	answers, err := wizard.run()
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*output, content, 0644); err != nil {
+		return err
+	}
+
+	// Read the file back as the updater will, so a mistake shows up now
+	// rather than at the next start.
+	cfg := defaultConfig()
+	if err := loadConfigFile(*output, cfg); err != nil {
+		return err
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("%s was written but is invalid: %v", *output, err)
+	}
+
+	fmt.Fprintf(out, "\nWrote %s. Start the updater with --config %s\n", *output, *output)
+	return nil
+}
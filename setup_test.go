@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetupWizard(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, ".env")
+	// Invalid answers are asked for again.
+	answers := strings.Join([]string{
+		"", // restart backend: the detected podman
+		"", // container: charon
+		"/no/such/dir/.env",
+		envPath,
+		"soon",
+		"1m",
+		"ftp://example.com",
+		"https://hooks.example.com/ip",
+		"slack",
+	}, "\n") + "\n"
+
+	var out bytes.Buffer
+	wizard := &setupWizard{
+		in:  bufio.NewScanner(strings.NewReader(answers)),
+		out: &out,
+		lookPath: func(binary string) (string, error) {
+			if binary == "podman" {
+				return "/usr/bin/podman", nil
+			}
+			return "", errors.New("not found")
+		},
+	}
+	got, err := wizard.run()
+	if err != nil {
+		t.Fatalf("run: %v\n%s", err, out.String())
+	}
+
+	want := setupConfig{
+		RestartBackend:   backendPodman,
+		RestartContainer: defaultRestartContainer,
+		EnvFile:          envPath,
+		CheckInterval:    "1m",
+		NotifyWebhookURL: "https://hooks.example.com/ip",
+		NotifyType:       notifySlack,
+	}
+	if got.RestartBackend != want.RestartBackend || got.RestartContainer != want.RestartContainer ||
+		got.EnvFile != want.EnvFile || got.CheckInterval != want.CheckInterval ||
+		got.NotifyWebhookURL != want.NotifyWebhookURL || got.NotifyType != want.NotifyType || got.ComposeServices != nil {
+		t.Errorf("answers = %+v, want %+v", *got, want)
+	}
+	if n := strings.Count(out.String(), ":   "); n != 3 {
+		t.Errorf("%d answers rejected, want 3:\n%s", n, out.String())
+	}
+}
+
+func TestRunSetupWritesLoadableConfig(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "config.yaml")
+	answers := "compose\ncharon-1\n" + filepath.Join(dir, ".env") + "\n30s\n\n"
+
+	var out bytes.Buffer
+	if err := runSetup(strings.NewReader(answers), &out, []string{"--output", output}); err != nil {
+		t.Fatalf("runSetup: %v\n%s", err, out.String())
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfigFile(output, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RestartBackend != backendCompose || len(cfg.ComposeServices) != 1 || cfg.ComposeServices[0] != "charon-1" ||
+		cfg.CheckInterval != 30*time.Second || cfg.NotifyWebhookURL != "" {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	if err := runSetup(strings.NewReader(answers), &out, []string{"--output", output}); err == nil {
+		t.Error("expected an error overwriting without --force")
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// statusReport is the state shown by the status subcommand.
+type statusReport struct {
+	DetectedIP string
+	EnvIP      string
+	StoredIP   string
+	// EnvChecked is false in dns update mode, where .env holds a hostname
+	// and takes no part in the verdict.
+	EnvChecked bool
+}
+
+func (r statusReport) synced() bool {
+	if r.DetectedIP == "" || r.DetectedIP != r.StoredIP {
+		return false
+	}
+	return !r.EnvChecked || r.EnvIP == r.DetectedIP
+}
+
+// collectStatus fetches the current IP once and reads .env and the latest
+// stored IP, without modifying anything. store may be nil when there is no
+// database yet.
+func collectStatus(ctx context.Context, cfg *Config, fetcher IPFetcher, env EnvWriter, store Store) (statusReport, error) {
+	report := statusReport{EnvChecked: cfg.updatesEnv()}
+
+	envIP, err := env.CurrentIP()
+	if err == nil {
+		report.EnvIP = envIP
+		if report.EnvChecked {
+			report.EnvIP = normalizeEnvIP(envIP)
+		}
+	}
+
+	if store != nil {
+		storedIP, err := store.LatestIP()
+		if err != nil && err != sql.ErrNoRows {
+			return report, fmt.Errorf("failed to query database: %v", err)
+		}
+		report.StoredIP = storedIP
+	}
+
+	detected, err := fetcher.FetchIP(ctx, report.EnvIP)
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", errFetchIP, err)
+	}
+	report.DetectedIP = detected.IP
+
+	return report, nil
+}
+
+func printStatus(w io.Writer, report statusReport) error {
+	orNone := func(s string) string {
+		if s == "" {
+			return "(none)"
+		}
+		return s
+	}
+	verdict := "no"
+	if report.synced() {
+		verdict = "yes"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "detected IP\t%s\n", orNone(report.DetectedIP))
+	fmt.Fprintf(tw, ".env\t%s\n", orNone(report.EnvIP))
+	fmt.Fprintf(tw, "stored IP\t%s\n", orNone(report.StoredIP))
+	fmt.Fprintf(tw, "synced\t%s\n", verdict)
+	return tw.Flush()
+}
+
+// runStatus implements the status subcommand. It skips the instance lock,
+// and does not create the SQLite database if it does not exist yet.
+func runStatus(ctx context.Context, cfg *Config) error {
+	var store Store
+	_, statErr := os.Stat(cfg.DBPath)
+	if cfg.DBDriver != driverSQLite || statErr == nil {
+		sqlStore, db, err := openStoreReadOnly(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		store = sqlStore
+	}
+
+	providers, err := newProviderSet(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := collectStatus(ctx, cfg, providers, newFileEnvWriter(cfg), store)
+	if err != nil {
+		return err
+	}
+	return printStatus(os.Stdout, report)
+}
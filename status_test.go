@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCollectStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		stored     []string
+		mode       string
+		wantSynced bool
+	}{
+		{"in sync", `"1.1.1.1"`, []string{"1.1.1.1"}, updateModeEnv, true},
+		{"env stale", "9.9.9.9", []string{"1.1.1.1"}, updateModeEnv, false},
+		{"database stale", "1.1.1.1", []string{"9.9.9.9"}, updateModeEnv, false},
+		{"empty database", "1.1.1.1", nil, updateModeEnv, false},
+		{"dns mode ignores env", "node.example.com", []string{"1.1.1.1"}, updateModeDNS, true},
+	}
+
+	for _, tt := range tests {
+		rec := &recorder{}
+		store := &memoryStore{rec: rec, ips: tt.stored}
+		env := &memoryEnvWriter{rec: rec, ip: tt.env}
+		cfg := &Config{UpdateMode: tt.mode}
+
+		report, err := collectStatus(context.Background(), cfg, fakeFetcher{ip: "1.1.1.1"}, env, store)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if report.synced() != tt.wantSynced {
+			t.Errorf("%s: synced = %v, want %v (%+v)", tt.name, report.synced(), tt.wantSynced, report)
+		}
+		if len(rec.calls) != 0 {
+			t.Errorf("%s: status modified state: %v", tt.name, rec.calls)
+		}
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	var buf bytes.Buffer
+	report := statusReport{DetectedIP: "1.1.1.1", EnvIP: "1.1.1.1", EnvChecked: true}
+	if err := printStatus(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"detected IP", "stored IP", "(none)", "synced", "no"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultDBPath = "ip_store.db"
+
+	driverSQLite   = "sqlite"
+	driverPostgres = "postgres"
+
+	// The default SQLite pragmas. WAL lets other processes (status, export,
+	// watch) read while the updater writes, and NORMAL synchronous is
+	// durable in WAL mode short of a power loss, which at worst drops the
+	// last few writes. The busy timeout makes a locked database wait
+	// instead of failing at once.
+	defaultSQLiteJournalMode = "WAL"
+	defaultSQLiteSynchronous = "NORMAL"
+	defaultSQLiteBusyTimeout = 5 * time.Second
+
+	// busyRetries bounds how often a statement that still finds the
+	// database locked after the busy timeout is retried, starting after
+	// busyBackoff and doubling.
+	busyRetries = 3
+	busyBackoff = 100 * time.Millisecond
+)
+
+// sqliteJournalModes and sqliteSynchronousModes are the values SQLite
+// accepts for the journal_mode and synchronous pragmas.
+var (
+	sqliteJournalModes     = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	sqliteSynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// sqliteOptions are the pragmas initDB sets on every connection.
+type sqliteOptions struct {
+	journalMode string
+	synchronous string
+	busyTimeout time.Duration
+}
+
+var defaultSQLiteOptions = sqliteOptions{
+	journalMode: defaultSQLiteJournalMode,
+	synchronous: defaultSQLiteSynchronous,
+	busyTimeout: defaultSQLiteBusyTimeout,
+}
+
+// dsn returns the go-sqlite3 DSN query string applying o.
+func (o sqliteOptions) dsn() string {
+	return fmt.Sprintf("?_journal_mode=%s&_synchronous=%s&_busy_timeout=%d",
+		o.journalMode, o.synchronous, o.busyTimeout.Milliseconds())
+}
+
+// isBusy reports whether err is SQLite's SQLITE_BUSY or SQLITE_LOCKED, as
+// seen on networked volumes where locks are slow to be released.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// retryBusy runs fn, running it again while it fails because the database
+// is locked, up to busyRetries times.
+func retryBusy(fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt <= busyRetries && isBusy(err); attempt++ {
+		delay := busyBackoff << (attempt - 1)
+		slog.Warn("Database is locked, retrying", "attempt", attempt, "delay", delay.String(), "error", err)
+		time.Sleep(delay)
+		err = fn()
+	}
+	return err
+}
+
+// dialect captures the SQL that differs between the supported databases.
+// Queries are written with ? placeholders and rebound per dialect.
+type dialect struct {
+	driver          string
+	idColumn        string
+	timestampColumn string
+	// olderThan is a predicate true for rows whose updated_at is more than
+	// the bound argument, as returned by ageArg, in the past.
+	olderThan string
+	ageArg    func(time.Duration) any
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+	// tableExists counts the tables named by its argument.
+	tableExists string
+}
+
+var sqliteDialect = dialect{
+	driver:          "sqlite3",
+	idColumn:        "INTEGER PRIMARY KEY",
+	timestampColumn: "TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	olderThan:       "datetime(updated_at) < datetime('now', ?)",
+	ageArg: func(d time.Duration) any {
+		return fmt.Sprintf("-%d seconds", int64(d.Seconds()))
+	},
+	tableExists: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+}
+
+var postgresDialect = dialect{
+	driver:          "postgres",
+	idColumn:        "BIGSERIAL PRIMARY KEY",
+	timestampColumn: "TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP",
+	olderThan:       "updated_at < NOW() - ? * INTERVAL '1 second'",
+	ageArg: func(d time.Duration) any {
+		return int64(d.Seconds())
+	},
+	numbered:    true,
+	tableExists: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+}
+
+// rebind rewrites the ? placeholders in query for the dialect.
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// openStore connects to the database selected by cfg.DBDriver. The returned
+// *sql.DB is owned by the caller, who must close it.
+func openStore(cfg *Config) (*sqlStore, *sql.DB, error) {
+	var (
+		db  *sql.DB
+		d   dialect
+		err error
+	)
+
+	switch cfg.DBDriver {
+	case driverSQLite:
+		d = sqliteDialect
+		db, err = initDB(cfg.DBPath, cfg.sqliteOptions())
+	case driverPostgres:
+		d = postgresDialect
+		db, err = initPostgres(cfg.DatabaseURL)
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", cfg.DBDriver)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	store := &sqlStore{db: db, dialect: d, maxRows: cfg.HistoryMaxRows, maxAge: cfg.HistoryMaxAge}
+	return store, db, nil
+}
+
+// openStoreReadOnly connects to the database like openStore, for commands
+// that only inspect it. The schema is not migrated and SQLite is opened
+// read-only, so they never modify a live database; an outdated schema is
+// an error, as the updater migrates it when it starts.
+func openStoreReadOnly(cfg *Config) (*sqlStore, *sql.DB, error) {
+	var (
+		db  *sql.DB
+		d   dialect
+		err error
+	)
+
+	switch cfg.DBDriver {
+	case driverSQLite:
+		d = sqliteDialect
+		dsn := fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", cfg.DBPath, cfg.SQLiteBusyTimeout.Milliseconds())
+		db, err = sql.Open(d.driver, dsn)
+	case driverPostgres:
+		d = postgresDialect
+		db, err = sql.Open(d.driver, cfg.DatabaseURL)
+	default:
+		return nil, nil, fmt.Errorf("unknown database driver %q", cfg.DBDriver)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	version, err := appliedSchemaVersion(db, d)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if version < len(migrations) {
+		db.Close()
+		return nil, nil, fmt.Errorf("database schema version %d is older than this release (%d), start the updater to migrate it", version, len(migrations))
+	}
+
+	return &sqlStore{db: db, dialect: d}, db, nil
+}
+
+// initDB opens the SQLite database at path with the pragmas in opts,
+// creating it and any missing parent directories, and migrates its schema.
+func initDB(path string, opts sqliteOptions) (*sql.DB, error) {
+	slog.Info("Initializing SQLite database", "path", path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %v", err)
+	}
+
+	db, err := sql.Open(sqliteDialect.driver, path+opts.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	// SQLite allows a single writer; one shared connection serializes
+	// writes in the process instead of having them contend for the lock.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db, sqliteDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	slog.Info("Database initialized successfully")
+	return db, nil
+}
+
+// initPostgres connects to the Postgres database at url and migrates its
+// schema.
+func initPostgres(url string) (*sql.DB, error) {
+	slog.Info("Initializing Postgres database")
+
+	db, err := sql.Open(postgresDialect.driver, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	if err := migrate(db, postgresDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	slog.Info("Database initialized successfully")
+	return db, nil
+}
+
+// Store persists the history of detected IPs.
+type Store interface {
+	// LatestIP returns the most recently stored IP, or sql.ErrNoRows if
+	// the store is empty.
+	LatestIP() (string, error)
+	// Insert records the IP in record as the newest entry; its UpdatedAt
+	// is ignored.
+	Insert(record ipRecord) error
+	// History returns up to limit stored IPs, newest first.
+	History(limit int) ([]ipRecord, error)
+	// RecordEvent appends an entry to the audit log.
+	RecordEvent(event eventRecord) error
+	// Events returns up to limit audit log entries, newest first.
+	Events(limit int) ([]eventRecord, error)
+	// LastEvent returns the newest audit log entry of type kind, or
+	// sql.ErrNoRows if there is none.
+	LastEvent(kind string) (eventRecord, error)
+	// SetMeta stores value under key in the meta table, replacing any
+	// previous value.
+	SetMeta(key, value string) error
+	// Meta returns the value stored under key, or sql.ErrNoRows if there
+	// is none.
+	Meta(key string) (string, error)
+}
+
+// metaLastRestart is the meta key holding the time of the last successful
+// restart of Charon, in RFC 3339 format.
+const metaLastRestart = "last_restart"
+
+// lastRestart returns when Charon was last restarted successfully, or
+// sql.ErrNoRows if it never was.
+func lastRestart(store Store) (time.Time, error) {
+	value, err := store.Meta(metaLastRestart)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Audit log event types.
+const (
+	eventTypeIPChange       = "ip_change"
+	eventTypeEnvResync      = "env_resync"
+	eventTypeRestartSuccess = "restart_success"
+	eventTypeRestartFailure = "restart_failure"
+)
+
+// eventRecord is a row of the events audit log.
+type eventRecord struct {
+	Type      string    `json:"type"`
+	IP        string    `json:"ip"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sqlStore keeps IP history in the ip_store table of a SQL database,
+// pruning it to at most maxRows entries and dropping entries older than
+// maxAge on each insert.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+	maxRows int
+	maxAge  time.Duration
+}
+
+func (s *sqlStore) LatestIP() (ip string, err error) {
+	err = retryBusy(func() error {
+		return s.db.QueryRow("SELECT ip FROM ip_store ORDER BY updated_at DESC, id DESC LIMIT 1").Scan(&ip)
+	})
+	return ip, err
+}
+
+// Insert prunes history in the same transaction as the insert. A zero
+// maxRows or maxAge disables the respective limit; the newest row is never
+// pruned.
+func (s *sqlStore) Insert(record ipRecord) error {
+	return retryBusy(func() error { return s.insert(record) })
+}
+
+func (s *sqlStore) insert(record ipRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Records without provider details, e.g. seeded by hand, leave the
+	// columns NULL, as do records without a previous IP or reason.
+	var latency sql.NullInt64
+	if record.Provider != "" {
+		latency = sql.NullInt64{Int64: record.LatencyMS, Valid: true}
+	}
+	if _, err := tx.Exec(s.dialect.rebind("INSERT INTO ip_store (ip, provider, latency_ms, previous_ip, reason) VALUES (?, ?, ?, ?, ?)"),
+		record.IP, nullString(record.Provider), latency, nullString(record.PreviousIP), nullString(record.Reason)); err != nil {
+		return err
+	}
+
+	var removed int64
+	if s.maxRows > 0 {
+		res, err := tx.Exec(s.dialect.rebind(`
+		DELETE FROM ip_store WHERE id NOT IN (
+			SELECT id FROM ip_store ORDER BY updated_at DESC, id DESC LIMIT ?
+		)`), s.maxRows)
+		if err != nil {
+			return fmt.Errorf("failed to prune history by row count: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		removed += n
+	}
+
+	if s.maxAge > 0 {
+		res, err := tx.Exec(s.dialect.rebind(`
+		DELETE FROM ip_store
+		WHERE `+s.dialect.olderThan+`
+		AND id != (SELECT id FROM ip_store ORDER BY updated_at DESC, id DESC LIMIT 1)`),
+			s.dialect.ageArg(s.maxAge))
+		if err != nil {
+			return fmt.Errorf("failed to prune history by age: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		removed += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if removed > 0 {
+		slog.Info("Pruned old IP history", "rows", removed)
+	}
+	return nil
+}
+
+// Reasons an IP is stored, recorded with it in ip_store.
+const (
+	// reasonColdStart is the first IP stored in an empty database.
+	reasonColdStart = "cold_start"
+	// reasonIPChange is a confirmed change from the previously stored IP.
+	reasonIPChange = "ip_change"
+)
+
+// ipRecord is a row of ip_store. Provider and LatencyMS describe the fetch
+// that detected the IP, PreviousIP and Reason why it was stored; all are
+// empty for rows stored without them.
+type ipRecord struct {
+	IP         string    `json:"ip"`
+	PreviousIP string    `json:"previous_ip,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Provider   string    `json:"provider,omitempty"`
+	LatencyMS  int64     `json:"latency_ms,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ipRecordColumns are the ip_store columns scanIPRecord reads, in order.
+const ipRecordColumns = "ip, previous_ip, reason, provider, latency_ms, updated_at"
+
+// scanIPRecord reads a row selected with ipRecordColumns.
+func scanIPRecord(rows *sql.Rows) (ipRecord, error) {
+	var (
+		record                     ipRecord
+		previous, reason, provider sql.NullString
+		latency                    sql.NullInt64
+	)
+	if err := rows.Scan(&record.IP, &previous, &reason, &provider, &latency, &record.UpdatedAt); err != nil {
+		return ipRecord{}, err
+	}
+	record.PreviousIP, record.Reason = previous.String, reason.String
+	record.Provider, record.LatencyMS = provider.String, latency.Int64
+	return record, nil
+}
+
+// nullString maps the empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (s *sqlStore) History(limit int) (records []ipRecord, err error) {
+	err = retryBusy(func() error {
+		records, err = s.history(limit)
+		return err
+	})
+	return records, err
+}
+
+func (s *sqlStore) history(limit int) ([]ipRecord, error) {
+	rows, err := s.db.Query(s.dialect.rebind("SELECT "+ipRecordColumns+" FROM ip_store ORDER BY updated_at DESC, id DESC LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []ipRecord{}
+	for rows.Next() {
+		record, err := scanIPRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *sqlStore) RecordEvent(event eventRecord) error {
+	return retryBusy(func() error {
+		_, err := s.db.Exec(s.dialect.rebind("INSERT INTO events (type, ip, error) VALUES (?, ?, ?)"), event.Type, event.IP, event.Error)
+		return err
+	})
+}
+
+func (s *sqlStore) Events(limit int) (events []eventRecord, err error) {
+	err = retryBusy(func() error {
+		events, err = s.events(limit)
+		return err
+	})
+	return events, err
+}
+
+func (s *sqlStore) events(limit int) ([]eventRecord, error) {
+	rows, err := s.db.Query(s.dialect.rebind("SELECT type, ip, error, created_at FROM events ORDER BY created_at DESC, id DESC LIMIT ?"), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []eventRecord{}
+	for rows.Next() {
+		var event eventRecord
+		if err := rows.Scan(&event.Type, &event.IP, &event.Error, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *sqlStore) LastEvent(kind string) (eventRecord, error) {
+	event := eventRecord{Type: kind}
+	err := retryBusy(func() error {
+		return s.db.QueryRow(s.dialect.rebind("SELECT ip, error, created_at FROM events WHERE type = ? ORDER BY created_at DESC, id DESC LIMIT 1"), kind).
+			Scan(&event.IP, &event.Error, &event.CreatedAt)
+	})
+	return event, err
+}
+
+func (s *sqlStore) SetMeta(key, value string) error {
+	return retryBusy(func() error {
+		_, err := s.db.Exec(s.dialect.rebind("INSERT INTO meta (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value"), key, value)
+		return err
+	})
+}
+
+func (s *sqlStore) Meta(key string) (value string, err error) {
+	err = retryBusy(func() error {
+		return s.db.QueryRow(s.dialect.rebind("SELECT value FROM meta WHERE key = ?"), key).Scan(&value)
+	})
+	return value, err
+}
+
+// dryRunStore reads from the wrapped Store but only logs writes.
+type dryRunStore struct {
+	Store
+}
+
+func (s dryRunStore) Insert(record ipRecord) error {
+	slog.Info("Dry run: would store IP in database", "ip", record.IP, "provider", record.Provider)
+	return nil
+}
+
+func (s dryRunStore) RecordEvent(event eventRecord) error {
+	slog.Info("Dry run: would record event", "type", event.Type, "ip", event.IP)
+	return nil
+}
+
+func (s dryRunStore) SetMeta(key, value string) error {
+	slog.Info("Dry run: would store metadata", "key", key, "value", value)
+	return nil
+}
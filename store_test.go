@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := initDB(filepath.Join(t.TempDir(), "ip_store.db"), defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM ip_store").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStoreIPPrunesByRowCount(t *testing.T) {
+	db := newTestDB(t)
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"} {
+		if err := (&sqlStore{dialect: sqliteDialect, db: db, maxRows: 2}).Insert(ipRecord{IP: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := (&sqlStore{dialect: sqliteDialect, db: db}).History(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].IP != "4.4.4.4" || records[1].IP != "3.3.3.3" {
+		t.Fatalf("unexpected history after pruning: %+v", records)
+	}
+}
+
+func TestStoreIPPrunesByAge(t *testing.T) {
+	db := newTestDB(t)
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	if _, err := db.Exec("INSERT INTO ip_store (ip, updated_at) VALUES (?, ?)", "1.1.1.1", old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&sqlStore{dialect: sqliteDialect, db: db, maxAge: 24 * time.Hour}).Insert(ipRecord{IP: "2.2.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := countRows(t, db); n != 1 {
+		t.Fatalf("expected 1 row after age pruning, got %d", n)
+	}
+}
+
+func TestStoreIPKeepsNewestRowRegardlessOfAge(t *testing.T) {
+	db := newTestDB(t)
+	if err := (&sqlStore{dialect: sqliteDialect, db: db, maxAge: time.Nanosecond}).Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, db); n != 1 {
+		t.Fatalf("expected the newest row to survive, got %d rows", n)
+	}
+}
+
+func TestInitDBCreatesParentDirsAndUsesWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "dir", "ip_store.db")
+	db, err := initDB(path, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	defer db.Close()
+
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+}
+
+func TestInitDBAppliesSQLiteOptions(t *testing.T) {
+	opts := sqliteOptions{journalMode: "DELETE", synchronous: "FULL", busyTimeout: 2 * time.Second}
+	db, err := initDB(filepath.Join(t.TempDir(), "ip_store.db"), opts)
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	defer db.Close()
+
+	var mode string
+	var synchronous, timeout int
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA synchronous").Scan(&synchronous); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	// synchronous reads back as a number, where FULL is 2.
+	if mode != "delete" || synchronous != 2 || timeout != 2000 {
+		t.Errorf("pragmas = %s, %d, %d; want delete, 2, 2000", mode, synchronous, timeout)
+	}
+	if n := db.Stats().MaxOpenConnections; n != 1 {
+		t.Errorf("MaxOpenConnections = %d, want 1", n)
+	}
+}
+
+func TestOpenStoreReadOnly(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "ip_store.db")
+	db, err := initDB(cfg.DBPath, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&sqlStore{dialect: sqliteDialect, db: db}).Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	store, db, err := openStoreReadOnly(cfg)
+	if err != nil {
+		t.Fatalf("openStoreReadOnly: %v", err)
+	}
+	defer db.Close()
+	if ip, err := store.LatestIP(); err != nil || ip != "1.1.1.1" {
+		t.Errorf("LatestIP = %q, %v", ip, err)
+	}
+	if err := store.Insert(ipRecord{IP: "2.2.2.2"}); err == nil {
+		t.Error("Insert succeeded on a read-only store")
+	}
+
+	// A database the updater has not migrated, including one from before
+	// schema versioning, is reported, not migrated.
+	for name, schema := range map[string]string{
+		"unmigrated": "CREATE TABLE schema_version (version INTEGER PRIMARY KEY, applied_at DATETIME)",
+		"baseline":   "CREATE TABLE ip_store (id INTEGER PRIMARY KEY, ip TEXT NOT NULL, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)",
+	} {
+		cfg.DBPath = filepath.Join(t.TempDir(), name+".db")
+		old, err := sql.Open(sqliteDialect.driver, cfg.DBPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer old.Close()
+		if _, err := old.Exec(schema); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := openStoreReadOnly(cfg); err == nil || !strings.Contains(err.Error(), "start the updater") {
+			t.Errorf("openStoreReadOnly on a %s database: err = %v", name, err)
+		}
+		if version, err := appliedSchemaVersion(old, sqliteDialect); err != nil || version != 0 {
+			t.Errorf("%s schema version = %d, %v; want the database left alone", name, version, err)
+		}
+	}
+}
+
+func TestDialectRebind(t *testing.T) {
+	query := "SELECT ip FROM ip_store WHERE ip = ? LIMIT ?"
+
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("sqlite rebind = %q, want it unchanged", got)
+	}
+	want := "SELECT ip FROM ip_store WHERE ip = $1 LIMIT $2"
+	if got := postgresDialect.rebind(query); got != want {
+		t.Errorf("postgres rebind = %q, want %q", got, want)
+	}
+}
+
+func TestStoreRecordsProviderAndLatency(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t)}
+	if err := store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Insert(ipRecord{IP: "2.2.2.2", Provider: ipifyAPI, LatencyMS: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.History(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("history = %+v, want 2 rows", records)
+	}
+	if records[0].Provider != ipifyAPI || records[0].LatencyMS != 42 {
+		t.Errorf("newest row = %+v, want provider and latency recorded", records[0])
+	}
+	if records[1].Provider != "" || records[1].LatencyMS != 0 {
+		t.Errorf("row without fetch details = %+v, want them empty", records[1])
+	}
+}
+
+func TestStoreLastEvent(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows}
+
+	if _, err := store.LastEvent(eventTypeRestartSuccess); err != sql.ErrNoRows {
+		t.Fatalf("LastEvent on an empty log: err = %v, want sql.ErrNoRows", err)
+	}
+
+	for _, event := range []eventRecord{
+		{Type: eventTypeRestartSuccess, IP: "1.1.1.1"},
+		{Type: eventTypeRestartSuccess, IP: "2.2.2.2"},
+		{Type: eventTypeIPChange, IP: "3.3.3.3"},
+	} {
+		if err := store.RecordEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := store.LastEvent(eventTypeRestartSuccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IP != "2.2.2.2" || got.CreatedAt.IsZero() {
+		t.Errorf("LastEvent = %+v, want the second restart", got)
+	}
+}
+
+func TestStoreMeta(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows}
+
+	if _, err := store.Meta(metaLastRestart); err != sql.ErrNoRows {
+		t.Fatalf("Meta of an unset key: err = %v, want sql.ErrNoRows", err)
+	}
+	for _, value := range []string{"first", "second"} {
+		if err := store.SetMeta(metaLastRestart, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := store.Meta(metaLastRestart); err != nil || got != "second" {
+		t.Errorf("Meta = %q, %v; want the value replaced", got, err)
+	}
+}
+
+func TestRetryBusy(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+
+	calls := 0
+	err := retryBusy(func() error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("failed to prune history by age: %w", busy)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retryBusy = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = retryBusy(func() error {
+		calls++
+		return busy
+	})
+	if !isBusy(err) || calls != busyRetries+1 {
+		t.Errorf("retryBusy = %v after %d calls, want the busy error after %d", err, calls, busyRetries+1)
+	}
+
+	calls = 0
+	err = retryBusy(func() error {
+		calls++
+		return sql.ErrNoRows
+	})
+	if err != sql.ErrNoRows || calls != 1 {
+		t.Errorf("retryBusy = %v after %d calls, want other errors returned at once", err, calls)
+	}
+}
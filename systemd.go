@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// systemdNotifier speaks the sd_notify protocol to the service manager, for
+// units with Type=notify and, optionally, WatchdogSec. A nil systemdNotifier
+// is valid and does nothing, as when not running under systemd.
+type systemdNotifier struct {
+	socket string
+	// watchdogInterval is how often systemd expects a WATCHDOG=1 ping, or
+	// zero if the watchdog is not enabled for this process.
+	watchdogInterval time.Duration
+	ready            sync.Once
+}
+
+// newSystemdNotifier reads NOTIFY_SOCKET and WATCHDOG_USEC, returning nil
+// if the process was not started by systemd with notify support.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ denotes a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	n := &systemdNotifier{socket: socket}
+
+	// WATCHDOG_PID, if set, names the process the watchdog applies to.
+	if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+		if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+			n.watchdogInterval = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+func (n *systemdNotifier) notify(state string) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("Failed to notify systemd", "state", state, "error", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("Failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// markReady tells systemd that startup finished; only the first call has
+// an effect.
+func (n *systemdNotifier) markReady() {
+	if n == nil {
+		return
+	}
+	n.ready.Do(func() {
+		n.notify("READY=1")
+		slog.Info("Notified systemd of readiness")
+	})
+}
+
+// pingWatchdog resets the systemd watchdog timer, if it is enabled.
+func (n *systemdNotifier) pingWatchdog() {
+	if n == nil || n.watchdogInterval == 0 {
+		return
+	}
+	n.notify("WATCHDOG=1")
+}
+
+// watchdogTicks returns a channel ticking at half the watchdog interval,
+// as sd_watchdog_enabled(3) recommends, and a function stopping it. The
+// channel is nil when the watchdog is disabled.
+func (n *systemdNotifier) watchdogTicks() (<-chan time.Time, func()) {
+	if n == nil || n.watchdogInterval == 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(n.watchdogInterval / 2)
+	return ticker.C, ticker.Stop
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	n := newSystemdNotifier()
+	if n == nil {
+		t.Fatal("expected a notifier with NOTIFY_SOCKET set")
+	}
+	if n.watchdogInterval != 30*time.Second {
+		t.Errorf("watchdog interval = %v, want 30s", n.watchdogInterval)
+	}
+
+	n.markReady()
+	n.markReady()
+	n.pingWatchdog()
+
+	var got []string
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		size, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		got = append(got, string(buf[:size]))
+	}
+	if len(got) != 2 || got[0] != "READY=1" || got[1] != "WATCHDOG=1" {
+		t.Errorf("messages = %q, want READY=1 once then WATCHDOG=1", got)
+	}
+}
+
+func TestSystemdNotifierDisabled(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n := newSystemdNotifier()
+	if n != nil {
+		t.Fatal("expected no notifier without NOTIFY_SOCKET")
+	}
+	// Must not panic.
+	n.markReady()
+	n.pingWatchdog()
+	if ticks, stop := n.watchdogTicks(); ticks != nil {
+		stop()
+		t.Error("expected no watchdog ticks")
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "/run/systemd/notify")
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "1")
+	if n := newSystemdNotifier(); n.watchdogInterval != 0 {
+		t.Error("watchdog enabled for another process")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInitTracingDisabledWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+	shutdown, err := initTracing(context.Background())
+	if err != nil {
+		t.Fatalf("initTracing: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+}
+
+func TestCheckSpans(t *testing.T) {
+	// The package tracer delegates to the first provider installed
+	// globally, so this must be the only test that installs one.
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	h := newCheckHarness(t, envKey+"=1.1.1.1\n")
+	if err := h.store.Insert(ipRecord{IP: "1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.run("2.2.2.2"); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	got := map[string]bool{}
+	for _, span := range spans.Ended() {
+		got[span.Name()] = true
+	}
+	for _, name := range []string{"check", "fetch_ip", "db.latest_ip", "env.write", "restart", "db.insert"} {
+		if !got[name] {
+			t.Errorf("missing span %q, got %v", name, got)
+		}
+	}
+}
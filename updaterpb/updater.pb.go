@@ -0,0 +1,513 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        v5.29.3
+// source: updaterpb/updater.proto
+
+package updaterpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusRequest) Reset() {
+	*x = GetStatusRequest{}
+	mi := &file_updaterpb_updater_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusRequest) ProtoMessage() {}
+
+func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{0}
+}
+
+type GetStatusResponse struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	DetectedIp string                 `protobuf:"bytes,1,opt,name=detected_ip,json=detectedIp,proto3" json:"detected_ip,omitempty"`
+	// env_ip is empty when .env has no IP or is not managed (UPDATE_MODE=dns).
+	EnvIp         string `protobuf:"bytes,2,opt,name=env_ip,json=envIp,proto3" json:"env_ip,omitempty"`
+	StoredIp      string `protobuf:"bytes,3,opt,name=stored_ip,json=storedIp,proto3" json:"stored_ip,omitempty"`
+	Synced        bool   `protobuf:"varint,4,opt,name=synced,proto3" json:"synced,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusResponse) Reset() {
+	*x = GetStatusResponse{}
+	mi := &file_updaterpb_updater_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusResponse) ProtoMessage() {}
+
+func (x *GetStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusResponse.ProtoReflect.Descriptor instead.
+func (*GetStatusResponse) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetStatusResponse) GetDetectedIp() string {
+	if x != nil {
+		return x.DetectedIp
+	}
+	return ""
+}
+
+func (x *GetStatusResponse) GetEnvIp() string {
+	if x != nil {
+		return x.EnvIp
+	}
+	return ""
+}
+
+func (x *GetStatusResponse) GetStoredIp() string {
+	if x != nil {
+		return x.StoredIp
+	}
+	return ""
+}
+
+func (x *GetStatusResponse) GetSynced() bool {
+	if x != nil {
+		return x.Synced
+	}
+	return false
+}
+
+type GetHistoryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// limit bounds the number of records; 0 means the HTTP API's default.
+	Limit         int32 `protobuf:"varint,1,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetHistoryRequest) Reset() {
+	*x = GetHistoryRequest{}
+	mi := &file_updaterpb_updater_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetHistoryRequest) ProtoMessage() {}
+
+func (x *GetHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetHistoryRequest.ProtoReflect.Descriptor instead.
+func (*GetHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetHistoryRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type IPRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Ip    string                 `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	// provider and latency_ms are empty for rows stored without them.
+	Provider  string                 `protobuf:"bytes,2,opt,name=provider,proto3" json:"provider,omitempty"`
+	LatencyMs int64                  `protobuf:"varint,3,opt,name=latency_ms,json=latencyMs,proto3" json:"latency_ms,omitempty"`
+	UpdatedAt *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// previous_ip and reason are empty for rows stored without them.
+	PreviousIp    string `protobuf:"bytes,5,opt,name=previous_ip,json=previousIp,proto3" json:"previous_ip,omitempty"`
+	Reason        string `protobuf:"bytes,6,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IPRecord) Reset() {
+	*x = IPRecord{}
+	mi := &file_updaterpb_updater_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IPRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IPRecord) ProtoMessage() {}
+
+func (x *IPRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IPRecord.ProtoReflect.Descriptor instead.
+func (*IPRecord) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IPRecord) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *IPRecord) GetProvider() string {
+	if x != nil {
+		return x.Provider
+	}
+	return ""
+}
+
+func (x *IPRecord) GetLatencyMs() int64 {
+	if x != nil {
+		return x.LatencyMs
+	}
+	return 0
+}
+
+func (x *IPRecord) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *IPRecord) GetPreviousIp() string {
+	if x != nil {
+		return x.PreviousIp
+	}
+	return ""
+}
+
+func (x *IPRecord) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type GetHistoryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*IPRecord            `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetHistoryResponse) Reset() {
+	*x = GetHistoryResponse{}
+	mi := &file_updaterpb_updater_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetHistoryResponse) ProtoMessage() {}
+
+func (x *GetHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetHistoryResponse.ProtoReflect.Descriptor instead.
+func (*GetHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetHistoryResponse) GetRecords() []*IPRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type ForceCheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForceCheckRequest) Reset() {
+	*x = ForceCheckRequest{}
+	mi := &file_updaterpb_updater_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForceCheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForceCheckRequest) ProtoMessage() {}
+
+func (x *ForceCheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForceCheckRequest.ProtoReflect.Descriptor instead.
+func (*ForceCheckRequest) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{5}
+}
+
+type ForceCheckResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// stored_ip is the IP in the database once the check completed.
+	StoredIp      string `protobuf:"bytes,1,opt,name=stored_ip,json=storedIp,proto3" json:"stored_ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ForceCheckResponse) Reset() {
+	*x = ForceCheckResponse{}
+	mi := &file_updaterpb_updater_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ForceCheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ForceCheckResponse) ProtoMessage() {}
+
+func (x *ForceCheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_updaterpb_updater_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ForceCheckResponse.ProtoReflect.Descriptor instead.
+func (*ForceCheckResponse) Descriptor() ([]byte, []int) {
+	return file_updaterpb_updater_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ForceCheckResponse) GetStoredIp() string {
+	if x != nil {
+		return x.StoredIp
+	}
+	return ""
+}
+
+var File_updaterpb_updater_proto protoreflect.FileDescriptor
+
+var file_updaterpb_updater_proto_rawDesc = string([]byte{
+	0x0a, 0x17, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x70, 0x62, 0x2f, 0x75, 0x70, 0x64, 0x61,
+	0x74, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x11, 0x6f, 0x62, 0x6f, 0x6c, 0x2e,
+	0x69, 0x70, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x12, 0x0a,
+	0x10, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x22, 0x80, 0x01, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x64, 0x65, 0x74, 0x65, 0x63,
+	0x74, 0x65, 0x64, 0x5f, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x64, 0x65,
+	0x74, 0x65, 0x63, 0x74, 0x65, 0x64, 0x49, 0x70, 0x12, 0x15, 0x0a, 0x06, 0x65, 0x6e, 0x76, 0x5f,
+	0x69, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x6e, 0x76, 0x49, 0x70, 0x12,
+	0x1b, 0x0a, 0x09, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x5f, 0x69, 0x70, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x49, 0x70, 0x12, 0x16, 0x0a, 0x06,
+	0x73, 0x79, 0x6e, 0x63, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x73, 0x79,
+	0x6e, 0x63, 0x65, 0x64, 0x22, 0x29, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x48, 0x69, 0x73, 0x74, 0x6f,
+	0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d,
+	0x69, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x22,
+	0xc9, 0x01, 0x0a, 0x08, 0x49, 0x50, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x0e, 0x0a, 0x02,
+	0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x70, 0x12, 0x1a, 0x0a, 0x08,
+	0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x61, 0x74, 0x65,
+	0x6e, 0x63, 0x79, 0x5f, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x6c, 0x61,
+	0x74, 0x65, 0x6e, 0x63, 0x79, 0x4d, 0x73, 0x12, 0x39, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74,
+	0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64,
+	0x41, 0x74, 0x12, 0x1f, 0x0a, 0x0b, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75, 0x73, 0x5f, 0x69,
+	0x70, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75,
+	0x73, 0x49, 0x70, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x4b, 0x0a, 0x12, 0x47,
+	0x65, 0x74, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x35, 0x0a, 0x07, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x6f, 0x62, 0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70, 0x64, 0x61,
+	0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x50, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52,
+	0x07, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x22, 0x13, 0x0a, 0x11, 0x46, 0x6f, 0x72, 0x63,
+	0x65, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x31, 0x0a,
+	0x12, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x5f, 0x69, 0x70,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x49, 0x70,
+	0x32, 0x99, 0x02, 0x0a, 0x09, 0x49, 0x50, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x12, 0x56,
+	0x0a, 0x09, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x23, 0x2e, 0x6f, 0x62,
+	0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x24, 0x2e, 0x6f, 0x62, 0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65,
+	0x72, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x59, 0x0a, 0x0a, 0x47, 0x65, 0x74, 0x48, 0x69, 0x73,
+	0x74, 0x6f, 0x72, 0x79, 0x12, 0x24, 0x2e, 0x6f, 0x62, 0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x48, 0x69, 0x73, 0x74,
+	0x6f, 0x72, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x25, 0x2e, 0x6f, 0x62, 0x6f,
+	0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x47,
+	0x65, 0x74, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x59, 0x0a, 0x0a, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x12,
+	0x24, 0x2e, 0x6f, 0x62, 0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72,
+	0x2e, 0x76, 0x31, 0x2e, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x25, 0x2e, 0x6f, 0x62, 0x6f, 0x6c, 0x2e, 0x69, 0x70, 0x75,
+	0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x46, 0x6f, 0x72, 0x63, 0x65, 0x43,
+	0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x2d, 0x5a, 0x2b,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x63, 0x72, 0x69, 0x73, 0x6f,
+	0x67, 0x2f, 0x6f, 0x62, 0x6f, 0x6c, 0x2d, 0x69, 0x70, 0x2d, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65,
+	0x72, 0x2f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x72, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+})
+
+var (
+	file_updaterpb_updater_proto_rawDescOnce sync.Once
+	file_updaterpb_updater_proto_rawDescData []byte
+)
+
+func file_updaterpb_updater_proto_rawDescGZIP() []byte {
+	file_updaterpb_updater_proto_rawDescOnce.Do(func() {
+		file_updaterpb_updater_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_updaterpb_updater_proto_rawDesc), len(file_updaterpb_updater_proto_rawDesc)))
+	})
+	return file_updaterpb_updater_proto_rawDescData
+}
+
+var file_updaterpb_updater_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_updaterpb_updater_proto_goTypes = []any{
+	(*GetStatusRequest)(nil),      // 0: obol.ipupdater.v1.GetStatusRequest
+	(*GetStatusResponse)(nil),     // 1: obol.ipupdater.v1.GetStatusResponse
+	(*GetHistoryRequest)(nil),     // 2: obol.ipupdater.v1.GetHistoryRequest
+	(*IPRecord)(nil),              // 3: obol.ipupdater.v1.IPRecord
+	(*GetHistoryResponse)(nil),    // 4: obol.ipupdater.v1.GetHistoryResponse
+	(*ForceCheckRequest)(nil),     // 5: obol.ipupdater.v1.ForceCheckRequest
+	(*ForceCheckResponse)(nil),    // 6: obol.ipupdater.v1.ForceCheckResponse
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_updaterpb_updater_proto_depIdxs = []int32{
+	7, // 0: obol.ipupdater.v1.IPRecord.updated_at:type_name -> google.protobuf.Timestamp
+	3, // 1: obol.ipupdater.v1.GetHistoryResponse.records:type_name -> obol.ipupdater.v1.IPRecord
+	0, // 2: obol.ipupdater.v1.IPUpdater.GetStatus:input_type -> obol.ipupdater.v1.GetStatusRequest
+	2, // 3: obol.ipupdater.v1.IPUpdater.GetHistory:input_type -> obol.ipupdater.v1.GetHistoryRequest
+	5, // 4: obol.ipupdater.v1.IPUpdater.ForceCheck:input_type -> obol.ipupdater.v1.ForceCheckRequest
+	1, // 5: obol.ipupdater.v1.IPUpdater.GetStatus:output_type -> obol.ipupdater.v1.GetStatusResponse
+	4, // 6: obol.ipupdater.v1.IPUpdater.GetHistory:output_type -> obol.ipupdater.v1.GetHistoryResponse
+	6, // 7: obol.ipupdater.v1.IPUpdater.ForceCheck:output_type -> obol.ipupdater.v1.ForceCheckResponse
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_updaterpb_updater_proto_init() }
+func file_updaterpb_updater_proto_init() {
+	if File_updaterpb_updater_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_updaterpb_updater_proto_rawDesc), len(file_updaterpb_updater_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_updaterpb_updater_proto_goTypes,
+		DependencyIndexes: file_updaterpb_updater_proto_depIdxs,
+		MessageInfos:      file_updaterpb_updater_proto_msgTypes,
+	}.Build()
+	File_updaterpb_updater_proto = out.File
+	file_updaterpb_updater_proto_goTypes = nil
+	file_updaterpb_updater_proto_depIdxs = nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// validation is the outcome of one --validate-config check. A non-empty
+// skipped explains why the check did not apply.
+type validation struct {
+	name    string
+	detail  string
+	err     error
+	skipped string
+}
+
+// validateSetup checks that the configuration can be acted upon: the .env
+// file, restart backend, database and hooks are all usable. It makes no
+// network calls and changes nothing on disk.
+func validateSetup(cfg *Config) []validation {
+	results := []validation{
+		{name: "config", detail: fmt.Sprintf("restart backend %s, database %s, update mode %s", cfg.RestartBackend, cfg.DBDriver, cfg.UpdateMode)},
+		validateEnvFile(cfg),
+		validateRestartBackend(cfg),
+		validateDatabase(cfg),
+	}
+
+	hooks := []struct{ name, path string }{
+		{"pre-update hook", cfg.PreUpdateHook},
+		{"post-update hook", cfg.PostUpdateHook},
+	}
+	for _, hook := range hooks {
+		if hook.path != "" {
+			results = append(results, validateExecutable(hook.name, hook.path))
+		}
+	}
+	return results
+}
+
+func validateEnvFile(cfg *Config) validation {
+	v := validation{name: ".env"}
+	if !cfg.updatesEnv() {
+		v.skipped = "not managed with UPDATE_MODE=dns"
+		return v
+	}
+
+	if err := checkEnvFile(cfg.EnvFile); err != nil {
+		v.err = err
+		return v
+	}
+	f, err := os.Open(cfg.EnvFile)
+	switch {
+	case os.IsNotExist(err):
+		v.detail = fmt.Sprintf("%s missing, it will be created on the first update", cfg.EnvFile)
+	case err != nil:
+		v.err = err
+	default:
+		f.Close()
+		v.detail = fmt.Sprintf("%s readable", cfg.EnvFile)
+	}
+	return v
+}
+
+func validateRestartBackend(cfg *Config) validation {
+	v := validation{name: "restart backend"}
+
+	var binary string
+	switch cfg.RestartBackend {
+	case backendCompose:
+		binary = "docker"
+	case backendDocker, backendPodman:
+		binary = cfg.RestartBackend
+	case backendCommand:
+		binary = cfg.RestartCommand[0]
+	case backendDockerAPI:
+		return validateDockerSocket()
+	case backendKubernetes:
+		if _, err := newKubernetesRestarter(cfg); err != nil {
+			v.err = err
+			return v
+		}
+		v.skipped = "the Kubernetes API is not contacted"
+		return v
+	case backendNomad:
+		if _, err := newNomadRestarter(cfg); err != nil {
+			v.err = err
+			return v
+		}
+		v.skipped = "the Nomad API is not contacted"
+		return v
+	default:
+		v.err = fmt.Errorf("unknown restart backend %q", cfg.RestartBackend)
+		return v
+	}
+
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		v.err = err
+		return v
+	}
+	v.detail = path
+	return v
+}
+
+// validateDockerSocket checks that the local Docker daemon socket accepts
+// connections. A daemon reached over TCP is not contacted.
+func validateDockerSocket() validation {
+	v := validation{name: "restart backend"}
+
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = client.DefaultDockerHost
+	}
+	socket, ok := strings.CutPrefix(host, "unix://")
+	if !ok {
+		v.skipped = fmt.Sprintf("remote Docker daemon %s is not contacted", host)
+		return v
+	}
+
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		v.err = fmt.Errorf("Docker socket unreachable: %v", err)
+		return v
+	}
+	conn.Close()
+	v.detail = socket
+	return v
+}
+
+func validateDatabase(cfg *Config) validation {
+	v := validation{name: "database"}
+
+	if cfg.DBDriver == driverPostgres {
+		if _, err := url.Parse(cfg.DatabaseURL); err != nil {
+			v.err = fmt.Errorf("invalid DATABASE_URL: %v", err)
+			return v
+		}
+		v.skipped = "Postgres connectivity is not checked"
+		return v
+	}
+
+	if _, err := os.Stat(cfg.DBPath); os.IsNotExist(err) {
+		v.detail = fmt.Sprintf("%s missing, it will be created", cfg.DBPath)
+		if info, err := os.Stat(filepath.Dir(cfg.DBPath)); err == nil && !info.IsDir() {
+			v.err = fmt.Errorf("%s is not a directory", filepath.Dir(cfg.DBPath))
+		}
+		return v
+	}
+
+	// Read-only, so validating never creates or modifies the database.
+	db, err := sql.Open(sqliteDialect.driver, "file:"+cfg.DBPath+"?mode=ro")
+	if err != nil {
+		v.err = err
+		return v
+	}
+	defer db.Close()
+
+	version, err := appliedSchemaVersion(db, sqliteDialect)
+	if err != nil {
+		v.err = err
+		return v
+	}
+	if version > len(migrations) {
+		v.err = fmt.Errorf("schema version %d is newer than this release supports (%d)", version, len(migrations))
+		return v
+	}
+	v.detail = fmt.Sprintf("%s, schema version %d", cfg.DBPath, version)
+	if pending := len(migrations) - version; pending > 0 {
+		v.detail += fmt.Sprintf(", %d migration(s) pending on the next start", pending)
+	}
+	return v
+}
+
+func validateExecutable(name, path string) validation {
+	v := validation{name: name, detail: path}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		v.err = err
+	case info.Mode()&0111 == 0:
+		v.err = fmt.Errorf("%s is not executable", path)
+	}
+	return v
+}
+
+// runValidateConfig implements --validate-config, writing a report of the
+// checks to w and failing if any of them did.
+func runValidateConfig(w io.Writer, cfg *Config) error {
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, v := range validateSetup(cfg) {
+		switch {
+		case v.err != nil:
+			failed++
+			fmt.Fprintf(tw, "FAIL\t%s\t%v\n", v.name, v.err)
+		case v.skipped != "":
+			fmt.Fprintf(tw, "skip\t%s\t%s\n", v.name, v.skipped)
+		default:
+			fmt.Fprintf(tw, "ok\t%s\t%s\n", v.name, v.detail)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
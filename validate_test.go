@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSetup(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	dbPath := filepath.Join(dir, "ip_store.db")
+	db, err := initDB(dbPath, defaultSQLiteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	hook := filepath.Join(dir, "hook.sh")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	cfg.DBPath = dbPath
+	cfg.RestartBackend = backendCommand
+	cfg.RestartCommand = []string{"sh", "-c", "true"}
+	cfg.UpdateMode = updateModeEnv
+
+	var out bytes.Buffer
+	if err := runValidateConfig(&out, cfg); err != nil {
+		t.Fatalf("runValidateConfig: %v\n%s", err, out.String())
+	}
+	for _, want := range []string{"ok  .env", "will be created", "schema version"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, out.String())
+		}
+	}
+
+	cfg.RestartCommand = []string{"no-such-restart-binary"}
+	cfg.PreUpdateHook = hook
+	out.Reset()
+	err = runValidateConfig(&out, cfg)
+	if err == nil || !strings.Contains(err.Error(), "2 check(s) failed") {
+		t.Errorf("err = %v, want the missing binary and non-executable hook reported\n%s", err, out.String())
+	}
+}
+
+func TestValidateSetupDoesNotCreateDatabase(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "missing", "ip_store.db")
+
+	if v := validateDatabase(cfg); v.err != nil {
+		t.Fatalf("validateDatabase: %v", v.err)
+	}
+	if _, err := os.Stat(filepath.Dir(cfg.DBPath)); !os.IsNotExist(err) {
+		t.Error("validation created the database directory")
+	}
+}
+
+func TestValidateDatabaseBeforeSchemaVersioning(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "ip_store.db")
+
+	// The only table releases before schema versioning created.
+	db, err := sql.Open(sqliteDialect.driver, cfg.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE ip_store (id INTEGER PRIMARY KEY, ip TEXT NOT NULL, updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	v := validateDatabase(cfg)
+	if v.err != nil {
+		t.Fatalf("validateDatabase: %v", v.err)
+	}
+	if want := fmt.Sprintf("schema version 0, %d migration(s) pending", len(migrations)); !strings.Contains(v.detail, want) {
+		t.Errorf("detail = %q, want it to contain %q", v.detail, want)
+	}
+}
+
+func TestValidateConfigRejectsMalformedEnv(t *testing.T) {
+	for key, value := range map[string]string{
+		"CHECK_INTERVAL":   "10 seconds",
+		"HISTORY_MAX_ROWS": "1k",
+		"RESTART_ENABLED":  "maybe",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			_, err := loadConfig("")
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("loadConfig with %s=%q: err = %v, want it rejected", key, value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// watchEvents is how many of the most recent audit log entries watch shows.
+const watchEvents = 5
+
+// watchSnapshot is what the watch display shows: the stored history and
+// audit log, .env, and the running updater's view from its /status
+// endpoint if that is reachable.
+type watchSnapshot struct {
+	Stored      *ipRecord
+	EnvIP       string
+	EnvErr      error
+	LastRestart time.Time
+	Events      []eventRecord
+	StoreErr    error
+	Runtime     *runtimeStatus
+	RuntimeErr  error
+}
+
+// watchStatusURL returns the URL of the /status endpoint the API at addr
+// serves, reached over loopback if it listens on all interfaces.
+func watchStatusURL(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid API_ADDR %q: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/status", nil
+}
+
+// collectWatch reads a snapshot. Failures are kept in the snapshot, so the
+// display shows what it can.
+func collectWatch(ctx context.Context, cfg *Config, store Store, client *http.Client, statusURL string) watchSnapshot {
+	var snap watchSnapshot
+
+	records, err := store.History(1)
+	if err == nil && len(records) > 0 {
+		snap.Stored = &records[0]
+	}
+	if err == nil {
+		snap.Events, err = store.Events(watchEvents)
+	}
+	if err == nil {
+		snap.LastRestart, err = lastRestart(store)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	snap.StoreErr = err
+
+	if cfg.updatesEnv() {
+		snap.EnvIP, snap.EnvErr = getEnvIP(cfg.EnvFile, cfg.EnvKeys)
+	}
+
+	if statusURL == "" {
+		snap.RuntimeErr = fmt.Errorf("API_ADDR is not set")
+		return snap
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		snap.RuntimeErr = err
+		return snap
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		snap.RuntimeErr = err
+		return snap
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		snap.RuntimeErr = fmt.Errorf("received status code %d", resp.StatusCode)
+		return snap
+	}
+	var runtime runtimeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&runtime); err != nil {
+		snap.RuntimeErr = err
+		return snap
+	}
+	snap.Runtime = &runtime
+	return snap
+}
+
+// renderWatch draws snap as a single screen, clearing the terminal first.
+func renderWatch(w io.Writer, snap watchSnapshot, now time.Time) error {
+	orNone := func(s string) string {
+		if s == "" {
+			return "(none)"
+		}
+		return s
+	}
+	ago := func(t time.Time) string {
+		if t.IsZero() {
+			return "(never)"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), now.Sub(t).Round(time.Second))
+	}
+
+	// Move the cursor home and clear the screen.
+	fmt.Fprint(w, "\033[H\033[2J")
+	fmt.Fprintf(w, "obol-ip-updater watch, %s (Ctrl-C to quit)\n\n", now.Local().Format(time.DateTime))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if rt := snap.Runtime; rt != nil {
+		fmt.Fprintf(tw, "detected IP\t%s\n", orNone(rt.DetectedIP))
+		next := "(unknown)"
+		if rt.NextCheckAt != nil {
+			next = fmt.Sprintf("in %s", max(rt.NextCheckAt.Sub(now), 0).Round(time.Second))
+		}
+		fmt.Fprintf(tw, "next check\t%s\n", next)
+		fmt.Fprintf(tw, "uptime\t%s, %d checks\n", time.Duration(rt.UptimeSeconds)*time.Second, rt.ChecksPerformed)
+	} else {
+		fmt.Fprintf(tw, "updater\tnot reachable: %v\n", snap.RuntimeErr)
+	}
+
+	switch {
+	case snap.StoreErr != nil:
+		fmt.Fprintf(tw, "stored IP\terror: %v\n", snap.StoreErr)
+	case snap.Stored == nil:
+		fmt.Fprintf(tw, "stored IP\t(none)\n")
+	default:
+		fmt.Fprintf(tw, "stored IP\t%s\n", snap.Stored.IP)
+		change := ago(snap.Stored.UpdatedAt)
+		if snap.Stored.PreviousIP != "" {
+			change += ", from " + snap.Stored.PreviousIP
+		}
+		fmt.Fprintf(tw, "last change\t%s\n", change)
+	}
+	if snap.EnvErr != nil {
+		fmt.Fprintf(tw, ".env\terror: %v\n", snap.EnvErr)
+	} else {
+		fmt.Fprintf(tw, ".env\t%s\n", orNone(snap.EnvIP))
+	}
+	fmt.Fprintf(tw, "last restart\t%s\n", ago(snap.LastRestart))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nrecent events")
+	if len(snap.Events) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, event := range snap.Events {
+		line := fmt.Sprintf("  %s\t%s\t%s", event.CreatedAt.Local().Format(time.DateTime), event.Type, event.IP)
+		if event.Error != "" {
+			line += "\t" + event.Error
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
+
+// runWatch implements the watch subcommand, redrawing the display every
+// interval until ctx is cancelled. Like status it only reads: it skips the
+// instance lock and opens the database read-only, without migrating it.
+func runWatch(ctx context.Context, cfg *Config, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "how often to refresh the display")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be positive, got %v", *interval)
+	}
+
+	if cfg.DBDriver == driverSQLite {
+		if _, err := os.Stat(cfg.DBPath); err != nil {
+			return fmt.Errorf("no database at %s, start the updater first: %v", cfg.DBPath, err)
+		}
+	}
+	store, db, err := openStoreReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var statusURL string
+	if cfg.APIAddr != "" {
+		if statusURL, err = watchStatusURL(cfg.APIAddr); err != nil {
+			return err
+		}
+	}
+	client := &http.Client{Timeout: *interval}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		snap := collectWatch(ctx, cfg, store, client, statusURL)
+		if err := renderWatch(os.Stdout, snap, time.Now()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchStatusURL(t *testing.T) {
+	for addr, want := range map[string]string{
+		":8080":          "http://127.0.0.1:8080/status",
+		"0.0.0.0:8080":   "http://127.0.0.1:8080/status",
+		"[::]:8080":      "http://127.0.0.1:8080/status",
+		"10.0.0.5:8080":  "http://10.0.0.5:8080/status",
+		"localhost:9000": "http://localhost:9000/status",
+	} {
+		if got, err := watchStatusURL(addr); err != nil || got != want {
+			t.Errorf("watchStatusURL(%q) = %q, %v; want %q", addr, got, err, want)
+		}
+	}
+	if _, err := watchStatusURL("8080"); err == nil {
+		t.Error("watchStatusURL accepted an address without a port")
+	}
+}
+
+func TestWatchSnapshot(t *testing.T) {
+	store := &sqlStore{dialect: sqliteDialect, db: newTestDB(t), maxRows: defaultHistoryRows}
+	for _, record := range []ipRecord{
+		{IP: "1.1.1.1", Reason: reasonColdStart},
+		{IP: "2.2.2.2", PreviousIP: "1.1.1.1", Reason: reasonIPChange},
+	} {
+		if err := store.Insert(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.RecordEvent(eventRecord{Type: eventTypeRestartSuccess, IP: "2.2.2.2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restart time is stored to the second.
+	now := time.Now().Truncate(time.Second)
+	svc := &Service{cfg: &Config{}, started: now.Add(-time.Hour), store: store, env: &memoryEnvWriter{ip: "2.2.2.2"}}
+	svc.detectedIP.Store("2.2.2.2")
+	svc.nextCheck.Store(now.Add(30 * time.Second).UnixNano())
+	svc.recordRestart(now.Add(-time.Minute))
+	srv := httptest.NewServer(runtimeHandler(svc))
+	defer srv.Close()
+
+	cfg := &Config{
+		EnvFile: filepath.Join(t.TempDir(), ".env"),
+		EnvKeys: []string{envKey},
+	}
+	if err := os.WriteFile(cfg.EnvFile, []byte(envKey+"=2.2.2.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := collectWatch(context.Background(), cfg, store, srv.Client(), srv.URL)
+	var out bytes.Buffer
+	if err := renderWatch(&out, snap, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"detected IP   2.2.2.2",
+		"next check    in 30s",
+		"stored IP     2.2.2.2",
+		"from 1.1.1.1",
+		".env          2.2.2.2",
+		"(1m0s ago)",
+		eventTypeRestartSuccess,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("display missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// Without the updater running, the stored state is still shown.
+	snap = collectWatch(context.Background(), cfg, store, srv.Client(), "")
+	out.Reset()
+	if err := renderWatch(&out, snap, now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "not reachable") || !strings.Contains(out.String(), "stored IP     2.2.2.2") {
+		t.Errorf("display without the updater:\n%s", out.String())
+	}
+}
+
+func TestRuntimeHandlerReportsLoopState(t *testing.T) {
+	svc := &Service{cfg: &Config{}, started: time.Now(), store: &memoryStore{ips: []string{"1.1.1.1"}}, env: &memoryEnvWriter{ip: "1.1.1.1"}}
+	next := time.Now().Add(time.Minute)
+	svc.detectedIP.Store("1.1.1.1")
+	svc.nextCheck.Store(next.UnixNano())
+
+	rec := httptest.NewRecorder()
+	runtimeHandler(svc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if !strings.Contains(rec.Body.String(), `"detected_ip":"1.1.1.1"`) || !strings.Contains(rec.Body.String(), `"next_check_at"`) || !strings.Contains(rec.Body.String(), `"synced":true`) {
+		t.Errorf("body = %s", rec.Body)
+	}
+}